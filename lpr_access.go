@@ -0,0 +1,109 @@
+package lprlib
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// parseNetworks parses the given networks in CIDR notation ("192.168.0.0/16") or single IP addresses.
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", value)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+				bits = 8 * net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", value, err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// containsIP tells if ip is part of any of the given networks.
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remoteIP returns the IP address of addr, or nil if addr has no IP address (e.g. a unix socket).
+func remoteIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+
+	if addr == nil {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+
+	return net.ParseIP(host)
+}
+
+// hostAllowed tells if a client with the given address may connect to the daemon
+// according to the AllowedHosts and DeniedHosts.
+// Connections without an IP address (e.g. via unix sockets) are always allowed.
+func (lpr *LprDaemon) hostAllowed(addr net.Addr) bool {
+	if len(lpr.allowedNetworks) == 0 && len(lpr.deniedNetworks) == 0 {
+		return true
+	}
+
+	ip := remoteIP(addr)
+	if ip == nil {
+		return true
+	}
+
+	if containsIP(lpr.deniedNetworks, ip) {
+		return false
+	}
+
+	return len(lpr.allowedNetworks) == 0 || containsIP(lpr.allowedNetworks, ip)
+}
+
+// rejectHost closes a connection from a host, which is not allowed to connect.
+// Connections of other protocols than LPR are closed without a negative acknowledgement.
+func (lpr *LprDaemon) rejectHost(conn net.Conn, protocol listenerProtocol) {
+	logErrorf("Rejecting connection from %s: host is not allowed", conn.RemoteAddr())
+	lpr.auditRejected(conn.RemoteAddr(), "host is not allowed")
+
+	if lpr.NackDeniedHosts && protocol == protocolLpr {
+		_, err := conn.Write([]byte{byte(NackRejected)})
+		if err != nil {
+			logErrorf("Sending NACK failed: %s", err.Error())
+		}
+	}
+
+	conn.Close()
+}
@@ -0,0 +1,95 @@
+package lprlib
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := parseNetworks([]string{"192.168.0.0/16", "10.1.2.3", "::1", "fd00::/8"})
+	require.Nil(t, err)
+	require.Len(t, networks, 4)
+
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{"192.168.10.20", true},
+		{"192.169.0.1", false},
+		{"10.1.2.3", true},
+		{"10.1.2.4", false},
+		{"::1", true},
+		{"fd12::1", true},
+		{"fe80::1", false},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, containsIP(networks, net.ParseIP(test.ip)), test.ip)
+	}
+
+	_, err = parseNetworks([]string{"192.168.0.0/33"})
+	require.NotNil(t, err)
+
+	_, err = parseNetworks([]string{"localhost"})
+	require.NotNil(t, err)
+}
+
+func TestDaemonHostAccess(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	// status requests the queue state and returns the response
+	status := func() string {
+		socket, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		require.Nil(t, err)
+		defer socket.Close()
+
+		_, err = socket.Write([]byte("\x03raw\n"))
+		require.Nil(t, err)
+
+		socket.SetReadDeadline(time.Now().Add(5 * time.Second))
+		response, _ := io.ReadAll(socket)
+
+		return string(response)
+	}
+
+	tests := []struct {
+		name     string
+		allowed  []string
+		denied   []string
+		nack     bool
+		expected string
+	}{
+		{name: "no lists", expected: "Idle\n"},
+		{name: "allowed", allowed: []string{"127.0.0.0/8"}, expected: "Idle\n"},
+		{name: "not allowed", allowed: []string{"10.0.0.0/8"}, expected: ""},
+		{name: "denied", allowed: []string{"127.0.0.0/8"}, denied: []string{"127.0.0.1"}, expected: ""},
+		{name: "denied with nack", denied: []string{"127.0.0.1"}, nack: true, expected: "\x03"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lprd := &LprDaemon{}
+			lprd.AllowedHosts = test.allowed
+			lprd.DeniedHosts = test.denied
+			lprd.NackDeniedHosts = test.nack
+			err := lprd.Init(port, "")
+			require.Nil(t, err)
+			defer lprd.Close()
+
+			require.Equal(t, test.expected, status())
+		})
+	}
+
+	lprd := &LprDaemon{}
+	lprd.DeniedHosts = []string{"invalid"}
+	err := lprd.Init(port, "")
+	require.NotNil(t, err)
+}
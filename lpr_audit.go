@@ -0,0 +1,158 @@
+package lprlib
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Outcomes of an AuditRecord
+const (
+	AuditOutcomeSuccess  = "success"
+	AuditOutcomeError    = "error"
+	AuditOutcomeRejected = "rejected"
+)
+
+// AuditRecord describes a connection (or a job, if multiple jobs are sent over one connection)
+// processed by the LprDaemon.
+type AuditRecord struct {
+	// Time is the time the connection (or job) was finished
+	Time time.Time `json:"time"`
+
+	// RemoteAddr is the address of the client
+	RemoteAddr string `json:"remote_addr"`
+
+	// Command is the requested daemon command (see ConnectionType.String), empty for rejected connections
+	Command string `json:"command,omitempty"`
+
+	// Queue is the name of the requested queue
+	Queue string `json:"queue,omitempty"`
+
+	// User is the user identification of a received job (P), or the agent of a remove jobs request
+	User string `json:"user,omitempty"`
+
+	// Host is the host name of a received job (H)
+	Host string `json:"host,omitempty"`
+
+	// JobName is the job name of a received job (J)
+	JobName string `json:"job_name,omitempty"`
+
+	// JobNumber is the job number of a received job
+	JobNumber string `json:"job_number,omitempty"`
+
+	// Jobs contains the jobs of a remove jobs request
+	Jobs []string `json:"jobs,omitempty"`
+
+	// FileName is the source file name of a received job (N)
+	FileName string `json:"file_name,omitempty"`
+
+	// SaveName is the name of the saved data file
+	SaveName string `json:"save_name,omitempty"`
+
+	// Bytes is the number of received bytes of the data file
+	Bytes uint64 `json:"bytes"`
+
+	// Checksum is the hex encoded checksum of the data file (see LprDaemon.ChecksumHash)
+	Checksum string `json:"checksum,omitempty"`
+
+	// Duration is the time the connection (or job) was processed
+	Duration time.Duration `json:"duration"`
+
+	// Outcome is AuditOutcomeSuccess, AuditOutcomeError or AuditOutcomeRejected
+	Outcome string `json:"outcome"`
+
+	// Error is the reason of a failed or rejected connection
+	Error string `json:"error,omitempty"`
+}
+
+// AuditLogger records the connections processed by the LprDaemon (see LprDaemon.AuditLog).
+// Audit is called from the goroutines processing the connections, so it must be safe for concurrent use.
+type AuditLogger interface {
+	Audit(record AuditRecord)
+}
+
+// AuditWriter is an AuditLogger writing every AuditRecord as a line of JSON to a writer.
+type AuditWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// NewAuditWriter creates a new AuditWriter writing to w.
+func NewAuditWriter(w io.Writer) *AuditWriter {
+	return &AuditWriter{w: w}
+}
+
+// Audit writes record as a line of JSON.
+func (a *AuditWriter) Audit(record AuditRecord) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		logErrorf("Error encoding audit record: %v", err)
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	_, err = a.w.Write(append(data, '\n'))
+	if err != nil {
+		logErrorf("Error writing audit record: %v", err)
+	}
+}
+
+// auditJob records the given finished connection or job in the audit log.
+func (lpr *LprConnection) auditJob(job *LprConnection) {
+	if lpr.daemon.AuditLog == nil {
+		return
+	}
+
+	record := AuditRecord{
+		Time:       time.Now(),
+		RemoteAddr: job.Connection.RemoteAddr().String(),
+		Command:    job.connectionType.String(),
+		Queue:      job.PrqName,
+		User:       job.UserIdentification,
+		Host:       job.Hostname,
+		JobName:    job.JobName,
+		JobNumber:  job.JobNumber,
+		Jobs:       job.JobList,
+		FileName:   job.Filename,
+		SaveName:   job.SaveName,
+		Bytes:      job.processedDataBytes,
+		Duration:   time.Since(job.startTime),
+		Outcome:    AuditOutcomeSuccess,
+	}
+
+	if job.Checksum != nil {
+		record.Checksum = hex.EncodeToString(job.Checksum)
+	}
+
+	if job.connectionType == ConnectionTypeRemoveJobs {
+		record.User = job.Agent
+	}
+
+	if job.Status != End {
+		record.Outcome = AuditOutcomeError
+		if job.err != nil {
+			record.Error = job.err.Error()
+		}
+	}
+
+	lpr.daemon.AuditLog.Audit(record)
+}
+
+// auditRejected records a connection rejected by the daemon in the audit log.
+func (lpr *LprDaemon) auditRejected(addr net.Addr, reason string) {
+	if lpr.AuditLog == nil {
+		return
+	}
+
+	lpr.AuditLog.Audit(AuditRecord{
+		Time:       time.Now(),
+		RemoteAddr: addr.String(),
+		Outcome:    AuditOutcomeRejected,
+		Error:      reason,
+	})
+}
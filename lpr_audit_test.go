@@ -0,0 +1,93 @@
+package lprlib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) records(t *testing.T) []AuditRecord {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	records := []AuditRecord{}
+	scanner := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	for scanner.Scan() {
+		var record AuditRecord
+		require.Nil(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func TestDaemonAuditLog(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	buffer := &syncBuffer{}
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.AuditLog = NewAuditWriter(buffer)
+	lprd.MaxJobSize = 100
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+
+	_, err = GetStatus("127.0.0.1", port, "raw", false, 2*time.Second)
+	require.Nil(t, err)
+	<-lprd.FinishedConnections()
+
+	lprd.MaxJobSize = 5
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.NotNil(t, err)
+	<-lprd.FinishedConnections()
+
+	records := buffer.records(t)
+	require.Len(t, records, 3)
+
+	require.Equal(t, "ReceivePrintJob", records[0].Command)
+	require.Equal(t, "raw", records[0].Queue)
+	require.Equal(t, "TestUser", records[0].User)
+	require.Equal(t, conn.SaveName, records[0].SaveName)
+	require.Equal(t, uint64(len(text)), records[0].Bytes)
+	require.Equal(t, AuditOutcomeSuccess, records[0].Outcome)
+	require.NotEmpty(t, records[0].RemoteAddr)
+
+	require.Equal(t, "SendQueueStateShort", records[1].Command)
+	require.Equal(t, AuditOutcomeSuccess, records[1].Outcome)
+
+	require.Equal(t, "ReceivePrintJob", records[2].Command)
+	require.Equal(t, AuditOutcomeError, records[2].Outcome)
+	require.Contains(t, records[2].Error, "maximum job size")
+}
@@ -0,0 +1,116 @@
+package lprlib
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// BannerFunc generates the banner page for job, which is prepended to the received data file.
+// The fields of the banner page are available as job.ClassName, job.JobName, job.BannerUser
+// and job.ControlFile.
+type BannerFunc func(job *LprConnection) ([]byte, error)
+
+// addBanner prepends the banner page generated by GenerateBanner to the data file,
+// once both files of a job requesting a banner page (L - Print banner page) were received.
+func (lpr *LprConnection) addBanner() error {
+	if lpr.daemon.GenerateBanner == nil || !lpr.PrintBanner || !lpr.controlFileReceived || !lpr.dataFileReceived {
+		return nil
+	}
+
+	if lpr.daemon.DataSinkFactory != nil {
+		logDebug("Not adding banner page to data file written to a DataSinkFactory")
+		return nil
+	}
+
+	banner, err := lpr.daemon.GenerateBanner(lpr)
+	if err != nil {
+		return fmt.Errorf("error generating banner page: %w", err)
+	}
+	if len(banner) == 0 {
+		return nil
+	}
+
+	if lpr.Data != nil {
+		lpr.Data = append(append(make([]byte, 0, len(banner)+len(lpr.Data)), banner...), lpr.Data...)
+		return nil
+	}
+
+	if lpr.SaveName == "" {
+		return nil
+	}
+
+	return lpr.prependToFile(banner)
+}
+
+// prependToFile writes data followed by the content of the data file into a part file,
+// which replaces the data file afterwards.
+// If a Compressor is used, data is compressed separately, which requires a format
+// allowing concatenated streams (like gzip).
+func (lpr *LprConnection) prependToFile(data []byte) (err error) {
+	part, err := lpr.createSpoolFile(".lpr_part_*")
+	if err != nil {
+		return fmt.Errorf("error creating part file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			part.Close()
+			os.Remove(part.Name())
+		}
+	}()
+
+	var w io.WriteCloser = part
+	if lpr.daemon.Compressor != nil {
+		w, err = lpr.daemon.Compressor.NewWriter(part)
+		if err != nil {
+			return fmt.Errorf("error creating compressor: %w", err)
+		}
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		return fmt.Errorf("error writing banner page: %w", err)
+	}
+
+	if lpr.daemon.Compressor != nil {
+		err = w.Close()
+		if err != nil {
+			return fmt.Errorf("error closing compressor: %w", err)
+		}
+	}
+
+	input, err := os.Open(lpr.SaveName)
+	if err != nil {
+		return fmt.Errorf("error opening data file %s: %w", lpr.SaveName, err)
+	}
+	defer input.Close()
+
+	_, err = io.Copy(part, input)
+	if err != nil {
+		return fmt.Errorf("error copying data file %s: %w", lpr.SaveName, err)
+	}
+
+	if lpr.daemon.SyncFiles {
+		err = part.Sync()
+		if err != nil {
+			return fmt.Errorf("error syncing part file %s: %w", part.Name(), err)
+		}
+	}
+
+	err = part.Close()
+	if err != nil {
+		return fmt.Errorf("error closing part file %s: %w", part.Name(), err)
+	}
+
+	err = os.Rename(part.Name(), lpr.SaveName)
+	if err != nil {
+		return fmt.Errorf("error renaming part file %s to %s: %w", part.Name(), lpr.SaveName, err)
+	}
+
+	if lpr.daemon.SyncFiles {
+		return syncDir(filepath.Dir(lpr.SaveName))
+	}
+
+	return nil
+}
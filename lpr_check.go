@@ -0,0 +1,171 @@
+package lprlib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCheckTimeout is the time CheckPrinter waits for the printer,
+// if the context has no deadline.
+const DefaultCheckTimeout = 2 * time.Second
+
+// PrinterCheckResult is the result of CheckPrinter.
+type PrinterCheckResult struct {
+	// Reachable tells if the connection to the printer was established
+	Reachable bool
+
+	// Responded tells if the printer answered the status request completely
+	Responded bool
+
+	// ConnectTime is the time needed to establish the connection
+	ConnectTime time.Duration
+
+	// RoundTrip is the time from sending the status request until the first byte
+	// (or the end) of the response was received
+	RoundTrip time.Duration
+
+	// Status is the (short) queue state returned by the printer
+	Status string
+}
+
+// CheckPrinter checks if the printer is reachable and responds to a short status request
+// (03 - Send queue state) for the queue, e.g. for monitoring systems.
+// The result is filled as far as the check succeeded and the error tells why it failed.
+// If the context has no deadline, DefaultCheckTimeout is used.
+func CheckPrinter(ctx context.Context, hostname string, port uint16, queue string) (*PrinterCheckResult, error) {
+	result := &PrinterCheckResult{}
+
+	// Set default Port
+	if port == 0 {
+		port = 515
+	}
+
+	// Set default Queue
+	if queue == "" {
+		queue = "raw"
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultCheckTimeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	address := net.JoinHostPort(hostname, strconv.Itoa(int(port)))
+	logDebugf("Checking printer %s, queue %s", address, queue)
+
+	start := time.Now()
+	var dialer net.Dialer
+	socket, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return result, &LprError{"Can't reach printer: " + err.Error()}
+	}
+	defer socket.Close()
+
+	result.Reachable = true
+	result.ConnectTime = time.Since(start)
+
+	socket.SetDeadline(deadline)
+
+	// abort reading and writing if the context is canceled
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			socket.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	start = time.Now()
+	_, err = socket.Write([]byte(fmt.Sprintf("%c%s\n", 3, queue)))
+	if err != nil {
+		return result, &LprError{"Can't write to printer: " + err.Error()}
+	}
+
+	buffer := make([]byte, 4096)
+	for {
+		n, err := socket.Read(buffer)
+		if result.RoundTrip == 0 && (n > 0 || err == io.EOF) {
+			result.RoundTrip = time.Since(start)
+		}
+		result.Status += string(buffer[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, &LprError{"Error while reading status: " + err.Error()}
+		}
+	}
+
+	result.Responded = true
+	return result, nil
+}
+
+// QueueProbe is the result of probing a queue name (see ProbeQueues).
+type QueueProbe struct {
+	// Queue is the probed queue name
+	Queue string
+
+	// Exists tells if the printer responded to the status request without reporting an unknown queue
+	Exists bool
+
+	// Status is the (short) queue state returned by the printer
+	Status string
+
+	// Err is the reason why the queue state could not be read
+	Err error
+}
+
+// unknownQueueMessages contains (lower case) parts of the queue states of common LPD servers
+// for unknown queues, e.g. "unknown printer" (BSD) or "spool queue for 'x' does not exist" (LPRng).
+var unknownQueueMessages = []string{
+	"unknown printer",
+	"unknown queue",
+	"does not exist",
+	"no such",
+	"not found",
+	"invalid printer",
+	"invalid queue",
+}
+
+// ProbeQueues requests the short queue state of each candidate queue name, e.g. "raw", "lp"
+// or "PASSTHRU", to find the queues of a printer whose queue names are unknown.
+// A queue exists if the printer responds with a queue state which doesn't report an unknown queue.
+// The results are returned in the order of the candidates.
+func ProbeQueues(hostname string, port uint16, candidates []string) []QueueProbe {
+	probes := make([]QueueProbe, 0, len(candidates))
+	for _, queue := range candidates {
+		probe := QueueProbe{Queue: queue}
+		probe.Status, probe.Err = GetStatus(hostname, port, queue, false, 0)
+		probe.Exists = probe.Err == nil && queueStateExists(probe.Status)
+		logDebugf("Probed queue %s of printer %s: exists %v", queue, hostname, probe.Exists)
+
+		probes = append(probes, probe)
+	}
+
+	return probes
+}
+
+// queueStateExists tells if the queue state describes an existing queue.
+func queueStateExists(state string) bool {
+	state = strings.ToLower(strings.TrimSpace(state))
+	if state == "" {
+		return false
+	}
+
+	for _, message := range unknownQueueMessages {
+		if strings.Contains(state, message) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,84 @@
+package lprlib
+
+import (
+	"context"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPrinter(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	lprd.GetQueueState = func(queue string, list string, long bool) string {
+		return "ready\n"
+	}
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	result, err := CheckPrinter(context.Background(), "127.0.0.1", port, "raw")
+	require.Nil(t, err)
+	require.True(t, result.Reachable)
+	require.True(t, result.Responded)
+	require.Equal(t, "ready\n", result.Status)
+	require.NotZero(t, result.RoundTrip)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+
+	// a printer which doesn't answer
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	result, err = CheckPrinter(ctx, "127.0.0.1", uint16(listener.Addr().(*net.TCPAddr).Port), "raw")
+	require.NotNil(t, err)
+	require.True(t, result.Reachable)
+	require.False(t, result.Responded)
+
+	// no printer at all
+	require.Nil(t, listener.Close())
+	result, err = CheckPrinter(context.Background(), "127.0.0.1", uint16(listener.Addr().(*net.TCPAddr).Port), "raw")
+	require.NotNil(t, err)
+	require.False(t, result.Reachable)
+}
+
+func TestProbeQueues(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	lprd.Queues = []string{"lp", "PASSTHRU", "other"}
+	lprd.GetQueueStateContext = func(ctx context.Context, request QueueStateRequest) (string, error) {
+		if request.Queue == "other" {
+			return "unknown printer\n", nil
+		}
+		return "no entries\n", nil
+	}
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+	go func() {
+		for range lprd.FinishedConnections() {
+		}
+	}()
+
+	probes := ProbeQueues("127.0.0.1", port, []string{"raw", "lp", "PASSTHRU", "other"})
+	require.Len(t, probes, 4)
+	require.False(t, probes[0].Exists)
+	require.True(t, probes[1].Exists)
+	require.Equal(t, "lp", probes[1].Queue)
+	require.True(t, probes[2].Exists)
+	require.False(t, probes[3].Exists)
+	require.Equal(t, "unknown printer\n", probes[3].Status)
+}
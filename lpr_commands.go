@@ -0,0 +1,125 @@
+package lprlib
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// StepPrintWaitingJobs is the command starting the printing of waiting jobs (01 - Print any waiting jobs)
+	StepPrintWaitingJobs SendStep = "printing waiting jobs"
+
+	// StepRemoveJobs is the command removing jobs from a queue (05 - Remove jobs)
+	StepRemoveJobs SendStep = "removing jobs"
+)
+
+// KickQueue requests the printer to print any waiting jobs of the queue (01 - Print any waiting jobs),
+// e.g. for spoolers which wait for this command after receiving jobs.
+func KickQueue(hostname string, port uint16, queue string) error {
+	if queue == "" || strings.ContainsAny(queue, " \t\n\x00") {
+		return &LprError{fmt.Sprintf("Invalid queue %q", queue)}
+	}
+
+	logDebugf("Printing waiting jobs of queue %s on printer %s", queue, hostname)
+	response, err := sendDaemonCommand(hostname, port, 0, 0x01, queue)
+	if err != nil {
+		return err
+	}
+
+	return checkCommandResponse(response, StepPrintWaitingJobs)
+}
+
+// RemoveJobs requests the printer to remove jobs from the queue (05 - Remove jobs).
+// agent is the name of the user requesting the removal, jobs contains user names or job numbers
+// (see LprSend.JobNumber). If jobs is empty, the active job of the agent is removed.
+// A negative acknowledgement of the printer is returned as PrinterNackError.
+func RemoveJobs(hostname string, port uint16, queue string, agent string, jobs []string, timeout time.Duration) error {
+	if agent == "" {
+		return &LprError{"No agent given"}
+	}
+
+	operands := append([]string{queue, agent}, jobs...)
+	for _, operand := range operands {
+		if operand == "" || strings.ContainsAny(operand, " \t\n\x00") {
+			return &LprError{fmt.Sprintf("Invalid operand %q", operand)}
+		}
+	}
+
+	logDebugf("Removing jobs %v of queue %s on printer %s as %s", jobs, queue, hostname, agent)
+
+	response, err := sendDaemonCommand(hostname, port, timeout, 0x05, operands...)
+	if err != nil {
+		return err
+	}
+
+	return checkCommandResponse(response, StepRemoveJobs)
+}
+
+// SendRawCommand sends a daemon command with the given code and operands (separated by spaces)
+// over the connection, e.g. for vendor-specific commands, and returns the response, which is read
+// until the printer closes the connection. Deadlines have to be set on the connection by the caller.
+func SendRawCommand(conn net.Conn, code byte, operands ...string) ([]byte, error) {
+	for _, operand := range operands {
+		if strings.ContainsAny(operand, "\n\x00") {
+			return nil, &LprError{fmt.Sprintf("Invalid operand %q", operand)}
+		}
+	}
+
+	command := fmt.Sprintf("%c%s\n", code, strings.Join(operands, " "))
+	logDebugf("Sending command %q to printer", command)
+
+	_, err := conn.Write([]byte(command))
+	if err != nil {
+		return nil, &LprError{"Can't write to printer: " + err.Error()}
+	}
+
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		return response, &LprError{"Error while reading response: " + err.Error()}
+	}
+
+	logDebugf("Response of printer: %q", response)
+	return response, nil
+}
+
+// sendDaemonCommand connects to the printer, sends the daemon command (see SendRawCommand)
+// and returns the response. If timeout is 0, 2 seconds are used for the whole command.
+func sendDaemonCommand(hostname string, port uint16, timeout time.Duration, code byte, operands ...string) ([]byte, error) {
+	// Set default Port
+	if port == 0 {
+		port = 515
+	}
+
+	if timeout == 0 {
+		timeout = 2 * time.Second
+	}
+
+	/* Connect to Server! */
+	address := net.JoinHostPort(hostname, strconv.Itoa(int(port)))
+	socket, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, &LprError{"Can't reach printer: " + err.Error()}
+	}
+	defer socket.Close()
+
+	err = socket.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, &LprError{err.Error()}
+	}
+
+	return SendRawCommand(socket, code, operands...)
+}
+
+// checkCommandResponse checks the response of a daemon command: RFC-1179 doesn't define one,
+// but some servers (e.g. LprDaemon) answer with an acknowledgement byte and others with text.
+func checkCommandResponse(response []byte, step SendStep) error {
+	if len(response) == 1 && response[0] != 0 {
+		return &PrinterNackError{Step: step, Code: NackCode(response[0])}
+	}
+
+	return nil
+}
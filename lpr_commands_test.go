@@ -0,0 +1,107 @@
+package lprlib
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveJobs(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	removed := make(chan []string, 1)
+	lprd.RemoveJobs = func(queue string, agent string, jobs []string) error {
+		removed <- append([]string{queue, agent}, jobs...)
+		return nil
+	}
+	lprd.AuthorizeRemoveJobs = func(remoteAddr net.Addr, agent string, queue string, jobs []string) error {
+		if agent != "TestUser" {
+			return errors.New("not the owner")
+		}
+		return nil
+	}
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	err = RemoveJobs("127.0.0.1", port, "raw", "TestUser", []string{"123", "456"}, time.Second)
+	require.Nil(t, err)
+	require.Equal(t, []string{"raw", "TestUser", "123", "456"}, <-removed)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+
+	err = RemoveJobs("127.0.0.1", port, "raw", "Other", []string{"123"}, time.Second)
+	var nackErr *PrinterNackError
+	require.True(t, errors.As(err, &nackErr))
+	require.Equal(t, StepRemoveJobs, nackErr.Step)
+	require.Equal(t, NackRejected, nackErr.Code)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+
+	require.NotNil(t, RemoveJobs("127.0.0.1", port, "raw", "", nil, time.Second))
+	require.NotNil(t, RemoveJobs("127.0.0.1", port, "raw", "TestUser", []string{"1 2"}, time.Second))
+}
+
+func TestKickQueue(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	kicked := make(chan string, 1)
+	lprd.PrintWaitingJobs = func(queue string) error {
+		kicked <- queue
+		return nil
+	}
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	err = KickQueue("127.0.0.1", port, "raw")
+	require.Nil(t, err)
+	require.Equal(t, "raw", <-kicked)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+
+	require.NotNil(t, KickQueue("127.0.0.1", port, ""))
+}
+
+func TestSendRawCommand(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	lprd.GetQueueState = func(queue string, list string, long bool) string {
+		return queue + " " + list + "\n"
+	}
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.Nil(t, err)
+	defer conn.Close()
+	require.Nil(t, conn.SetDeadline(time.Now().Add(time.Second)))
+
+	response, err := SendRawCommand(conn, 0x03, "raw", "alice", "123")
+	require.Nil(t, err)
+	require.Equal(t, "raw alice 123\n", string(response))
+
+	finished := <-lprd.FinishedConnections()
+	require.Equal(t, End, finished.Status)
+
+	_, err = SendRawCommand(conn, 0x03, "raw\n")
+	require.NotNil(t, err)
+}
@@ -0,0 +1,126 @@
+package lprlib
+
+import (
+	"fmt"
+
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// CompatibilityProfile adapts the daemon to LPR clients which don't follow RFC-1179 strictly
+// (see LprDaemon.Compatibility and LprDaemon.QueueCompatibility).
+type CompatibilityProfile struct {
+	// Name describes the clients of the profile in log messages
+	Name string
+
+	// LenientControlFile keeps control file lines with commands not defined by RFC-1179 in
+	// ControlFile.UnknownLines instead of rejecting the job. Invalid indents (I), widths (W)
+	// and symbolic link data (S) are ignored.
+	LenientControlFile bool
+
+	// FallbackEncoding is the encoding of the values of control files (e.g. user and job name)
+	// which are not valid UTF-8, e.g. "IBM850". If empty, the fallback encoding of the daemon
+	// (see LprDaemon.SetFallbackEncoding) is used.
+	FallbackEncoding string
+
+	// ImplicitNextJob starts the next job for the same queue if a client sends another control
+	// or data file after the job was complete, without sending 02 - Receive a printer job first.
+	ImplicitNextJob bool
+}
+
+// ProfileSAP is the CompatibilityProfile of the SAP spool system (access methods L and U),
+// which sends non-standard control file lines and several jobs over one connection.
+var ProfileSAP = &CompatibilityProfile{
+	Name:               "SAP",
+	LenientControlFile: true,
+	FallbackEncoding:   "ISO-8859-1",
+	ImplicitNextJob:    true,
+}
+
+// ProfileAS400 is the CompatibilityProfile of IBM i (AS/400) remote output queues, which send
+// non-standard control file lines, metadata converted from EBCDIC into the IBM PC code page 850
+// and several jobs over one connection.
+var ProfileAS400 = &CompatibilityProfile{
+	Name:               "AS/400",
+	LenientControlFile: true,
+	FallbackEncoding:   "IBM850",
+	ImplicitNextJob:    true,
+}
+
+// strictProfile is used if no CompatibilityProfile is configured.
+var strictProfile = &CompatibilityProfile{Name: "RFC-1179"}
+
+// checkCompatibility checks the encodings of the compatibility profiles of the daemon.
+func (lpr *LprDaemon) checkCompatibility() error {
+	profiles := []*CompatibilityProfile{lpr.Compatibility}
+	for _, profile := range lpr.QueueCompatibility {
+		profiles = append(profiles, profile)
+	}
+
+	for _, profile := range profiles {
+		if profile == nil || profile.FallbackEncoding == "" {
+			continue
+		}
+
+		encoding, err := ianaindex.IANA.Encoding(profile.FallbackEncoding)
+		if err == nil && encoding == nil {
+			err = fmt.Errorf("unsupported encoding %q", profile.FallbackEncoding)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid fallback encoding of compatibility profile %s: %w", profile.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// compatibility returns the CompatibilityProfile for the queue of the connection.
+func (lpr *LprConnection) compatibility() *CompatibilityProfile {
+	if profile := lpr.daemon.QueueCompatibility[lpr.PrqName]; profile != nil {
+		return profile
+	}
+
+	if lpr.daemon.Compatibility != nil {
+		return lpr.daemon.Compatibility
+	}
+
+	return strictProfile
+}
+
+// decode converts the given value of a control file into an UTF-8 string,
+// using the fallback encoding of the CompatibilityProfile if set.
+func (lpr *LprConnection) decode(value []byte) (string, error) {
+	profile := lpr.compatibility()
+	if profile.FallbackEncoding == "" {
+		return lpr.daemon.decode(value)
+	}
+
+	encoding, err := ianaindex.IANA.Encoding(profile.FallbackEncoding)
+	if err != nil {
+		return string(value), err
+	}
+
+	decoded, _, err := toUTF8(value, encoding.NewDecoder())
+	return decoded, err
+}
+
+// startImplicitJob starts the next job for the same queue, if the client sends the files of another job
+// without 02 - Receive a printer job (see CompatibilityProfile.ImplicitNextJob).
+func (lpr *LprConnection) startImplicitJob() error {
+	queue := lpr.PrqName
+	logDebugf("Client of profile %s continues with the next job for queue %s", lpr.compatibility().Name, queue)
+
+	lpr.startNextJob()
+
+	lpr.setConnectionType(ConnectionTypeReceivePrintJob)
+	lpr.PrqName = queue
+
+	err := lpr.checkJob()
+	if err != nil {
+		return err
+	}
+
+	lpr.receivingJob = true
+	lpr.emit(JobAccepted, nil)
+
+	return nil
+}
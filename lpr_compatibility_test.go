@@ -0,0 +1,81 @@
+package lprlib
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemonCompatibilityProfile(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.QueueCompatibility = map[string]*CompatibilityProfile{"sap": ProfileSAP, "as400": ProfileAS400}
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+
+	// sendJobs sends the jobs like the SAP spool system over one connection,
+	// sending 02 - Receive a printer job only for the first job
+	sendJobs := func(queue string, users ...string) {
+		socket, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		require.Nil(t, err)
+		defer socket.Close()
+		socket.SetDeadline(time.Now().Add(time.Minute))
+
+		ack := make([]byte, 1)
+		command := func(text string) {
+			_, err := socket.Write([]byte(text))
+			require.Nil(t, err)
+			_, err = socket.Read(ack)
+			require.Nil(t, err)
+			require.Equal(t, byte(0), ack[0], text)
+		}
+
+		command("\x02" + queue + "\n")
+		for i, user := range users {
+			controlFile := fmt.Sprintf("Hsapsys\nP%s\nJSAP job %d\n&R3SPOOL\nWabc\nldfA%03dsapsys\n", user, i, i)
+			command(fmt.Sprintf("\x02%d cfA%03dsapsys\n", len(controlFile), i))
+			command(controlFile + "\x00")
+			command(fmt.Sprintf("\x03%d dfA%03dsapsys\n", 4, i))
+			command(fmt.Sprintf("job%d\x00", i))
+		}
+	}
+
+	sendJobs("sap", "M\xfcller", "jdoe")
+
+	for i, user := range []string{"Müller", "jdoe"} {
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+		require.Equal(t, "sap", conn.PrqName)
+		require.Equal(t, user, conn.UserIdentification)
+		require.Equal(t, fmt.Sprintf("SAP job %d", i), conn.JobName)
+		require.Equal(t, []string{"&R3SPOOL"}, conn.ControlFile.UnknownLines)
+
+		data, err := os.ReadFile(conn.SaveName)
+		require.Nil(t, err)
+		require.Equal(t, fmt.Sprintf("job%d", i), string(data))
+		require.Nil(t, os.Remove(conn.SaveName))
+	}
+
+	// IBM code page 850
+	sendJobs("as400", "M\x81ller")
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "Müller", conn.UserIdentification)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	lprd.Close()
+
+	// profiles with unknown encodings are rejected
+	lprd.Compatibility = &CompatibilityProfile{Name: "invalid", FallbackEncoding: "unknown"}
+	require.NotNil(t, lprd.Init(port, ""))
+}
@@ -0,0 +1,136 @@
+package lprlib
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Compressor compresses received data files while they are saved (see LprDaemon.Compressor).
+type Compressor interface {
+	// Extension returns the file name extension of compressed files, e.g. ".gz".
+	Extension() string
+
+	// NewWriter returns a writer compressing all data written to w.
+	// Closing the returned writer must flush all data to w, but must not close w.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+}
+
+// Decompressor is implemented by a Compressor which can also read the files it compressed.
+// A Relay needs it to forward compressed data files.
+type Decompressor interface {
+	// NewReader returns a reader decompressing the data read from r.
+	// Closing the returned reader must not close r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// GzipCompressor compresses data files using gzip.
+type GzipCompressor struct {
+	// Level is the gzip compression level.
+	// If 0, gzip.DefaultCompression is used.
+	Level int
+}
+
+// Extension returns ".gz"
+func (g GzipCompressor) Extension() string {
+	return ".gz"
+}
+
+// NewWriter returns a gzip writer writing to w.
+func (g GzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	level := g.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	return gzip.NewWriterLevel(w, level)
+}
+
+// NewReader returns a gzip reader reading from r.
+func (g GzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// compressedSink compresses the data file into file.
+type compressedSink struct {
+	conn       *LprConnection
+	file       *os.File
+	compressor io.WriteCloser
+
+	// written is the number of (compressed) bytes written to file
+	written uint64
+
+	// uncompressed is the number of bytes written to the sink
+	uncompressed uint64
+}
+
+func (lpr *LprConnection) newCompressedSink(file *os.File) (*compressedSink, error) {
+	sink := &compressedSink{conn: lpr, file: file}
+
+	compressor, err := lpr.daemon.Compressor.NewWriter(writerFunc(sink.writeFile))
+	if err != nil {
+		return nil, fmt.Errorf("error creating compressor: %w", err)
+	}
+	sink.compressor = compressor
+
+	return sink, nil
+}
+
+func (c *compressedSink) writeFile(data []byte) (int, error) {
+	n, err := c.file.Write(data)
+	c.written += uint64(n)
+	return n, err
+}
+
+func (c *compressedSink) Write(data []byte) (int, error) {
+	n, err := c.compressor.Write(data)
+	c.uncompressed += uint64(n)
+	return n, err
+}
+
+// Sync flushes the compressor and syncs the file.
+// As most compression formats can't be flushed without closing the stream, Sync closes the compressor.
+func (c *compressedSink) Sync() error {
+	err := c.closeCompressor()
+	if err != nil {
+		return err
+	}
+
+	return c.file.Sync()
+}
+
+func (c *compressedSink) Close() error {
+	err := c.closeCompressor()
+
+	cErr := c.file.Close()
+	if err == nil {
+		err = cErr
+	}
+
+	return err
+}
+
+func (c *compressedSink) closeCompressor() error {
+	if c.compressor == nil {
+		return nil
+	}
+
+	err := c.compressor.Close()
+	c.compressor = nil
+	if err != nil {
+		return fmt.Errorf("error closing compressor: %w", err)
+	}
+
+	c.conn.UncompressedSize = c.uncompressed
+	c.conn.CompressedSize = c.written
+
+	return nil
+}
+
+// writerFunc is an io.Writer calling the function itself.
+type writerFunc func(data []byte) (int, error)
+
+func (w writerFunc) Write(data []byte) (int, error) {
+	return w(data)
+}
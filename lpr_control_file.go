@@ -0,0 +1,295 @@
+package lprlib
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// ControlFile contains the lines of a control file (see RFC-1179, chapter 7).
+type ControlFile struct {
+	// Class is the class name for banner pages (C)
+	Class string
+
+	// Host is the name of the host which created the job (H)
+	Host string
+
+	// Indent is the number of columns to indent the output (I)
+	Indent int64
+
+	// JobName is the job name for banner pages (J)
+	JobName string
+
+	// PrintBanner tells if a banner page should be printed (L)
+	PrintBanner bool
+
+	// BannerUser is the user name which should be printed on the banner page (L)
+	BannerUser string
+
+	// MailUser is the user which should be notified by mail when the job is printed (M)
+	MailUser string
+
+	// SourceFileName is the name of the source file (N)
+	SourceFileName string
+
+	// User is the user identification (P)
+	User string
+
+	// SymbolicLinks contains the device and inode numbers of files printed as symbolic links (S)
+	SymbolicLinks []SymbolicLink
+
+	// Title is the title for pr (T)
+	Title string
+
+	// UnlinkFiles contains the data files which should be removed after printing (U)
+	UnlinkFiles []string
+
+	// Width is the page width of the output (W)
+	Width int64
+
+	// TroffFonts contains the font file names for the troff R, I, B and S fonts (1, 2, 3, 4)
+	TroffFonts [4]string
+
+	// PrintFiles contains the data files which should be printed with their format
+	// (c, d, f, g, l, n, o, p, r, t, v) in the order they appeared in the control file.
+	PrintFiles []PrintFile
+
+	// UnknownLines contains the lines with commands not defined by RFC-1179,
+	// if the control file was received using a lenient CompatibilityProfile.
+	UnknownLines []string
+}
+
+// SymbolicLink is the device and inode number of a file printed as symbolic link.
+type SymbolicLink struct {
+	Device string
+	Inode  string
+}
+
+// PrintFile is a data file which should be printed using the given format.
+type PrintFile struct {
+	// Format is the control file command describing the format of the file (e.g. 'l' or 'o')
+	Format byte
+
+	// FileName is the name of the data file
+	FileName string
+}
+
+// decodeFunc converts a received value into a string.
+type decodeFunc func(value []byte) (string, error)
+
+// ParseControlFile parses the given control file, e.g. read from a spool directory.
+// A trailing 0x00 byte (as sent over the network) is ignored.
+// Values which are not valid UTF-8 are decoded using windows-1252.
+func ParseControlFile(data []byte) (*ControlFile, error) {
+	return ParseControlFileWithEncoding(data, "windows-1252")
+}
+
+// ParseControlFileWithEncoding is like ParseControlFile, but values which are not valid UTF-8
+// are decoded using the given fallback encoding (see LprDaemon.SetFallbackEncoding).
+func ParseControlFileWithEncoding(data []byte, fallbackEncoding string) (*ControlFile, error) {
+	encoding, err := ianaindex.IANA.Encoding(fallbackEncoding)
+	if err != nil {
+		return nil, err
+	}
+	if encoding == nil {
+		return nil, fmt.Errorf("unsupported encoding %q", fallbackEncoding)
+	}
+
+	decoder := encoding.NewDecoder()
+	decode := func(value []byte) (string, error) {
+		decoded, _, err := toUTF8(value, decoder)
+		return decoded, err
+	}
+
+	return parseControlFile(bytes.TrimSuffix(data, []byte{0}), decode, false)
+}
+
+// parseControlFile parses the lines of the given control file data (without the trailing 0x00 byte).
+// If lenient is set, unknown lines are kept in UnknownLines and invalid numeric values are ignored.
+func parseControlFile(data []byte, decode decodeFunc, lenient bool) (*ControlFile, error) {
+	cf := &ControlFile{}
+
+	line := []byte{}
+	for _, b := range data {
+		if b == '\n' {
+			// end of control file line
+			err := cf.parseLine(line, decode, lenient)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing control file line %q: %w", string(line), err)
+			}
+
+			line = make([]byte, 0)
+		} else {
+			line = append(line, b)
+		}
+	}
+
+	if len(line) > 0 {
+		return nil, fmt.Errorf("garbage at end of control file: %s", string(line))
+	}
+
+	return cf, nil
+}
+
+func (cf *ControlFile) parseLine(line []byte, decode decodeFunc, lenient bool) error {
+	if len(line) == 0 {
+		// empty line
+		return nil
+	}
+
+	value := line[1:]
+
+	var err error
+	switch line[0] {
+	/* C - Class for banner page */
+	case 'C':
+		cf.Class, err = decode(value)
+		if err != nil {
+			return fmt.Errorf("invalid class name %q: %v", cf.Class, err)
+		}
+		logDebugf("Class name: %s", cf.Class)
+
+	/* H - Host name */
+	case 'H':
+		cf.Host, err = decode(value)
+		if err != nil {
+			return fmt.Errorf("invalid hostname %q: %v", cf.Host, err)
+		}
+		logDebugf("Hostname: %s", cf.Host)
+
+	/* I - Indent Printing */
+	case 'I':
+		cf.Indent, err = strconv.ParseInt(string(value), 10, 64)
+		if err != nil && lenient {
+			logDebugf("Ignoring invalid indent %q", string(value))
+			cf.Indent, err = 0, nil
+		}
+		if err != nil {
+			return err
+		}
+		logDebugf("indenting_count: %d", cf.Indent)
+
+	/* J - Job name for banner page */
+	case 'J':
+		cf.JobName, err = decode(value)
+		if err != nil {
+			return fmt.Errorf("invalid job name %q: %v", cf.JobName, err)
+		}
+		logDebugf("Job name: %s", cf.JobName)
+
+	/* L - Print banner page */
+	case 'L':
+		cf.PrintBanner = true
+		cf.BannerUser, err = decode(value)
+		if err != nil {
+			return fmt.Errorf("invalid banner user %q: %v", cf.BannerUser, err)
+		}
+
+	/* M - Mail When Printed */
+	case 'M':
+		cf.MailUser, err = decode(value)
+		if err != nil {
+			return fmt.Errorf("invalid mail user %q: %v", cf.MailUser, err)
+		}
+
+	/* N - Name of source file */
+	case 'N':
+		cf.SourceFileName, err = decode(value)
+		if err != nil {
+			return fmt.Errorf("invalid filename %q: %v", cf.SourceFileName, err)
+		}
+		logDebugf("Filename: %s", cf.SourceFileName)
+
+	/* P - User identification */
+	case 'P':
+		cf.User, err = decode(value)
+		if err != nil {
+			return fmt.Errorf("invalid user identification %q: %v", cf.User, err)
+		}
+		logDebugf("User identification: %s", cf.User)
+
+	/* S - Symbolic link data */
+	case 'S':
+		fields := strings.Fields(string(value))
+		if len(fields) != 2 && lenient {
+			logDebugf("Ignoring invalid symbolic link data %q", string(value))
+			break
+		}
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid symbolic link data %q", string(value))
+		}
+		cf.SymbolicLinks = append(cf.SymbolicLinks, SymbolicLink{Device: fields[0], Inode: fields[1]})
+
+	/* T - Title for pr */
+	case 'T':
+		cf.Title, err = decode(value)
+		if err != nil {
+			return fmt.Errorf("invalid title text %q: %v", cf.Title, err)
+		}
+		logDebugf("Title text: %s", cf.Title)
+
+	/* U - Unlink data file */
+	case 'U':
+		cf.UnlinkFiles = append(cf.UnlinkFiles, string(value))
+
+	/* W - Width of output */
+	case 'W':
+		cf.Width, err = strconv.ParseInt(string(value), 10, 64)
+		if err != nil && lenient {
+			logDebugf("Ignoring invalid width %q", string(value))
+			cf.Width, err = 0, nil
+		}
+		if err != nil {
+			return err
+		}
+
+	/* 1 - troff R font */
+	/* 2 - troff I font */
+	/* 3 - troff B font */
+	/* 4 - troff S font */
+	case '1', '2', '3', '4':
+		cf.TroffFonts[line[0]-'1'] = string(value)
+
+	/* c - Plot CIF file */
+	/* d - Print DVI file */
+	/* f - Print formatted file */
+	/* g - Plot file */
+	/* l - Print file leaving control characters */
+	/* n - Print ditroff output file */
+	/* o - Print Postscript output file */
+	/* p - Print file with 'pr' format */
+	/* r - File to print with FORTRAN carriage control */
+	/* t - Print troff output file */
+	/* v - Print raster file */
+	case 'c', 'd', 'f', 'g', 'l', 'n', 'o', 'p', 'r', 't', 'v':
+		cf.PrintFiles = append(cf.PrintFiles, PrintFile{Format: line[0], FileName: string(value)})
+		logDebugf("%c: %s", line[0], string(value))
+
+	case 0x00:
+
+	default:
+		if lenient {
+			logDebugf("Keeping unknown control file line %q", string(line))
+			cf.UnknownLines = append(cf.UnknownLines, string(line))
+			break
+		}
+		return fmt.Errorf("unknown control file line %02x (%c): %s", line[0], line[0], string(line))
+
+	}
+
+	return nil
+}
+
+// printFile returns the name of the first data file which should be printed using format.
+func (cf *ControlFile) printFile(format byte) string {
+	for _, file := range cf.PrintFiles {
+		if file.Format == format {
+			return file.FileName
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,248 @@
+package lprlib
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Maximum lengths of control file values (see RFC-1179, chapter 7)
+const (
+	maxHostLength       = 31
+	maxUserLength       = 31
+	maxJobNameLength    = 99
+	maxClassLength      = 31
+	maxTitleLength      = 79
+	maxSourceNameLength = 131
+)
+
+// controlValueNames contains the names and maximum lengths (0 means no limit) of the
+// control file commands whose values are checked before sending.
+var controlValueNames = map[byte]struct {
+	name      string
+	maxLength int
+}{
+	'H': {"host name", maxHostLength},
+	'P': {"user identification", maxUserLength},
+	'J': {"job name", maxJobNameLength},
+	'C': {"class", maxClassLength},
+	'L': {"banner user", maxUserLength},
+	'T': {"title", maxTitleLength},
+	'I': {"indent", 0},
+	'W': {"width", 0},
+	'M': {"mail user", maxUserLength},
+	'N': {"source file name", maxSourceNameLength},
+}
+
+// checkControlValue checks if the value may be written into a control file line.
+// The value must not contain control characters (e.g. a newline) and must not exceed
+// maxLength bytes (0 means no limit).
+func checkControlValue(command byte, name string, value string, maxLength int) error {
+	for _, r := range value {
+		if r < ' ' || r == 0x7f {
+			return fmt.Errorf("%s (%c) must not contain control characters: %q", name, command, value)
+		}
+	}
+	if maxLength > 0 && len(value) > maxLength {
+		return fmt.Errorf("%s (%c) exceeds %d bytes: %q", name, command, maxLength, value)
+	}
+
+	return nil
+}
+
+// clipControlValue removes control characters from the value and truncates it to maxLength bytes
+// (0 means no limit), so it passes checkControlValue. It is used for values taken from other
+// sources, which are truncated like the lpd backend of CUPS does instead of failing the job.
+func clipControlValue(value string, maxLength int) string {
+	value = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, value)
+
+	if maxLength > 0 && len(value) > maxLength {
+		// don't split a multi-byte character
+		end := maxLength
+		for end > 0 && !utf8.RuneStart(value[end]) {
+			end--
+		}
+		value = value[:end]
+	}
+
+	return value
+}
+
+// ControlFileBuilder builds a control file (see RFC-1179, chapter 7).
+// In contrast to LprSend.Config, the values are validated and the lines are written
+// in the order recommended by the RFC: H, P, J, C, L, T, I, W, M, the print commands with
+// the names of their source files (N) and finally the data files to unlink (U).
+type ControlFileBuilder struct {
+	host       string
+	user       string
+	jobName    string
+	class      string
+	banner     bool
+	bannerUser string
+	title      string
+	indent     int
+	width      int
+	mailUser   string
+	files      []builderFile
+	unlink     []string
+}
+
+// builderFile is a data file which should be printed.
+type builderFile struct {
+	format     Format
+	dataFile   string
+	sourceName string
+	copies     int
+}
+
+// NewControlFileBuilder creates a builder for a control file of the given host (H) and user (P).
+func NewControlFileBuilder(host string, user string) *ControlFileBuilder {
+	return &ControlFileBuilder{host: host, user: user}
+}
+
+// SetJobName sets the job name for the banner page (J).
+func (b *ControlFileBuilder) SetJobName(name string) *ControlFileBuilder {
+	b.jobName = name
+	return b
+}
+
+// SetClass sets the class name for the banner page (C).
+func (b *ControlFileBuilder) SetClass(class string) *ControlFileBuilder {
+	b.class = class
+	return b
+}
+
+// SetBanner requests a banner page for the given user (L).
+func (b *ControlFileBuilder) SetBanner(user string) *ControlFileBuilder {
+	b.banner = true
+	b.bannerUser = user
+	return b
+}
+
+// SetTitle sets the title for pr (T).
+func (b *ControlFileBuilder) SetTitle(title string) *ControlFileBuilder {
+	b.title = title
+	return b
+}
+
+// SetIndent sets the number of columns to indent the output (I).
+func (b *ControlFileBuilder) SetIndent(indent int) *ControlFileBuilder {
+	b.indent = indent
+	return b
+}
+
+// SetWidth sets the page width of the output (W).
+func (b *ControlFileBuilder) SetWidth(width int) *ControlFileBuilder {
+	b.width = width
+	return b
+}
+
+// SetMailUser requests a mail to the given user when the job is printed (M).
+func (b *ControlFileBuilder) SetMailUser(user string) *ControlFileBuilder {
+	b.mailUser = user
+	return b
+}
+
+// AddPrintFile adds a data file which should be printed using the given format (e.g. FormatRaw).
+// The sourceName is the (optional) name of the source file (N), copies the number of copies.
+func (b *ControlFileBuilder) AddPrintFile(format Format, dataFile string, sourceName string, copies int) *ControlFileBuilder {
+	b.files = append(b.files, builderFile{format: format, dataFile: dataFile, sourceName: sourceName, copies: copies})
+	return b
+}
+
+// AddUnlink requests to remove the given data file after printing (U).
+func (b *ControlFileBuilder) AddUnlink(dataFile string) *ControlFileBuilder {
+	b.unlink = append(b.unlink, dataFile)
+	return b
+}
+
+// Build validates the values and returns the control file (without the trailing 0x00 byte).
+func (b *ControlFileBuilder) Build() ([]byte, error) {
+	if b.host == "" {
+		return nil, fmt.Errorf("host name (H) is required")
+	}
+	if b.user == "" {
+		return nil, fmt.Errorf("user identification (P) is required")
+	}
+	if len(b.files) == 0 {
+		return nil, fmt.Errorf("no data file to print")
+	}
+
+	var buffer bytes.Buffer
+	var err error
+
+	writeLine := func(command byte, name string, value string, maxLength int) {
+		if err != nil {
+			return
+		}
+		err = checkControlValue(command, name, value, maxLength)
+		if err != nil {
+			return
+		}
+		buffer.WriteByte(command)
+		buffer.WriteString(value)
+		buffer.WriteByte('\n')
+	}
+
+	writeLine('H', "host name", b.host, maxHostLength)
+	writeLine('P', "user identification", b.user, maxUserLength)
+	if b.jobName != "" {
+		writeLine('J', "job name", b.jobName, maxJobNameLength)
+	}
+	if b.class != "" {
+		writeLine('C', "class", b.class, maxClassLength)
+	}
+	if b.banner {
+		writeLine('L', "banner user", b.bannerUser, maxUserLength)
+	}
+	if b.title != "" {
+		writeLine('T', "title", b.title, maxTitleLength)
+	}
+	if b.indent > 0 {
+		writeLine('I', "indent", strconv.Itoa(b.indent), 0)
+	}
+	if b.width > 0 {
+		writeLine('W', "width", strconv.Itoa(b.width), 0)
+	}
+	if b.mailUser != "" {
+		writeLine('M', "mail user", b.mailUser, maxUserLength)
+	}
+
+	for _, file := range b.files {
+		if !file.format.Valid() {
+			return nil, fmt.Errorf("invalid print format %s for data file %q", file.format, file.dataFile)
+		}
+		if file.dataFile == "" {
+			return nil, fmt.Errorf("data file name is required")
+		}
+
+		copies := file.copies
+		if copies < 1 {
+			copies = 1
+		}
+		for i := 0; i < copies; i++ {
+			writeLine(byte(file.format), "data file name", file.dataFile, 0)
+		}
+
+		if file.sourceName != "" {
+			writeLine('N', "source file name", file.sourceName, maxSourceNameLength)
+		}
+	}
+
+	for _, dataFile := range b.unlink {
+		writeLine('U', "data file name", dataFile, 0)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,50 @@
+package lprlib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestControlFileBuilder(t *testing.T) {
+	data, err := NewControlFileBuilder("host", "user").
+		AddPrintFile('l', "dfA001host", "first.txt", 2).
+		AddPrintFile('o', "dfB001host", "", 1).
+		AddUnlink("dfA001host").
+		SetMailUser("mail").
+		SetWidth(80).
+		SetIndent(4).
+		SetTitle("title").
+		SetBanner("banner").
+		SetClass("class").
+		SetJobName("job").
+		Build()
+	require.Nil(t, err)
+	require.Equal(t, "Hhost\nPuser\nJjob\nCclass\nLbanner\nTtitle\nI4\nW80\nMmail\n"+
+		"ldfA001host\nldfA001host\nNfirst.txt\nodfB001host\nUdfA001host\n", string(data))
+
+	cf, err := ParseControlFile(data)
+	require.Nil(t, err)
+	require.Equal(t, "host", cf.Host)
+	require.Equal(t, "user", cf.User)
+	require.Equal(t, "job", cf.JobName)
+	require.True(t, cf.PrintBanner)
+	require.Len(t, cf.PrintFiles, 3)
+
+	for name, builder := range map[string]*ControlFileBuilder{
+		"newline":      NewControlFileBuilder("host", "TestUser1\n\n").AddPrintFile('p', "dfA001host", "", 1),
+		"zero byte":    NewControlFileBuilder("host", "user").SetJobName("job\x00").AddPrintFile('p', "dfA001host", "", 1),
+		"too long":     NewControlFileBuilder(strings.Repeat("h", 32), "user").AddPrintFile('p', "dfA001host", "", 1),
+		"long title":   NewControlFileBuilder("host", "user").SetTitle(strings.Repeat("t", 80)).AddPrintFile('p', "dfA001host", "", 1),
+		"control char": NewControlFileBuilder("host", "user").SetClass("a\tb").AddPrintFile('p', "dfA001host", "", 1),
+		"no host":      NewControlFileBuilder("", "user").AddPrintFile('p', "dfA001host", "", 1),
+		"no user":      NewControlFileBuilder("host", "").AddPrintFile('p', "dfA001host", "", 1),
+		"no file":      NewControlFileBuilder("host", "user"),
+		"no file name": NewControlFileBuilder("host", "user").AddPrintFile('p', "", "", 1),
+		"format":       NewControlFileBuilder("host", "user").AddPrintFile('x', "dfA001host", "", 1),
+	} {
+		_, err := builder.Build()
+		require.NotNil(t, err, name)
+	}
+}
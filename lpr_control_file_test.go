@@ -0,0 +1,101 @@
+package lprlib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseControlFile(t *testing.T) {
+	daemon := LprDaemon{}
+	err := daemon.SetFallbackEncoding("windows-1252")
+	require.Nil(t, err)
+
+	data := "Hhost\n" +
+		"Puser\n" +
+		"Jjob name\n" +
+		"Cclass\n" +
+		"Lbanner user\n" +
+		"Mmail@example.com\n" +
+		"Nsource.txt\n" +
+		"Ttitle\n" +
+		"I8\n" +
+		"W132\n" +
+		"S1234 5678\n" +
+		"1R.font\n" +
+		"4S.font\n" +
+		"ldfA001host\n" +
+		"ldfA001host\n" +
+		"odfA002host\n" +
+		"UdfA001host\n" +
+		"UdfA002host\n"
+
+	cf, err := parseControlFile([]byte(data), daemon.decode, false)
+	require.Nil(t, err)
+	require.Equal(t, &ControlFile{
+		Class:          "class",
+		Host:           "host",
+		Indent:         8,
+		JobName:        "job name",
+		PrintBanner:    true,
+		BannerUser:     "banner user",
+		MailUser:       "mail@example.com",
+		SourceFileName: "source.txt",
+		User:           "user",
+		SymbolicLinks:  []SymbolicLink{{Device: "1234", Inode: "5678"}},
+		Title:          "title",
+		UnlinkFiles:    []string{"dfA001host", "dfA002host"},
+		Width:          132,
+		TroffFonts:     [4]string{"R.font", "", "", "S.font"},
+		PrintFiles: []PrintFile{
+			{Format: 'l', FileName: "dfA001host"},
+			{Format: 'l', FileName: "dfA001host"},
+			{Format: 'o', FileName: "dfA002host"},
+		},
+	}, cf)
+
+	_, err = parseControlFile([]byte("Hhost\nPuser"), daemon.decode, false)
+	require.NotNil(t, err)
+
+	_, err = parseControlFile([]byte("Xunknown\n"), daemon.decode, false)
+	require.NotNil(t, err)
+
+	_, err = parseControlFile([]byte("Inot a number\n"), daemon.decode, false)
+	require.NotNil(t, err)
+
+	// lenient parsing keeps unknown lines
+	cf, err = parseControlFile([]byte("Hhost\nXunknown\n&SAP\nInot a number\nW\nSinvalid\nldfA001host\n"), daemon.decode, true)
+	require.Nil(t, err)
+	require.Equal(t, &ControlFile{
+		Host:         "host",
+		PrintFiles:   []PrintFile{{Format: 'l', FileName: "dfA001host"}},
+		UnknownLines: []string{"Xunknown", "&SAP"},
+	}, cf)
+}
+
+func TestParseControlFilePublic(t *testing.T) {
+	cf, err := ParseControlFile([]byte("Hhost\nPT\xE4stUser\nldfA000host\n\x00"))
+	require.Nil(t, err)
+	require.Equal(t, "host", cf.Host)
+	require.Equal(t, "TästUser", cf.User)
+	require.Equal(t, []PrintFile{{Format: 'l', FileName: "dfA000host"}}, cf.PrintFiles)
+
+	cf, err = ParseControlFileWithEncoding([]byte("P\xD4\xD5\n"), "windows-1251")
+	require.Nil(t, err)
+	require.Equal(t, "ФХ", cf.User)
+
+	_, err = ParseControlFileWithEncoding([]byte("Puser\n"), "no-such-encoding")
+	require.NotNil(t, err)
+}
+
+func FuzzParseControlFile(f *testing.F) {
+	f.Add([]byte("Hhost\nPuser\nldfA000host\n"))
+	f.Add([]byte("S1 2\nI8\nW80\n\x00"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cf, err := ParseControlFile(data)
+		if err == nil && cf == nil {
+			t.Error("got neither control file nor error")
+		}
+	})
+}
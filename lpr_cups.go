@@ -0,0 +1,320 @@
+package lprlib
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCupsTimeout is the timeout of the LPR connection of a CUPS backend,
+// if the device URI has no timeout option.
+const DefaultCupsTimeout = 5 * time.Minute
+
+// CupsExitCode is the exit status of a CUPS backend, which tells the scheduler how to continue (see backend(7)).
+type CupsExitCode int
+
+// Exit codes of CUPS backends
+const (
+	// CupsBackendOK tells that the job was sent successfully
+	CupsBackendOK CupsExitCode = 0
+
+	// CupsBackendFailed tells that the job failed, the error policy of the queue applies
+	CupsBackendFailed CupsExitCode = 1
+
+	// CupsBackendAuthRequired tells that the job requires authentication
+	CupsBackendAuthRequired CupsExitCode = 2
+
+	// CupsBackendHold tells that the job should be held
+	CupsBackendHold CupsExitCode = 3
+
+	// CupsBackendStop tells that the queue should be stopped
+	CupsBackendStop CupsExitCode = 4
+
+	// CupsBackendCancel tells that the job should be canceled
+	CupsBackendCancel CupsExitCode = 5
+
+	// CupsBackendRetry tells that the job should be retried later
+	CupsBackendRetry CupsExitCode = 6
+
+	// CupsBackendRetryCurrent tells that the job should be retried immediately
+	CupsBackendRetryCurrent CupsExitCode = 7
+)
+
+// CupsJob is a job passed by the CUPS scheduler to a backend using the arguments
+// "job-id user title copies options [file]" and the environment (e.g. DEVICE_URI).
+type CupsJob struct {
+	// JobID is the id of the job in the CUPS scheduler
+	JobID string
+
+	// User is the user who submitted the job
+	User string
+
+	// Title is the title of the job
+	Title string
+
+	// Copies is the number of copies the backend has to print. The copies of jobs read from the
+	// standard input are already produced by the filters, so it is 1 for them.
+	Copies int
+
+	// Options contains the job options (e.g. media=A4)
+	Options map[string]string
+
+	// File is the file to print. If empty, the job is read from the standard input.
+	File string
+
+	// ContentType is the MIME media type of the job (CONTENT_TYPE)
+	ContentType string
+
+	// DeviceURI is the device URI of the queue, e.g. lpd://printer/queue?format=o (DEVICE_URI)
+	DeviceURI string
+
+	// Hostname, Port and Queue of the printer taken from the DeviceURI
+	Hostname string
+	Port     uint16
+	Queue    string
+
+	// URIOptions contains the options of the DeviceURI, which are supported as by the lpd
+	// backend of CUPS: banner=on, format=<print command>, order=data,control, reserve=rfc1179,
+	// timeout=<seconds> and contimeout=<seconds>.
+	URIOptions map[string]string
+}
+
+// ParseCupsJob parses the arguments (including the program name) and environment
+// (e.g. os.Getenv) passed to a CUPS backend.
+func ParseCupsJob(args []string, getenv func(string) string) (*CupsJob, error) {
+	if len(args) != 6 && len(args) != 7 {
+		return nil, &LprError{fmt.Sprintf("Usage: %s job-id user title copies options [file]", cupsBackendName(args))}
+	}
+
+	job := &CupsJob{
+		JobID:       args[1],
+		User:        args[2],
+		Title:       args[3],
+		Copies:      1,
+		Options:     parseCupsOptions(args[5]),
+		ContentType: getenv("CONTENT_TYPE"),
+		DeviceURI:   getenv("DEVICE_URI"),
+	}
+
+	if len(args) == 7 {
+		job.File = args[6]
+
+		copies, err := strconv.Atoi(args[4])
+		if err != nil || copies < 1 {
+			return nil, &LprError{fmt.Sprintf("Invalid number of copies %q", args[4])}
+		}
+		job.Copies = copies
+	}
+
+	uri, err := url.Parse(job.DeviceURI)
+	if err != nil || uri.Scheme == "" || uri.Hostname() == "" {
+		return nil, &LprError{fmt.Sprintf("Invalid device URI %q", job.DeviceURI)}
+	}
+
+	job.Hostname = uri.Hostname()
+	job.Queue = strings.TrimPrefix(uri.Path, "/")
+	if job.Queue == "" {
+		return nil, &LprError{fmt.Sprintf("Device URI %q has no queue", job.DeviceURI)}
+	}
+
+	if port := uri.Port(); port != "" {
+		value, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, &LprError{fmt.Sprintf("Invalid port in device URI %q", job.DeviceURI)}
+		}
+		job.Port = uint16(value)
+	}
+
+	job.URIOptions = make(map[string]string)
+	for name, values := range uri.Query() {
+		job.URIOptions[strings.ToLower(name)] = values[len(values)-1]
+	}
+
+	return job, nil
+}
+
+// cupsBackendName returns the name of the backend program.
+func cupsBackendName(args []string) string {
+	if len(args) == 0 {
+		return "lpd"
+	}
+
+	return args[0]
+}
+
+// parseCupsOptions parses the job options passed to a CUPS backend, which are separated by spaces
+// and may be quoted, e.g. "media=A4 job-sheets='none,none' landscape". Options without value are true.
+func parseCupsOptions(options string) map[string]string {
+	values := make(map[string]string)
+
+	var name, value strings.Builder
+	current := &name
+	var quote rune
+	escaped := false
+
+	add := func() {
+		if name.Len() > 0 {
+			if current == &name {
+				values[name.String()] = "true"
+			} else {
+				values[name.String()] = value.String()
+			}
+		}
+		name.Reset()
+		value.Reset()
+		current = &name
+	}
+
+	for _, c := range options {
+		switch {
+		case escaped:
+			current.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '=' && current == &name:
+			current = &value
+		case c == ' ' || c == '\t':
+			add()
+		default:
+			current.WriteRune(c)
+		}
+	}
+	add()
+
+	return values
+}
+
+// SendOptions returns the options sending the job like the lpd backend of CUPS:
+// the title is used as job name (truncated to the 99 bytes allowed by RFC-1179),
+// the copies are repeated in the control file, and the URIOptions configure the banner page,
+// format, order and timeouts. Invalid URI options are ignored.
+func (job *CupsJob) SendOptions() []SendOption {
+	opts := []SendOption{WithJobName(clipControlValue(job.Title, maxJobNameLength))}
+
+	if job.Copies > 1 {
+		opts = append(opts, WithCopies(job.Copies))
+	}
+
+	if strings.EqualFold(job.URIOptions["banner"], "on") || strings.EqualFold(job.URIOptions["banner"], "yes") {
+		opts = append(opts, WithBanner(""))
+	}
+
+	if format := job.URIOptions["format"]; len(format) == 1 {
+		opts = append(opts, WithFormat(Format(format[0])))
+	}
+
+	if job.URIOptions["order"] == "data,control" {
+		opts = append(opts, WithDataFileFirst())
+	}
+
+	if job.URIOptions["reserve"] == "rfc1179" {
+		opts = append(opts, func(lpr *LprSend) {
+			lpr.PrivilegedSourcePort = true
+		})
+	}
+
+	if timeout, err := strconv.Atoi(job.URIOptions["timeout"]); err == nil && timeout > 0 {
+		opts = append(opts, func(lpr *LprSend) {
+			lpr.Timeout = time.Duration(timeout) * time.Second
+		})
+	}
+
+	if timeout, err := strconv.Atoi(job.URIOptions["contimeout"]); err == nil && timeout > 0 {
+		opts = append(opts, func(lpr *LprSend) {
+			lpr.DialTimeout = time.Duration(timeout) * time.Second
+		})
+	}
+
+	return opts
+}
+
+// RunCupsBackend runs a CUPS backend for lpd:// device URIs, so a small main function
+// can act as drop-in replacement of the lpd backend of CUPS:
+//
+//	func main() {
+//		os.Exit(int(lprlib.RunCupsBackend(os.Args, os.Getenv, os.Stdin, os.Stdout, os.Stderr)))
+//	}
+//
+// If called without arguments, the device discovery line of the backend is written to stdout.
+// Otherwise, the job (see ParseCupsJob) is sent to the printer using the SendOptions of the job,
+// followed by the given options. Jobs read from stdin are spooled into a temporary file (in TMPDIR),
+// since the size of the data file has to be sent first. Messages for the scheduler are written to stderr.
+// The returned exit code asks the scheduler to retry the job later if the printer couldn't be reached
+// or asked to retry (see PrinterNackError.Temporary).
+func RunCupsBackend(args []string, getenv func(string) string, stdin io.Reader, stdout io.Writer, stderr io.Writer, opts ...SendOption) CupsExitCode {
+	if len(args) <= 1 {
+		fmt.Fprintln(stdout, `network lpd "Unknown" "LPD/LPR Host or Printer"`)
+		return CupsBackendOK
+	}
+
+	job, err := ParseCupsJob(args, getenv)
+	if err != nil {
+		fmt.Fprintf(stderr, "ERROR: %v\n", err)
+		return CupsBackendFailed
+	}
+
+	file := job.File
+	if file == "" {
+		file, err = spoolCupsJob(stdin, getenv("TMPDIR"))
+		if err != nil {
+			fmt.Fprintf(stderr, "ERROR: Can't spool job %s: %v\n", job.JobID, err)
+			return CupsBackendFailed
+		}
+		defer os.Remove(file)
+	}
+
+	fmt.Fprintf(stderr, "INFO: Sending job %s to queue %s on %s\n", job.JobID, job.Queue, job.Hostname)
+
+	lpr := &LprSend{}
+	err = send(lpr, job.Hostname, job.Port, job.Queue, job.User, DefaultCupsTimeout, file, clipControlValue(job.Title, maxSourceNameLength), append(job.SendOptions(), opts...), func(lpr *LprSend) error {
+		return lpr.SendFile()
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "ERROR: Can't send job %s: %v\n", job.JobID, err)
+		return cupsExitCode(lpr, err)
+	}
+
+	fmt.Fprintf(stderr, "INFO: Job %s sent\n", job.JobID)
+
+	return CupsBackendOK
+}
+
+// cupsExitCode returns the exit code of a CUPS backend for the error of the given LprSend.
+func cupsExitCode(lpr *LprSend, err error) CupsExitCode {
+	if lpr.retryable(err) {
+		return CupsBackendRetry
+	}
+
+	return CupsBackendFailed
+}
+
+// spoolCupsJob copies the job read from the reader into a temporary file in the given directory
+// (the default directory for temporary files if empty) and returns its name.
+func spoolCupsJob(reader io.Reader, dir string) (string, error) {
+	file, err := os.CreateTemp(dir, "lpr_cups_*")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(file, reader)
+	if cErr := file.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
@@ -0,0 +1,135 @@
+package lprlib
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCupsJob(t *testing.T) {
+	env := map[string]string{
+		"DEVICE_URI":   "lpd://printer:1515/office%20queue?banner=on&format=o",
+		"CONTENT_TYPE": "application/postscript",
+	}
+
+	job, err := ParseCupsJob([]string{"lpd", "42", "jdoe", "Invoice 42", "3", `media=A4 job-sheets='none,none' landscape title="a \"b\""`, "/tmp/d00042-001"}, func(name string) string {
+		return env[name]
+	})
+	require.Nil(t, err)
+	require.Equal(t, "42", job.JobID)
+	require.Equal(t, "jdoe", job.User)
+	require.Equal(t, "Invoice 42", job.Title)
+	require.Equal(t, 3, job.Copies)
+	require.Equal(t, "/tmp/d00042-001", job.File)
+	require.Equal(t, "application/postscript", job.ContentType)
+	require.Equal(t, "printer", job.Hostname)
+	require.Equal(t, uint16(1515), job.Port)
+	require.Equal(t, "office queue", job.Queue)
+	require.Equal(t, map[string]string{"banner": "on", "format": "o"}, job.URIOptions)
+	require.Equal(t, map[string]string{"media": "A4", "job-sheets": "none,none", "landscape": "true", "title": `a "b"`}, job.Options)
+
+	// the copies of jobs read from stdin are produced by the filters
+	job, err = ParseCupsJob([]string{"lpd", "42", "jdoe", "Invoice 42", "3", ""}, func(name string) string {
+		return env[name]
+	})
+	require.Nil(t, err)
+	require.Equal(t, 1, job.Copies)
+	require.Equal(t, "", job.File)
+	require.Equal(t, map[string]string{}, job.Options)
+
+	_, err = ParseCupsJob([]string{"lpd", "42", "jdoe"}, os.Getenv)
+	require.NotNil(t, err)
+
+	env["DEVICE_URI"] = "lpd://printer"
+	_, err = ParseCupsJob([]string{"lpd", "42", "jdoe", "Invoice 42", "3", ""}, func(name string) string {
+		return env[name]
+	})
+	require.NotNil(t, err)
+}
+
+func TestRunCupsBackend(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+
+	env := map[string]string{"DEVICE_URI": "lpd://127.0.0.1:2345/office?banner=on&format=o", "TMPDIR": t.TempDir()}
+	getenv := func(name string) string {
+		return env[name]
+	}
+
+	// the job is read from stdin
+	var stdout, stderr bytes.Buffer
+	code := RunCupsBackend([]string{"lpd", "42", "jdoe", "Invoice 42", "2", ""}, getenv, strings.NewReader("%!PS-Adobe-3.0\n"), &stdout, &stderr)
+	require.Equal(t, CupsBackendOK, code, stderr.String())
+	require.Contains(t, stderr.String(), "INFO: Job 42 sent")
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "office", conn.PrqName)
+	require.Equal(t, "jdoe", conn.UserIdentification)
+	require.Equal(t, "Invoice 42", conn.JobName)
+	require.True(t, conn.PrintBanner)
+	require.Equal(t, 1, len(conn.ControlFile.PrintFiles))
+	require.Equal(t, FormatPostScript, Format(conn.ControlFile.PrintFiles[0].Format))
+
+	data, err := os.ReadFile(conn.SaveName)
+	require.Nil(t, err)
+	require.Equal(t, "%!PS-Adobe-3.0\n", string(data))
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// the spool file was removed
+	entries, err := os.ReadDir(env["TMPDIR"])
+	require.Nil(t, err)
+	require.Equal(t, 0, len(entries))
+
+	// the job is read from a file
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	code = RunCupsBackend([]string{"lpd", "43", "jdoe", "Text", "2", "", name}, getenv, nil, &stdout, &stderr)
+	require.Equal(t, CupsBackendOK, code, stderr.String())
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, 2, len(conn.ControlFile.PrintFiles))
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// long titles are truncated, control characters are removed
+	title := strings.Repeat("Long title ", 12)
+	code = RunCupsBackend([]string{"lpd", "44", "jdoe", "\t" + title, "1", "", name}, getenv, nil, &stdout, &stderr)
+	require.Equal(t, CupsBackendOK, code, stderr.String())
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, title[:99], conn.JobName)
+	require.Equal(t, title[:131], conn.Filename)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	lprd.Close()
+
+	// the printer is not reachable
+	stderr.Reset()
+	code = RunCupsBackend([]string{"lpd", "44", "jdoe", "Text", "1", "", name}, getenv, nil, &stdout, &stderr)
+	require.Equal(t, CupsBackendRetry, code)
+	require.True(t, strings.HasPrefix(stderr.String(), "INFO: Sending job 44"))
+	require.Contains(t, stderr.String(), "ERROR: Can't send job 44")
+
+	code = RunCupsBackend([]string{"lpd", "44"}, getenv, nil, &stdout, &stderr)
+	require.Equal(t, CupsBackendFailed, code)
+
+	// device discovery
+	stdout.Reset()
+	code = RunCupsBackend([]string{"lpd"}, getenv, nil, &stdout, &stderr)
+	require.Equal(t, CupsBackendOK, code)
+	require.Equal(t, "network lpd \"Unknown\" \"LPD/LPR Host or Printer\"\n", stdout.String())
+}
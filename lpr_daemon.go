@@ -1,19 +1,26 @@
 package lprlib
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"math/rand"
+	"math"
 	"net"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 	"unicode/utf8"
 
+	"github.com/documatrix/go-lprlib/pjl"
 	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/ianaindex"
 )
@@ -21,26 +28,143 @@ import (
 type ConnectionType int
 
 const (
-	ConnectionTypePrintAnyWaitingJobs ConnectionType = 0
-	ConnectionTypeReceivePrintJob     ConnectionType = 1
-	ConnectionTypeSendQueueStateShort ConnectionType = 2
-	ConnectionTypeSendQueueStateLong  ConnectionType = 3
-	ConnectionTypeRemoveJobs          ConnectionType = 4
-	ConnectionTypeUnknown             ConnectionType = 5
+	ConnectionTypePrintAnyWaitingJobs  ConnectionType = 0
+	ConnectionTypeReceivePrintJob      ConnectionType = 1
+	ConnectionTypeSendQueueStateShort  ConnectionType = 2
+	ConnectionTypeSendQueueStateLong   ConnectionType = 3
+	ConnectionTypeRemoveJobs           ConnectionType = 4
+	ConnectionTypeUnknown              ConnectionType = 5
+	ConnectionTypeRaw                  ConnectionType = 6
+	ConnectionTypeIppPrintJob          ConnectionType = 7
+	ConnectionTypeIppPrinterAttributes ConnectionType = 8
 )
 
+// String returns the name of the connection type.
+func (t ConnectionType) String() string {
+	switch t {
+	case ConnectionTypePrintAnyWaitingJobs:
+		return "PrintAnyWaitingJobs"
+	case ConnectionTypeReceivePrintJob:
+		return "ReceivePrintJob"
+	case ConnectionTypeSendQueueStateShort:
+		return "SendQueueStateShort"
+	case ConnectionTypeSendQueueStateLong:
+		return "SendQueueStateLong"
+	case ConnectionTypeRemoveJobs:
+		return "RemoveJobs"
+	case ConnectionTypeRaw:
+		return "Raw"
+	case ConnectionTypeIppPrintJob:
+		return "IppPrintJob"
+	case ConnectionTypeIppPrinterAttributes:
+		return "IppPrinterAttributes"
+	default:
+		return "Unknown"
+	}
+}
+
+// DefaultMaxCommandSize is the maximum size of a command line, if LprDaemon.MaxCommandSize is not set.
+const DefaultMaxCommandSize = 64 * 1024
+
+// DefaultMaxControlFileSize is the maximum size of a control file, if LprDaemon.MaxControlFileSize is not set.
+const DefaultMaxControlFileSize = 4 * 1024 * 1024
+
+// DefaultFinishedConnectionsSize is the capacity of the FinishedConnections channel,
+// if LprDaemon.FinishedConnectionsSize is not set.
+const DefaultFinishedConnectionsSize = 100
+
 type QueueState func(queue string, list string, long bool) string
 
-type ExternalIDCallbackFunc func() uint64
+// QueueStateRequest describes a request of the queue state (03 - Send queue state).
+type QueueStateRequest struct {
+	// RemoteAddr is the address of the requesting client
+	RemoteAddr net.Addr
+
+	// Queue is the name of the requested queue
+	Queue string
+
+	// List contains the user names or job numbers the client is interested in (may be empty)
+	List []string
+
+	// Long tells if the long format was requested (04 - Send queue state (long))
+	Long bool
+}
+
+// QueueStateContextFunc returns the queue state for the given request.
+// ctx is canceled if the connection is aborted (e.g. by LprDaemon.Shutdown).
+type QueueStateContextFunc func(ctx context.Context, request QueueStateRequest) (string, error)
+
+// PrintWaitingJobsFunc is called if a client requests to print any waiting jobs of queue.
+type PrintWaitingJobsFunc func(queue string) error
+
+// DataSinkFactoryFunc creates the destination for the data file of job.
+// The returned writer is closed once the data file was received.
+type DataSinkFactoryFunc func(job *LprConnection) (io.WriteCloser, error)
+
+// RemoveJobsFunc is called if a client requests to remove the given jobs (user names or job numbers)
+// from queue. agent is the name of the user requesting the removal.
+type RemoveJobsFunc func(queue string, agent string, jobs []string) error
 
-func init() {
-	rand.Seed(time.Now().UnixMicro())
+// NackCode is a non-zero byte sent to the client as negative acknowledgement.
+// RFC 1179 only distinguishes zero (success) and non-zero (failure), the values
+// of the constants follow the common interpretation of LPRng.
+type NackCode byte
+
+const (
+	// NackFailure is the generic negative acknowledgement.
+	NackFailure NackCode = 1
+
+	// NackRetry tells the client, that the request failed temporarily (e.g. not enough disk space)
+	// and may be retried later.
+	NackRetry NackCode = 2
+
+	// NackRejected tells the client, that the request was rejected permanently
+	// (e.g. unknown queue, unauthorized host or a too large job).
+	NackRejected NackCode = 3
+)
+
+// NackError can be returned by callbacks (e.g. OnReceiveJob, RemoveJobs or DataSinkFactory)
+// to choose the negative acknowledgement sent to the client.
+// Other errors are answered with NackFailure.
+type NackError struct {
+	Code NackCode
+	Err  error
+}
+
+func (e *NackError) Error() string {
+	return e.Err.Error()
 }
 
+func (e *NackError) Unwrap() error {
+	return e.Err
+}
+
+// nackCodeOf returns the code of the NackError in the chain of err, or fallback.
+func nackCodeOf(err error, fallback NackCode) NackCode {
+	var nackErr *NackError
+	if errors.As(err, &nackErr) && nackErr.Code != 0 {
+		return nackErr.Code
+	}
+
+	return fallback
+}
+
+// AuthorizeRemoveJobsFunc is called if agent at remoteAddr requests to remove the given jobs from queue.
+// If it returns an error, the request is rejected.
+type AuthorizeRemoveJobsFunc func(remoteAddr net.Addr, agent string, queue string, jobs []string) error
+
+// MailRequestFunc is called if the client requested to notify mailbox when job is printed.
+type MailRequestFunc func(job *LprConnection, mailbox string)
+
+// ReceiveJobFunc is called if a client at remoteAddr wants to send a print job to queue.
+// If it returns an error, the job is rejected.
+type ReceiveJobFunc func(remoteAddr net.Addr, queue string) error
+
+type ExternalIDCallbackFunc func() uint64
+
 // LprDaemon structure
 type LprDaemon struct {
 	finishedConns chan *LprConnection
-	connections   chan *LprConnection
 
 	// closeSocket is used to notify the Listen method, that the socket should be closed.
 	// It is closed by the Close method to notify, that an error returned from Accept means "stop".
@@ -48,10 +172,71 @@ type LprDaemon struct {
 
 	socket net.Listener
 
+	// additionalSockets contains the additional listeners accepting raw print jobs (see ServeRawListener)
+	// and IPP requests (see ServeIppListener).
+	additionalSockets []net.Listener
+
+	// additionalListeners counts the running accept loops of the additionalSockets.
+	additionalListeners sync.WaitGroup
+
+	// listenersClosed is set by closeListeners, listeners added afterwards are rejected.
+	listenersClosed bool
+	listenersMutex  sync.Mutex
+
+	// spooler is the Spooler running for the daemon, which answers queue state requests (see Spooler.Run).
+	spooler      *Spooler
+	spoolerMutex sync.Mutex
+
+	// RawQueue is the queue name (see LprConnection.PrqName) of the jobs received by raw listeners
+	// (see ServeRawListener). If empty, DefaultRawQueue is used.
+	RawQueue string
+
+	// FinishedConnectionsSize is the capacity of the FinishedConnections channel.
+	// If the channel is full, the connections wait until the application received a finished connection.
+	// If 0, DefaultFinishedConnectionsSize is used.
+	FinishedConnectionsSize int
+
+	// OnFinishedConnection will be called for every finished connection (and every received job)
+	// instead of delivering it to the FinishedConnections channel.
+	// It is called from the goroutine processing the connection and must be safe for concurrent use.
+	OnFinishedConnection func(conn *LprConnection)
+
 	// GetQueueState will be called if a client requests the queue state.
-	// If not set, "Idle" will be returned.
+	// If not set, the queue state is generated from the jobs of a running Spooler of the daemon
+	// (see Spooler.QueueState) or "Idle" will be returned.
 	GetQueueState QueueState
 
+	// GetQueueStateContext will be called if a client requests the queue state.
+	// It takes precedence over GetQueueState.
+	// If it returns an error, the connection is closed without sending a queue state.
+	GetQueueStateContext QueueStateContextFunc
+
+	// PrintWaitingJobs will be called if a client requests to print any waiting jobs.
+	// No response is sent to the client, as defined by RFC 1179.
+	PrintWaitingJobs PrintWaitingJobsFunc
+
+	// RemoveJobs will be called if a client requests to remove jobs.
+	// If it returns nil, a positive acknowledgement will be sent, otherwise a negative one (see NackError).
+	// If not set, the jobs are removed from a running Spooler of the daemon (see Spooler.Cancel)
+	// or all remove jobs requests will be rejected.
+	RemoveJobs RemoveJobsFunc
+
+	// AuthorizeRemoveJobs will be called before RemoveJobs, e.g. to ensure that users may only remove
+	// their own jobs. If it returns an error, a negative acknowledgement (NackRejected, see NackError)
+	// is sent and RemoveJobs is not called.
+	AuthorizeRemoveJobs AuthorizeRemoveJobsFunc
+
+	// Queues contains the names of the valid queues. Requests for other queues are rejected
+	// (with a negative acknowledgement or an error message as queue state).
+	// If empty, all queue names are accepted.
+	Queues []string
+
+	// OnReceiveJob will be called if a client wants to send a print job, before it is acknowledged.
+	// If it returns an error, a negative acknowledgement is sent and the connection is closed
+	// (see NackError).
+	// If not set, all print jobs are accepted.
+	OnReceiveJob ReceiveJobFunc
+
 	// InputFileSaveDir is the directory into which received files will be saved.
 	// If empty, the default system temp directory will be used.
 	// if nil set, a temp file will be used instead of the directory
@@ -61,66 +246,466 @@ type LprDaemon struct {
 	// The trace file will be saved into the InputFileSaveDir or system temp directory.
 	Trace bool
 
+	// AtomicWrites states if data files should be written to a temporary part file, which is
+	// renamed to the SaveName only after the data file was received completely.
+	// Part files of aborted transfers are removed, so a SaveName always refers to a complete file.
+	AtomicWrites bool
+
+	// SyncFiles states if received data files should be flushed to disk (fsync) before
+	// the receipt is acknowledged.
+	SyncFiles bool
+
+	// CheckDiskSpace states if the available disk space of the InputFileSaveDir should be checked
+	// before a data file is accepted. Data files which would leave less than DiskSpaceMargin bytes
+	// available are rejected with a negative acknowledgement.
+	CheckDiskSpace bool
+
+	// DiskSpaceMargin is the number of bytes which must stay available (see CheckDiskSpace).
+	DiskSpaceMargin uint64
+
+	// MaxJobSize is the maximum size of a data file in bytes.
+	// Data files announced with a larger size are rejected with a negative acknowledgement,
+	// data files exceeding the size while being received are aborted.
+	// If 0, the size is not limited.
+	MaxJobSize uint64
+
+	// VerifySize rejects data files whose received size differs from the announced size
+	// with a negative acknowledgement. Data files with an unknown size (0) or a dummy size
+	// (see WindowsQuirks) are always accepted.
+	// Independent of this setting, the result is available in LprConnection.SizeVerified.
+	VerifySize bool
+
+	// Compatibility is the CompatibilityProfile of the clients sending jobs to the daemon,
+	// e.g. ProfileSAP or ProfileAS400. If nil, RFC-1179 is followed strictly.
+	Compatibility *CompatibilityProfile
+
+	// QueueCompatibility overrides the Compatibility for jobs sent to the queues
+	// which are the keys of the map.
+	QueueCompatibility map[string]*CompatibilityProfile
+
+	// WindowsQuirks enables the compatibility with the LPR port monitor of Windows:
+	// Without "LPR Byte Counting", Windows announces a dummy size for data files and closes
+	// the connection after sending the data. Data files announced with a size above
+	// WindowsDummyFileSize are received until the connection is closed (or the end of the
+	// announced size), the dummy size is neither checked against the MaxJobSize nor the
+	// available disk space. Data files ending before the announced size because the client
+	// closed the connection are accepted as well (see LprConnection.SizeVerified).
+	// Carriage returns at the end of control file lines are removed.
+	// Without it, data files announced with a size of 0 or above WindowsDummyFileSize are
+	// received until the connection is closed as well, but a dummy size is checked like any
+	// other size (e.g. rejected by VerifySize).
+	WindowsQuirks bool
+
+	// DisableZeroCopy disables copying data files directly from the connection into the output file,
+	// which is done if the data is written to a plain file and neither a ChecksumHash nor a read timeout is set.
+	DisableZeroCopy bool
+
+	// ReceiveBufferSize is the size of the buffer used to read from a connection in bytes,
+	// e.g. 256 KiB for large raster jobs on fast networks. For TCP connections, the receive buffer
+	// of the operating system is set to the same size.
+	// If 0, 8192 bytes are used.
+	ReceiveBufferSize int64
+
+	// KeepAlive is the period of the TCP keepalive probes of accepted connections, e.g. to keep
+	// long transfers through NAT devices alive. If negative, keepalive is disabled.
+	// If 0, the default of Go (enabled, 15 seconds) is used.
+	KeepAlive time.Duration
+
+	// DisableNoDelay enables Nagle's algorithm for accepted TCP connections,
+	// which delays small writes (TCP_NODELAY is set per default).
+	DisableNoDelay bool
+
+	// MaxCommandSize is the maximum size of a command line in bytes.
+	// It limits the attributes of IPP requests as well (see ServeIppListener).
+	// If 0, DefaultMaxCommandSize is used.
+	MaxCommandSize int
+
+	// MaxCommandDuration is the maximum time between receiving the first byte of a command line
+	// and its end, which protects against clients sending a command very slowly.
+	// If 0, the duration is only limited by the idle timeout (see SetConnectionTimeout).
+	MaxCommandDuration time.Duration
+
+	// MaxControlFileSize is the maximum size of a control file in bytes.
+	// Control files announced with a larger size are rejected with a negative acknowledgement.
+	// If 0, DefaultMaxControlFileSize is used.
+	MaxControlFileSize uint64
+
+	// ChecksumHash creates the hash which is used to compute the checksum of received data files
+	// (see LprConnection.Checksum), e.g. sha256.New.
+	// If not set, no checksum is computed.
+	ChecksumHash func() hash.Hash
+
+	// ParsePJL enables parsing the PJL job header at the start of received data files
+	// (see LprConnection.PJL), which contains the job information of many printer drivers.
+	ParsePJL bool
+
+	// DetectFormat enables detecting the format of received data files from their first bytes
+	// (see LprConnection.DetectedFormat and DetectDocumentFormat).
+	DetectFormat bool
+
+	// ParseDSC enables parsing the DSC comments (e.g. %%Title, %%Pages and %%Creator) of received PostScript
+	// data files while they are received (see LprConnection.DSC), e.g. for page accounting.
+	ParseDSC bool
+
+	// FileNameTemplate is used to name the data files saved into the InputFileSaveDir.
+	// The placeholders {queue}, {jobnumber}, {host}, {user}, {jobname}, {filename}, {timestamp} and {random}
+	// are replaced with the values of the job, e.g. "{queue}_{jobnumber}_{user}_{timestamp}".
+	// The values are sanitized (see SanitizeFileName), {filename} is the base name of the source file (N).
+	// Values of the control file are empty if the data file is received first.
+	// If empty, random file names are used.
+	FileNameTemplate string
+
+	// InMemoryThreshold is the maximum announced size of data files which are kept in memory
+	// (see LprConnection.Data) instead of being saved into the InputFileSaveDir.
+	// If 0, all data files are saved to disk.
+	InMemoryThreshold uint64
+
+	// DataSinkFactory creates the destination for received data files.
+	// If not set, the data files are saved into the InputFileSaveDir.
+	// If the returned writer is an *os.File, SaveName is set to its name.
+	DataSinkFactory DataSinkFactoryFunc
+
+	// CleanupMaxAge enables a Janitor which removes trace files, part files and data files
+	// (using the default naming) from the InputFileSaveDir, once they are older than CleanupMaxAge.
+	// Received files must be moved or removed by the application before.
+	// If 0, no files are removed.
+	CleanupMaxAge time.Duration
+
+	// CleanupInterval is the time between two cleanups (see CleanupMaxAge).
+	// If 0, CleanupMaxAge is used.
+	CleanupInterval time.Duration
+
+	// Compressor compresses data files while they are saved into the InputFileSaveDir,
+	// e.g. GzipCompressor{}. The extension of the Compressor is appended to the SaveName.
+	// Data files kept in memory or written to a DataSinkFactory are not compressed.
+	Compressor Compressor
+
+	// HonorUnlink states if the files of a job are removed by LprConnection.Printed,
+	// if the client requested it (U - Unlink data file), as classic BSD lpr clients expect.
+	HonorUnlink bool
+
+	// GenerateBanner will be called for every received job requesting a banner page (L - Print banner page).
+	// The returned document is prepended to the data file (the Checksum still describes the received data).
+	// Data files written to a DataSinkFactory are not changed.
+	GenerateBanner BannerFunc
+
+	// OnMailRequest will be called for every completely received job, whose control file contains
+	// a mail request (M - Mail When Printed). The job has not been delivered to FinishedConnections yet.
+	// As the daemon does not print the job itself, the application has to send the mail once it is printed.
+	OnMailRequest MailRequestFunc
+
+	// AuditLog records every processed connection (and every received job), e.g. using an AuditWriter.
+	AuditLog AuditLogger
+
+	// OnJobEvent will be called whenever the state of a received job changes.
+	// It is called from the goroutine processing the connection, so it should return quickly.
+	OnJobEvent JobEventFunc
+
+	// OnProgress will be called while a data file is received.
+	// It is called from the goroutine processing the connection, so it should return quickly.
+	OnProgress ProgressFunc
+
+	// ProgressInterval is the minimum time between two calls of OnProgress (and DataFileProgress events)
+	// for the same data file. The first and the last block of a data file are always reported.
+	// If 0, every received block is reported.
+	ProgressInterval time.Duration
+
+	// SaveControlFile states if the LprDaemon should save the received control file
+	// next to the data file (SaveName with the suffix ".cf").
+	SaveControlFile bool
+
 	fallbackDecoder *encoding.Decoder
 
 	fileMask os.FileMode
 
+	// readTimeout is the maximum time a connection waits for data while receiving a control or data file.
+	readTimeout time.Duration
+
+	// idleTimeout is the maximum time a connection waits for the next command.
+	idleTimeout time.Duration
+
 	GetExternalID ExternalIDCallbackFunc
+
+	// ExternalIDConcurrency is the maximum number of concurrent GetExternalID calls.
+	// If 0, GetExternalID is called for one job after another in the order the jobs arrived.
+	// Connections which do not receive a print job never wait for GetExternalID.
+	ExternalIDConcurrency int
+
+	// PreserveExternalIDOrder starts the GetExternalID calls in the order the jobs arrived,
+	// even if ExternalIDConcurrency allows concurrent calls.
+	PreserveExternalIDOrder bool
+
+	// externalIDSlots limits the number of concurrent GetExternalID calls.
+	externalIDSlots chan struct{}
+
+	// lastExternalIDTurn is closed once the external ID generation of the last arrived job was started.
+	// It is used to preserve the order of the GetExternalID calls.
+	lastExternalIDTurn chan struct{}
+	externalIDMutex    sync.Mutex
+
+	// AllowedHosts contains the networks (e.g. "192.168.0.0/16") or IP addresses of the clients
+	// which may connect to the daemon. If empty, all clients not listed in DeniedHosts are allowed.
+	// Connections without IP address (e.g. via unix sockets) are not checked.
+	AllowedHosts []string
+
+	// DeniedHosts contains the networks or IP addresses of the clients which must not connect to the daemon.
+	// It takes precedence over AllowedHosts.
+	DeniedHosts []string
+
+	// NackDeniedHosts states if a negative acknowledgement (NackRejected) is sent to clients which
+	// are not allowed to connect, before the connection is closed.
+	NackDeniedHosts bool
+
+	allowedNetworks []*net.IPNet
+	deniedNetworks  []*net.IPNet
+
+	// MaxConcurrentConnections is the maximum number of connections which are processed at the same time.
+	// Connections accepted while the limit is reached are closed immediately.
+	// If 0, the number of connections is not limited.
+	MaxConcurrentConnections int
+
+	// connectionSlots limits the number of running connections to MaxConcurrentConnections.
+	connectionSlots chan struct{}
+
+	// Workers is the number of goroutines processing the accepted connections.
+	// If 0, every connection is processed by its own goroutine.
+	Workers int
+
+	// AcceptQueueSize is the number of accepted connections waiting for a free worker (see Workers).
+	// Connections accepted while the queue is full are closed immediately.
+	AcceptQueueSize int
+
+	// acceptQueue contains the accepted connections waiting for a worker.
+	acceptQueue chan acceptedConn
+
+	// runningConns contains all connections which are currently processed.
+	runningConns      map[*LprConnection]struct{}
+	runningConnsMutex sync.Mutex
+
+	// aborting is set by abortRunningConnections, connections started afterwards are aborted immediately.
+	aborting bool
+
+	// ReuseConnections enables recycling of LprConnection objects.
+	// If set, the consumer of a finished connection should call LprConnection.Release once it is done with it,
+	// so that the object and its receive buffer can be reused for a later connection.
+	ReuseConnections bool
+
+	// connectionPool contains the released connections if ReuseConnections is set.
+	connectionPool sync.Pool
+
+	// listenDone is closed once the Listen method returned.
+	listenDone chan struct{}
+
+	// ctx is the context the daemon was started with.
+	// Once it is canceled, the listener is closed and all running connections are aborted.
+	ctx context.Context
 }
 
 // Init is the constructor
 // port ist the tcp port where the daemon should listen default 515
 // ipAddress of the daemon default own ip
 func (lpr *LprDaemon) Init(port uint16, ipAddress string) error {
+	return lpr.InitContext(context.Background(), port, ipAddress)
+}
+
+// InitContext is like Init, but ties the lifetime of the daemon to ctx.
+// Canceling ctx closes the listener and aborts all running connections.
+func (lpr *LprDaemon) InitContext(ctx context.Context, port uint16, ipAddress string) error {
 
 	if port == 0 {
 		port = 515
 	}
 
+	listenAddr := net.JoinHostPort(ipAddress, strconv.Itoa(int(port)))
+	logDebugf("Listening on: %s", listenAddr)
+
+	socket, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return &LprError{"Can't listen to " + listenAddr + " : " + err.Error()}
+	}
+
+	return lpr.ServeListenerContext(ctx, socket)
+}
+
+// InitUnix is like Init, but the daemon listens on the unix domain socket socketPath instead of a tcp port.
+// If mode is not 0, the permissions of the socket file are set to mode, so that file permissions
+// can be used to restrict the access to the daemon.
+// The socket file is removed by Close.
+func (lpr *LprDaemon) InitUnix(socketPath string, mode os.FileMode) error {
+	return lpr.InitUnixContext(context.Background(), socketPath, mode)
+}
+
+// InitUnixContext is like InitUnix, but ties the lifetime of the daemon to ctx.
+// Canceling ctx closes the listener and aborts all running connections.
+func (lpr *LprDaemon) InitUnixContext(ctx context.Context, socketPath string, mode os.FileMode) error {
+	logDebugf("Listening on unix socket: %s", socketPath)
+
+	socket, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return &LprError{"Can't listen to " + socketPath + " : " + err.Error()}
+	}
+
+	if mode != 0 {
+		err = os.Chmod(socketPath, mode)
+		if err != nil {
+			socket.Close()
+			return &LprError{"Can't change mode of " + socketPath + " : " + err.Error()}
+		}
+	}
+
+	return lpr.ServeListenerContext(ctx, socket)
+}
+
+// ServeListener initializes the daemon to accept connections from the given listener
+// instead of opening its own tcp socket (e.g. a pre-bound socket or a TLS listener).
+// The listener will be closed by Close.
+func (lpr *LprDaemon) ServeListener(listener net.Listener) error {
+	return lpr.ServeListenerContext(context.Background(), listener)
+}
+
+// ServeListenerContext is like ServeListener, but ties the lifetime of the daemon to ctx.
+// Canceling ctx closes the listener and aborts all running connections.
+func (lpr *LprDaemon) ServeListenerContext(ctx context.Context, listener net.Listener) error {
 	if err := lpr.SetFallbackEncoding("windows-1252"); err != nil {
+		listener.Close()
+		return err
+	}
+
+	if err := lpr.checkCompatibility(); err != nil {
+		listener.Close()
 		return err
 	}
 
+	var err error
+	lpr.allowedNetworks, err = parseNetworks(lpr.AllowedHosts)
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("invalid allowed hosts: %w", err)
+	}
+
+	lpr.deniedNetworks, err = parseNetworks(lpr.DeniedHosts)
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("invalid denied hosts: %w", err)
+	}
+
 	lpr.fileMask = 0600
 
-	lpr.finishedConns = make(chan *LprConnection, 100)
-	lpr.connections = make(chan *LprConnection, 100)
+	finishedConnsSize := lpr.FinishedConnectionsSize
+	if finishedConnsSize <= 0 {
+		finishedConnsSize = DefaultFinishedConnectionsSize
+	}
+	lpr.finishedConns = make(chan *LprConnection, finishedConnsSize)
+	externalIDConcurrency := lpr.ExternalIDConcurrency
+	if externalIDConcurrency <= 0 {
+		externalIDConcurrency = 1
+	}
+	lpr.externalIDSlots = make(chan struct{}, externalIDConcurrency)
+	lpr.lastExternalIDTurn = nil
 	lpr.closeSocket = make(chan bool)
+	lpr.ctx = ctx
+	lpr.socket = listener
+	lpr.additionalSockets = nil
+	lpr.listenersClosed = false
 
-	listenAddr := fmt.Sprintf(":%d", port)
-	logDebugf("Listening on: %s", listenAddr)
+	lpr.runningConns = make(map[*LprConnection]struct{})
+	lpr.listenDone = make(chan struct{})
 
-	var err error
-	lpr.socket, err = net.Listen("tcp", listenAddr)
-	if err != nil {
-		return &LprError{"Can't listen to " + listenAddr + " : " + err.Error()}
+	lpr.connectionSlots = nil
+	if lpr.MaxConcurrentConnections > 0 {
+		lpr.connectionSlots = make(chan struct{}, lpr.MaxConcurrentConnections)
+	}
+
+	lpr.acceptQueue = nil
+	if lpr.Workers > 0 {
+		lpr.acceptQueue = make(chan acceptedConn, lpr.AcceptQueueSize)
 	}
+	lpr.aborting = false
 
-	go lpr.externalIDGenerator()
+	go lpr.closeOnDone()
 	go lpr.Listen()
 
+	if lpr.CleanupMaxAge > 0 {
+		go lpr.runJanitor()
+	}
+
 	return nil
 }
 
-func (lpr *LprDaemon) externalIDGenerator() {
-	for conn := range lpr.connections {
-		lpr.generateExternalID(conn)
+// runJanitor removes stale files from the InputFileSaveDir until the daemon is closed.
+func (lpr *LprDaemon) runJanitor() {
+	ctx, cancel := context.WithCancel(lpr.ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-lpr.closeSocket:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	janitor := Janitor{
+		Dir:      lpr.InputFileSaveDir,
+		MaxAge:   lpr.CleanupMaxAge,
+		Interval: lpr.CleanupInterval,
+	}
+	janitor.Run(ctx)
+}
+
+// closeOnDone closes the listeners once the daemon's context is canceled.
+func (lpr *LprDaemon) closeOnDone() {
+	select {
+	case <-lpr.ctx.Done():
+		logDebugf("Context done (%v), closing socket", lpr.ctx.Err())
+		lpr.closeListeners()
+	case <-lpr.closeSocket:
 	}
 }
 
-func (lpr *LprDaemon) generateExternalID(conn *LprConnection) {
-	defer close(conn.externalIDChan)
+// closeListeners closes the additional listeners and the listener of the daemon.
+func (lpr *LprDaemon) closeListeners() {
+	lpr.listenersMutex.Lock()
+	lpr.listenersClosed = true
+	for _, listener := range lpr.additionalSockets {
+		err := listener.Close()
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			logErrorf("Error closing socket %s: %s", listener.Addr(), err.Error())
+		}
+	}
+	lpr.listenersMutex.Unlock()
 
-	connectionType := <-conn.typeChan
-	if connectionType != ConnectionTypeReceivePrintJob {
-		return
+	err := lpr.socket.Close()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		logErrorf("Error closing socket: %s", err.Error())
+	}
+}
+
+// maxCommandSize returns the MaxCommandSize or DefaultMaxCommandSize.
+func (lpr *LprDaemon) maxCommandSize() int {
+	if lpr.MaxCommandSize <= 0 {
+		return DefaultMaxCommandSize
+	}
+
+	return lpr.MaxCommandSize
+}
+
+// maxControlFileSize returns the MaxControlFileSize or DefaultMaxControlFileSize.
+func (lpr *LprDaemon) maxControlFileSize() uint64 {
+	if lpr.MaxControlFileSize == 0 {
+		return DefaultMaxControlFileSize
 	}
 
-	extID := uint64(0)
-	if lpr.GetExternalID != nil {
-		extID = lpr.GetExternalID()
+	return lpr.MaxControlFileSize
+}
+
+// fileExtension returns the extension of saved data files.
+func (lpr *LprDaemon) fileExtension() string {
+	if lpr.Compressor == nil {
+		return ""
 	}
-	conn.externalIDChan <- extID
+
+	return lpr.Compressor.Extension()
 }
 
 // SetFileMask can be used to set the file mask which should be applied to the
@@ -129,6 +714,16 @@ func (lpr *LprDaemon) SetFileMask(fileMask os.FileMode) {
 	lpr.fileMask = fileMask
 }
 
+// SetConnectionTimeout sets the timeouts which are applied to new connections.
+// readTimeout is the maximum time to wait for data while a control or data file is received,
+// idleTimeout is the maximum time to wait for the next command of a client.
+// A connection exceeding one of the timeouts is closed and ends with the status Error.
+// A timeout of 0 means no timeout, which is the default.
+func (lpr *LprDaemon) SetConnectionTimeout(readTimeout time.Duration, idleTimeout time.Duration) {
+	lpr.readTimeout = readTimeout
+	lpr.idleTimeout = idleTimeout
+}
+
 // SetFallbackEncoding sets the given encoding as fallback encoding.
 // Will be used to decode any received non-utf8 string values like Filename, PrqName, UserIdentification, etc.
 // Will not be applied to any received file contents.
@@ -144,71 +739,303 @@ func (lpr *LprDaemon) SetFallbackEncoding(encodingName string) error {
 	return nil
 }
 
+// listenerProtocol is the protocol spoken by the clients of a listener of the daemon.
+type listenerProtocol int
+
+const (
+	// protocolLpr is spoken by the clients of the listener passed to ServeListener
+	protocolLpr listenerProtocol = 0
+
+	// protocolRaw is spoken by the clients of raw listeners (see ServeRawListener)
+	protocolRaw listenerProtocol = 1
+
+	// protocolIpp is spoken by the clients of IPP listeners (see ServeIppListener)
+	protocolIpp listenerProtocol = 2
+)
+
+// acceptedConn is an accepted connection waiting for a worker.
+type acceptedConn struct {
+	conn net.Conn
+
+	// protocol is the protocol of the listener which accepted the connection
+	protocol listenerProtocol
+}
+
 // Listen waits for a new connection and accept them
 func (lpr *LprDaemon) Listen() {
+	defer close(lpr.listenDone)
+
 	wg := sync.WaitGroup{}
 
+	if lpr.acceptQueue != nil {
+		for i := 0; i < lpr.Workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				lpr.worker()
+			}()
+		}
+	}
+
+	lpr.accept(lpr.socket, protocolLpr, &wg)
+
+	// the additional listeners are closed together with the listener, so they stop accepting as well
+	lpr.additionalListeners.Wait()
+
+	if lpr.acceptQueue != nil {
+		close(lpr.acceptQueue)
+	}
+
+	logDebug("Waiting for running connections to finish")
+	wg.Wait()
+
+	logDebug("Running connections finished")
+	close(lpr.finishedConns)
+}
+
+// accept accepts connections from the listener until the daemon is stopped and starts processing them
+// using the given protocol. The goroutines processing the connections are added to wg.
+func (lpr *LprDaemon) accept(listener net.Listener, protocol listenerProtocol, wg *sync.WaitGroup) {
 	for {
 		logDebug("Wait for next connection...")
-		newConn, err := lpr.socket.Accept()
+		newConn, err := listener.Accept()
 		if err != nil {
-			select {
-			case <-lpr.closeSocket:
-				logDebug("Waiting for running connections to finish")
-				wg.Wait()
+			if lpr.stopping() {
+				return
+			}
+			if protocol != protocolLpr && errors.Is(err, net.ErrClosed) {
+				logErrorf("Socket %s was closed", listener.Addr())
+				return
+			}
+
+			logError("Can't accept connection: " + err.Error())
+			continue
+		}
 
-				logDebug("Running connections finished")
-				close(lpr.finishedConns)
+		logDebug("Accepted Client")
 
-				// Inform the external ID generator, that it should stop
-				close(lpr.connections)
+		if !lpr.hostAllowed(newConn.RemoteAddr()) {
+			lpr.rejectHost(newConn, protocol)
+			continue
+		}
 
-				return
+		if !lpr.acquireConnectionSlot() {
+			logErrorf("Rejecting connection from %s: limit of %d concurrent connections reached", newConn.RemoteAddr(), lpr.MaxConcurrentConnections)
+			lpr.auditRejected(newConn.RemoteAddr(), "limit of concurrent connections reached")
+			newConn.Close()
+			continue
+		}
+
+		if lpr.acceptQueue != nil {
+			select {
+			case lpr.acceptQueue <- acceptedConn{conn: newConn, protocol: protocol}:
 			default:
+				logErrorf("Rejecting connection from %s: accept queue is full", newConn.RemoteAddr())
+				lpr.auditRejected(newConn.RemoteAddr(), "accept queue is full")
+				newConn.Close()
+				lpr.releaseConnectionSlot()
 			}
+			continue
+		}
 
-			logError("Can't accept connection: " + err.Error())
-		} else {
-			logDebug("Accepted Client")
+		wg.Add(1)
 
-			wg.Add(1)
+		newLprcon := lpr.newConnection(newConn, protocol)
+
+		go func() {
+			defer wg.Done()
+			lpr.serveConnection(newLprcon)
+		}()
+	}
+}
 
-			var newLprcon LprConnection
-			newLprcon.Init(newConn, 0, lpr)
+// worker processes the connections of the acceptQueue until it is closed.
+func (lpr *LprDaemon) worker() {
+	for accepted := range lpr.acceptQueue {
+		lpr.serveConnection(lpr.newConnection(accepted.conn, accepted.protocol))
+	}
+}
 
-			go func() {
-				newLprcon.RunConnection()
-				wg.Done()
-			}()
+// newConnection creates a running LprConnection for the given accepted connection,
+// which speaks the given protocol.
+func (lpr *LprDaemon) newConnection(conn net.Conn, protocol listenerProtocol) *LprConnection {
+	newLprcon := &LprConnection{}
+	if lpr.ReuseConnections {
+		if released, ok := lpr.connectionPool.Get().(*LprConnection); ok {
+			newLprcon = released
 		}
 	}
+	newLprcon.Init(conn, lpr.ReceiveBufferSize, lpr)
+	newLprcon.protocol = protocol
+
+	lpr.addRunningConnection(newLprcon)
+
+	return newLprcon
+}
+
+// serveConnection processes the given connection and releases its connection slot afterwards.
+// The connection is removed from the running connections by RunConnection.
+func (lpr *LprDaemon) serveConnection(conn *LprConnection) {
+	conn.RunConnection()
+	lpr.releaseConnectionSlot()
+}
+
+// acquireConnectionSlot reserves a slot for a new connection.
+// Returns false if MaxConcurrentConnections connections are already running.
+func (lpr *LprDaemon) acquireConnectionSlot() bool {
+	if lpr.connectionSlots == nil {
+		return true
+	}
+
+	select {
+	case lpr.connectionSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseConnectionSlot frees the slot reserved by acquireConnectionSlot.
+func (lpr *LprDaemon) releaseConnectionSlot() {
+	if lpr.connectionSlots != nil {
+		<-lpr.connectionSlots
+	}
+}
+
+func (lpr *LprDaemon) addRunningConnection(conn *LprConnection) {
+	lpr.runningConnsMutex.Lock()
+	defer lpr.runningConnsMutex.Unlock()
+
+	lpr.runningConns[conn] = struct{}{}
+	if lpr.aborting {
+		conn.cancel()
+	}
+}
+
+func (lpr *LprDaemon) removeRunningConnection(conn *LprConnection) {
+	lpr.runningConnsMutex.Lock()
+	defer lpr.runningConnsMutex.Unlock()
+
+	delete(lpr.runningConns, conn)
+}
+
+// abortedConnection describes a connection aborted by Shutdown.
+// The values are copied, as the connection may be released (see LprConnection.Release) once it is delivered.
+type abortedConnection struct {
+	remoteAddr net.Addr
+	queue      string
+	user       string
+}
+
+// abortRunningConnections aborts all connections which are currently processed and returns them.
+// The connections are described while the lock is held, as they aren't delivered before
+// they were removed from the running connections.
+func (lpr *LprDaemon) abortRunningConnections() []abortedConnection {
+	lpr.runningConnsMutex.Lock()
+	defer lpr.runningConnsMutex.Unlock()
+
+	lpr.aborting = true
+
+	aborted := make([]abortedConnection, 0, len(lpr.runningConns))
+	for conn := range lpr.runningConns {
+		conn.cancel()
+		aborted = append(aborted, abortedConnection{
+			remoteAddr: conn.Connection.RemoteAddr(),
+			queue:      conn.PrqName,
+			user:       conn.UserIdentification,
+		})
+	}
+
+	return aborted
+}
+
+// stopping tells if the daemon was closed or its context was canceled,
+// which means that an error returned from Accept means "stop".
+func (lpr *LprDaemon) stopping() bool {
+	select {
+	case <-lpr.closeSocket:
+		return true
+	case <-lpr.ctx.Done():
+		return true
+	default:
+		return false
+	}
 }
 
-// Close Closes all LprConnections and the listener
+// Close Closes all LprConnections and the listeners
 func (lpr *LprDaemon) Close() {
 	logDebug("Closing socket")
 
 	close(lpr.closeSocket)
 
-	err := lpr.socket.Close()
-	if err != nil {
-		logErrorf("Error closing socket: %s", err.Error())
+	lpr.closeListeners()
+}
+
+// Shutdown stops accepting new connections and waits for the running connections to finish.
+// If ctx is done before all connections finished, the remaining connections are aborted
+// (they are delivered with the status Error by FinishedConnections) and an error naming
+// the interrupted jobs is returned.
+func (lpr *LprDaemon) Shutdown(ctx context.Context) error {
+	lpr.Close()
+
+	select {
+	case <-lpr.listenDone:
+		return nil
+	case <-ctx.Done():
+	}
+
+	aborted := lpr.abortRunningConnections()
+
+	logDebugf("Aborted %d running connections, waiting for them to finish", len(aborted))
+	<-lpr.listenDone
+
+	jobs := ""
+	for i, conn := range aborted {
+		if i > 0 {
+			jobs += ", "
+		}
+		jobs += fmt.Sprintf("%s (queue %q, user %q)", conn.remoteAddr, conn.queue, conn.user)
 	}
+
+	return &LprError{fmt.Sprintf("Shutdown interrupted %d connections (%v): %s", len(aborted), ctx.Err(), jobs)}
 }
 
 // FinishedConnections returns a channel containing the finished connections.
 // The ConnectionStatus may be END or ERROR.
 // Will also contain LPR Queue State requests (check with SaveName != "").
+// If OnFinishedConnection is set, the channel stays empty and is only closed once the daemon stopped.
 func (lpr *LprDaemon) FinishedConnections() <-chan *LprConnection {
 	return lpr.finishedConns
 }
 
+// deliver passes a finished connection to the OnFinishedConnection handler or the FinishedConnections channel.
+func (lpr *LprDaemon) deliver(conn *LprConnection) {
+	if lpr.OnFinishedConnection != nil {
+		lpr.OnFinishedConnection(conn)
+		return
+	}
+
+	lpr.finishedConns <- conn
+}
+
+// decode converts the given value into an UTF-8 string (see ensureUTF8).
+func (lpr *LprDaemon) decode(value []byte) (string, error) {
+	decoded, _, err := lpr.ensureUTF8(value)
+	return decoded, err
+}
+
 // ensureUTF8 checks if the given value contains valid UTF-8 encoded runes.
 // If not, the function tries to decode the given value using the fallbackDecoder.
 func (lpr *LprDaemon) ensureUTF8(value []byte) (string, bool, error) {
+	return toUTF8(value, lpr.fallbackDecoder)
+}
+
+// toUTF8 checks if the given value contains valid UTF-8 encoded runes.
+// If not, the function tries to decode the given value using fallbackDecoder.
+func toUTF8(value []byte, fallbackDecoder *encoding.Decoder) (string, bool, error) {
 	valid := utf8.Valid(value)
 	if !valid {
-		decodedValue, err := lpr.fallbackDecoder.Bytes(value)
+		decodedValue, err := fallbackDecoder.Bytes(value)
 		if err != nil {
 			return string(value), valid, err
 		}
@@ -239,6 +1066,12 @@ type LprConnection struct {
 	// buffer contains read data from the socket
 	buffer []uint8
 
+	// reader buffers the data read from the socket, all reads have to use it
+	reader *bufio.Reader
+
+	// readDeadlineSet tells if a read deadline is set on the socket
+	readDeadlineSet bool
+
 	// processedDataBytes are the already read bytes from the connection
 	processedDataBytes uint64
 
@@ -269,12 +1102,73 @@ type LprConnection struct {
 	// ClassName Name of class for banner pages
 	ClassName string
 
+	// MailUser is the user who should be notified by mail when the job is printed
+	MailUser string
+
+	// PrintBanner tells if a banner page should be printed
+	PrintBanner bool
+
+	// Unlink tells if the client requested to remove the data file after printing (U - Unlink data file).
+	// See LprDaemon.HonorUnlink and Printed.
+	Unlink bool
+
+	// unlinkSink is the writer returned by the DataSinkFactory, if it implements UnlinkSink
+	unlinkSink UnlinkSink
+
+	// BannerUser is the user name which should be printed on the banner page
+	BannerUser string
+
 	// Filesize Filesize
 	Filesize uint64
 
+	// ReceivedSize is the number of bytes of the data file which were actually received
+	ReceivedSize uint64
+
+	// SizeVerified tells if the ReceivedSize matches the announced Filesize.
+	// It is false if the size of the data file was not announced (0).
+	SizeVerified bool
+
 	// Output output File
+	// Only set while the data file is received into a file.
 	Output *os.File
 
+	// Data contains the received data file, if it was kept in memory (see LprDaemon.InMemoryThreshold)
+	Data []byte
+
+	// CompressedSize is the size of the saved data file, if it was compressed (see LprDaemon.Compressor)
+	CompressedSize uint64
+
+	// UncompressedSize is the size of the data file before compression (see LprDaemon.Compressor)
+	UncompressedSize uint64
+
+	// Checksum is the checksum of the received data file computed by the LprDaemon.ChecksumHash
+	Checksum []byte
+
+	// hash computes the Checksum while the data file is received
+	hash hash.Hash
+
+	// PJL is the PJL job header of the data file if LprDaemon.ParsePJL is set and the data file has one
+	PJL *pjl.Header
+
+	// DetectedFormat is the format of the data file detected by DetectDocumentFormat,
+	// if LprDaemon.DetectFormat is set
+	DetectedFormat DocumentFormat
+
+	// DSC contains the DSC comments of the data file if LprDaemon.ParseDSC is set and it is a PostScript document
+	DSC *DSCComments
+
+	// dsc parses the DSC comments while the data file is received
+	dsc *dscScanner
+
+	// head contains the first bytes of the data file if they are inspected (e.g. by LprDaemon.ParsePJL)
+	head []byte
+
+	// partFileName is the name of the part file the data file is written to if AtomicWrites is set
+	partFileName string
+
+	// sink is the destination of the data file while it is received
+	sink io.WriteCloser
+
 	// IntentingCount Indenting count
 	IntentingCount int64
 
@@ -291,6 +1185,9 @@ type LprConnection struct {
 	// The connection must be closed once the context is canceled.
 	ctx context.Context
 
+	// cancel cancels ctx to abort the connection.
+	cancel context.CancelFunc
+
 	// daemon contains a reference to the LprDaemon
 	daemon *LprDaemon
 
@@ -300,10 +1197,65 @@ type LprConnection struct {
 	// controlFileReceived tells if the control file was already received
 	controlFileReceived bool
 
-	// ExternalID describes a reference of a print job id
+	// lastProgress is the time the progress of the data file was reported the last time
+	lastProgress time.Time
+
+	// receivingJob tells if the connection receives a print job
+	receivingJob bool
+
+	// err is the error which caused the status Error
+	err error
+
+	// ExternalID describes a reference of a print job id
 	ExternalID uint64
 
-	typeChan       chan ConnectionType
+	// ControlFile contains all lines of the received control file
+	ControlFile *ControlFile
+
+	// ControlFileName is the name of the control file sent by the client (e.g. cfA123hostname)
+	ControlFileName string
+
+	// DataFileName is the name of the data file sent by the client (e.g. dfA123hostname)
+	DataFileName string
+
+	// JobNumber is the three digit job number taken from the control or data file name
+	JobNumber string
+
+	// OriginHost is the host name taken from the control or data file name
+	OriginHost string
+
+	// ControlFileSaveName is the file name of the saved control file (see LprDaemon.SaveControlFile)
+	ControlFileSaveName string
+
+	// rawControlFile contains the received control file (without the trailing 0x00 byte)
+	rawControlFile []byte
+
+	// Agent is the user name which requested to remove jobs
+	Agent string
+
+	// JobList contains the user names or job numbers of a remove jobs request
+	JobList []string
+
+	// RemovedJobs contains the job numbers of the jobs removed from a running Spooler
+	// by a remove jobs request (see Spooler.Cancel)
+	RemovedJobs []string
+
+	// connectionType is the type of the connection determined by the daemon command
+	connectionType ConnectionType
+
+	// protocol is the protocol of the listener which accepted the connection
+	protocol listenerProtocol
+
+	// startTime is the time the connection (or the current job) was started
+	startTime time.Time
+
+	// externalIDTurn is closed once the previous job may generate its external ID (see PreserveExternalIDOrder).
+	externalIDTurn chan struct{}
+
+	// externalIDDone is closed once the external ID generation of the current job was started.
+	externalIDDone chan struct{}
+
+	// externalIDChan receives the external ID of the current job, if it receives a print job.
 	externalIDChan chan uint64
 }
 
@@ -315,55 +1267,191 @@ func (lpr *LprConnection) Init(socket net.Conn, bufferSize int64, daemon *LprDae
 		bufferSize = 8192
 	}
 
-	lpr.buffer = make([]byte, bufferSize)
+	if int64(len(lpr.buffer)) != bufferSize || lpr.reader == nil {
+		lpr.buffer = make([]byte, bufferSize)
+		lpr.reader = bufio.NewReaderSize(socket, int(bufferSize))
+	} else {
+		lpr.reader.Reset(socket)
+	}
+	if tcpConn, ok := socket.(*net.TCPConn); ok && daemon.ReceiveBufferSize > 0 {
+		err := tcpConn.SetReadBuffer(int(bufferSize))
+		if err != nil {
+			logErrorf("Error setting receive buffer size %d: %v", bufferSize, err)
+		}
+	}
+	configureTCP(socket, daemon.KeepAlive, daemon.DisableNoDelay)
 	lpr.Connection = socket
 	lpr.BufferSize = bufferSize
 	lpr.daemon = daemon
-	lpr.typeChan = make(chan ConnectionType, 1)
-	lpr.externalIDChan = make(chan uint64, 1)
+	ctx := daemon.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	lpr.ctx, lpr.cancel = context.WithCancel(ctx)
+	lpr.connectionType = ConnectionTypeUnknown
+	lpr.startTime = time.Now()
+	lpr.queueExternalID()
+}
+
+// Release returns the finished connection to the daemon for reuse if LprDaemon.ReuseConnections is set.
+// Otherwise, it does nothing. The connection must not be used after calling Release.
+func (lpr *LprConnection) Release() {
+	daemon := lpr.daemon
+	if daemon == nil || !daemon.ReuseConnections {
+		return
+	}
+
+	buffer, reader := lpr.buffer, lpr.reader
+	if reader != nil {
+		reader.Reset(nil)
+	}
+	*lpr = LprConnection{buffer: buffer, reader: reader}
 
-	daemon.connections <- lpr
+	daemon.connectionPool.Put(lpr)
 }
 
-// ReadCommand reads from the socket until the newline character occurs, but only a maximum number of len(buffer) bytes.
-// The command returned does not include the LF character.
+// setConnectionType stores the type of the connection and starts generating the external ID
+// if the connection receives a print job.
+func (lpr *LprConnection) setConnectionType(connectionType ConnectionType) {
+	lpr.connectionType = connectionType
+	switch connectionType {
+	case ConnectionTypeReceivePrintJob, ConnectionTypeRaw, ConnectionTypeIppPrintJob:
+		lpr.startExternalID()
+	}
+}
+
+// ReadCommand reads from the socket until the newline character occurs, but only a maximum number of
+// MaxCommandSize bytes. The command returned does not include the LF character.
+// Bytes following the LF are kept for the next read.
 func (lpr *LprConnection) ReadCommand() ([]byte, error) {
-	offset := 0
+	maxSize := lpr.daemon.maxCommandSize()
+
+	// deadline is the time the command has to be received completely, once its first byte was received
+	var deadline time.Time
 
+	command := []byte{}
 	for {
-		logDebugf("Reading next block from socket, offset: %d", offset)
-		bytesRead, err := lpr.Connection.Read(lpr.buffer[offset:])
-		if err != nil {
-			return nil, fmt.Errorf("error reading from LPR connection: %w", err)
+		timeout := lpr.daemon.idleTimeout
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return nil, fmt.Errorf("command not received within %v", lpr.daemon.MaxCommandDuration)
+			}
+			if timeout == 0 || remaining < timeout {
+				timeout = remaining
+			}
 		}
 
-		logDebugf("Read %d bytes from socket", bytesRead)
-
-		endOfData := offset + bytesRead
+		if lpr.reader.Buffered() == 0 {
+			logDebug("Reading next block from socket")
+			err := lpr.setReadTimeout(timeout)
+			if err != nil {
+				return nil, err
+			}
 
-		for i, b := range lpr.buffer[:endOfData] {
-			if b == '\n' {
-				if i != (offset+bytesRead)-1 {
-					logErrorf("Garbage at data from socket after byte %d (offset %d, bytes read: %d): %s / %+v", i, offset, bytesRead, lpr.buffer, lpr.buffer)
-					logErrorf("Connection: %+v", lpr)
+			_, err = lpr.reader.Peek(1)
+			if err != nil {
+				if !deadline.IsZero() && errors.Is(err, os.ErrDeadlineExceeded) && !time.Now().Before(deadline) {
+					return nil, fmt.Errorf("command not received within %v: %w", lpr.daemon.MaxCommandDuration, err)
 				}
-
-				return lpr.buffer[:i], nil
+				return nil, fmt.Errorf("error reading from LPR connection: %w", err)
 			}
 		}
 
-		offset += bytesRead
+		if len(command) == 0 && deadline.IsZero() && lpr.daemon.MaxCommandDuration > 0 {
+			deadline = time.Now().Add(lpr.daemon.MaxCommandDuration)
+		}
+
+		buffered, _ := lpr.reader.Peek(lpr.reader.Buffered())
+		logDebugf("Read %d bytes from socket", len(buffered))
+
+		end := bytes.IndexByte(buffered, '\n')
+		if end < 0 {
+			end = len(buffered)
+		}
+
+		if len(command)+end > maxSize {
+			return nil, fmt.Errorf("command exceeds the maximum size of %d bytes", maxSize)
+		}
+
+		command = append(command, buffered[:end]...)
+
+		if end < len(buffered) {
+			// skip the LF
+			lpr.reader.Discard(end + 1)
+			return command, nil
+		}
+
+		lpr.reader.Discard(end)
+	}
+}
+
+// read reads from the network connection.
+// If timeout is not 0, the read fails if no data is received within timeout.
+func (lpr *LprConnection) read(buffer []byte, timeout time.Duration) (int, error) {
+	err := lpr.setReadTimeout(timeout)
+	if err != nil {
+		return 0, err
+	}
+
+	return lpr.reader.Read(buffer)
+}
+
+// setReadTimeout sets the read deadline of the network connection to now plus timeout.
+// A timeout of 0 removes a deadline set before.
+func (lpr *LprConnection) setReadTimeout(timeout time.Duration) error {
+	if timeout <= 0 && !lpr.readDeadlineSet {
+		return nil
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	err := lpr.Connection.SetReadDeadline(deadline)
+	if err != nil {
+		return fmt.Errorf("error setting read deadline: %w", err)
 	}
+	lpr.readDeadlineSet = timeout > 0
+
+	return nil
+}
+
+// connectionReader reads from the network connection of an LprConnection using the read timeout of the daemon.
+type connectionReader struct {
+	conn *LprConnection
+}
+
+func (r connectionReader) Read(buffer []byte) (int, error) {
+	return r.conn.read(buffer, r.conn.daemon.readTimeout)
 }
 
 // RunConnection This method read the data from the client
 func (lpr *LprConnection) RunConnection() {
 	defer func() {
-		close(lpr.typeChan)
-		lpr.ExternalID = <-lpr.externalIDChan
-		lpr.daemon.finishedConns <- lpr
+		lpr.ExternalID = lpr.externalID()
+
+		if lpr.receivingJob {
+			if lpr.Status == End {
+				lpr.jobFinished(lpr)
+			} else {
+				lpr.emit(JobFailed, lpr.err)
+			}
+		}
+
+		lpr.auditJob(lpr)
+
+		// the connection may be released and reused once it is delivered
+		lpr.daemon.removeRunningConnection(lpr)
+		lpr.daemon.deliver(lpr)
 	}()
 
+	defer lpr.cancel()
+
+	stopAbort := lpr.abortOnDone()
+	defer stopAbort()
+
 	var err error
 	lpr.Status = DaemonCommand
 
@@ -379,6 +1467,15 @@ func (lpr *LprConnection) RunConnection() {
 		traceFile.WriteString(fmt.Sprintf("LPR connection trace %s\n", time.Now()))
 	}
 
+	switch lpr.protocol {
+	case protocolRaw:
+		lpr.end(lpr.receiveRawJob())
+		return
+	case protocolIpp:
+		lpr.end(lpr.serveIpp())
+		return
+	}
+
 	for lpr.Status != Error && lpr.Status != End {
 		command, err := lpr.ReadCommand()
 
@@ -438,12 +1535,36 @@ func (lpr *LprConnection) RunConnection() {
 	}
 }
 
+// abortOnDone closes the network connection once the connection's context is canceled,
+// so that any blocking read or write fails. The returned function stops watching the context.
+func (lpr *LprConnection) abortOnDone() func() {
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-lpr.ctx.Done():
+			logDebugf("Aborting connection: %v", lpr.ctx.Err())
+			lpr.Connection.Close()
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
 // end should be called when processing a request is done to set the connection status to "End" and
 // close the output file and network connection.
 func (lpr *LprConnection) end(err error) {
+	if err != nil && lpr.ctx.Err() != nil {
+		err = fmt.Errorf("connection aborted (%v): %w", lpr.ctx.Err(), err)
+	}
+
 	if err != nil {
 		logErrorf("Error processing: %s", err.Error())
 		lpr.Status = Error
+		lpr.err = err
 	} else {
 		logDebug("Request processed")
 		lpr.Status = End
@@ -454,16 +1575,15 @@ func (lpr *LprConnection) end(err error) {
 
 // close closes the output file (if any is open) and the network connection.
 func (lpr *LprConnection) close() {
-	if lpr.Output != nil {
-		err := lpr.Output.Close()
+	if lpr.sink != nil {
+		err := lpr.closeOutput()
 		if err != nil {
-			logErrorf("Error closing output file %s: %s", lpr.Output.Name(), err)
+			logError(err.Error())
 		}
-		lpr.Output = nil
 	}
 
 	err := lpr.Connection.Close()
-	if err != nil {
+	if err != nil && !errors.Is(err, net.ErrClosed) {
 		logErrorf("Error closing connection: %s", err.Error())
 	}
 }
@@ -475,44 +1595,59 @@ func (lpr *LprConnection) parseDaemonCommand(command []byte) error {
 	switch firstSymbol {
 	/* Daemon commands */
 	/* 01 - Print any waiting jobs */
+	/* | 01 | Queue | LF | */
 	case 0x1:
-		lpr.typeChan <- ConnectionTypePrintAnyWaitingJobs
+		lpr.setConnectionType(ConnectionTypePrintAnyWaitingJobs)
+		return lpr.printWaitingJobs(command)
 
 	/* 02 - Receive a printer job */
 	case 0x2:
-		lpr.typeChan <- ConnectionTypeReceivePrintJob
+		lpr.setConnectionType(ConnectionTypeReceivePrintJob)
 		var err error
 		lpr.PrqName, _, err = lpr.daemon.ensureUTF8(command[1:])
 		if err != nil {
 			logErrorf("Invalid printer queue name %q: %v", lpr.PrqName, err)
 		}
+
+		err = lpr.checkJob()
+		if err != nil {
+			return err
+		}
+
 		lpr.Status = JobSubCommand
 
-		return lpr.sendAck()
+		err = lpr.sendAck()
+		if err != nil {
+			return err
+		}
+
+		lpr.receivingJob = true
+		lpr.emit(JobAccepted, nil)
+
+		return nil
 
 	/* 03 - Send queue state (short) */
 	/* | 03 | Queue | SP | List | LF | */
 	case 0x3:
-		lpr.typeChan <- ConnectionTypeSendQueueStateShort
+		lpr.setConnectionType(ConnectionTypeSendQueueStateShort)
 		return lpr.sendQueueState(command, false)
 
 	/* 04 - Send queue state (long) */
 	/* | 04 | Queue | SP | List | LF | */
 	case 0x4:
-		lpr.typeChan <- ConnectionTypeSendQueueStateLong
+		lpr.setConnectionType(ConnectionTypeSendQueueStateLong)
 		return lpr.sendQueueState(command, true)
 
 	/* 05 - Remove jobs */
+	/* | 05 | Queue | SP | Agent | SP | List | LF | */
 	case 0x5:
-		lpr.typeChan <- ConnectionTypeRemoveJobs
+		lpr.setConnectionType(ConnectionTypeRemoveJobs)
+		return lpr.removeJobs(command)
 
 	default:
-		lpr.typeChan <- ConnectionTypeUnknown
+		lpr.setConnectionType(ConnectionTypeUnknown)
 		return fmt.Errorf("unknown daemon command %02x (%c): %s", command[0], command[0], string(command))
-
 	}
-
-	return nil
 }
 
 func (lpr *LprConnection) sendQueueState(command []byte, long bool) error {
@@ -526,6 +1661,130 @@ func (lpr *LprConnection) sendQueueState(command []byte, long bool) error {
 	return lpr.replyQueueState(queue, list, long)
 }
 
+// checkJob checks if a print job for the PrqName may be received
+// and sends a negative acknowledgement if not.
+func (lpr *LprConnection) checkJob() error {
+	err := lpr.checkQueue()
+	if err != nil {
+		lpr.sendNack(NackRejected)
+		return err
+	}
+
+	if lpr.daemon.OnReceiveJob != nil {
+		err = lpr.daemon.OnReceiveJob(lpr.Connection.RemoteAddr(), lpr.PrqName)
+		if err != nil {
+			lpr.sendNack(nackCodeOf(err, NackFailure))
+			return fmt.Errorf("print job for queue %s from %s rejected: %w", lpr.PrqName, lpr.Connection.RemoteAddr(), err)
+		}
+	}
+
+	return nil
+}
+
+// checkQueue returns an error, if the Queues of the daemon are set and do not contain PrqName.
+func (lpr *LprConnection) checkQueue() error {
+	if len(lpr.daemon.Queues) == 0 || containsString(lpr.daemon.Queues, lpr.PrqName) {
+		return nil
+	}
+
+	return fmt.Errorf("unknown queue %s", lpr.PrqName)
+}
+
+func (lpr *LprConnection) printWaitingJobs(command []byte) error {
+	var err error
+	lpr.PrqName, _, err = lpr.daemon.ensureUTF8(command[1:])
+	if err != nil {
+		logErrorf("Invalid printer queue name %q: %v", lpr.PrqName, err)
+	}
+
+	logDebugf("Print any waiting jobs of queue %s requested", lpr.PrqName)
+
+	err = lpr.checkQueue()
+	if err != nil {
+		return err
+	}
+
+	if lpr.daemon.PrintWaitingJobs != nil {
+		err = lpr.daemon.PrintWaitingJobs(lpr.PrqName)
+		if err != nil {
+			return fmt.Errorf("error printing waiting jobs of queue %s: %w", lpr.PrqName, err)
+		}
+	}
+
+	lpr.end(nil)
+
+	return nil
+}
+
+func (lpr *LprConnection) removeJobs(command []byte) error {
+	parts := operands(command[1:], 3)
+	if len(parts) < 2 {
+		lpr.sendNack(NackFailure)
+		return fmt.Errorf("received remove jobs command %q without agent", string(command))
+	}
+
+	var err error
+	lpr.PrqName, _, err = lpr.daemon.ensureUTF8([]byte(parts[0]))
+	if err != nil {
+		logErrorf("Invalid printer queue name %q: %v", lpr.PrqName, err)
+	}
+
+	lpr.Agent, _, err = lpr.daemon.ensureUTF8([]byte(parts[1]))
+	if err != nil {
+		logErrorf("Invalid agent %q: %v", lpr.Agent, err)
+	}
+
+	lpr.JobList = []string{}
+	if len(parts) > 2 {
+		lpr.JobList = strings.Fields(parts[2])
+	}
+
+	logDebugf("Remove jobs %v of queue %s requested by %s", lpr.JobList, lpr.PrqName, lpr.Agent)
+
+	err = lpr.checkQueue()
+	if err != nil {
+		lpr.sendNack(NackRejected)
+		return err
+	}
+
+	spooler := lpr.daemon.runningSpooler()
+	if lpr.daemon.RemoveJobs == nil && spooler == nil {
+		lpr.sendNack(NackRejected)
+		return errors.New("removing jobs is not supported")
+	}
+
+	if lpr.daemon.AuthorizeRemoveJobs != nil {
+		err = lpr.daemon.AuthorizeRemoveJobs(lpr.Connection.RemoteAddr(), lpr.Agent, lpr.PrqName, lpr.JobList)
+		if err != nil {
+			lpr.sendNack(nackCodeOf(err, NackRejected))
+			return fmt.Errorf("%s is not allowed to remove jobs %v of queue %s: %w", lpr.Agent, lpr.JobList, lpr.PrqName, err)
+		}
+	}
+
+	if lpr.daemon.RemoveJobs != nil {
+		err = lpr.daemon.RemoveJobs(lpr.PrqName, lpr.Agent, lpr.JobList)
+	} else {
+		var removed []SpoolJob
+		removed, err = spooler.Cancel(lpr.PrqName, lpr.Agent, lpr.JobList)
+		for _, job := range removed {
+			lpr.RemovedJobs = append(lpr.RemovedJobs, job.queueJob("").JobNumber)
+		}
+	}
+	if err != nil {
+		lpr.sendNack(nackCodeOf(err, NackFailure))
+		return fmt.Errorf("error removing jobs %v of queue %s: %w", lpr.JobList, lpr.PrqName, err)
+	}
+
+	err = lpr.sendAck()
+	if err != nil {
+		return err
+	}
+
+	lpr.end(nil)
+
+	return nil
+}
+
 var asciiSpace = [256]byte{' ': 1, '\t': 1, '\v': 1, '\f': 1}
 
 func operands(data []byte, max int) []string {
@@ -561,15 +1820,36 @@ func (lpr *LprConnection) parseJobSubCommand(command []byte) error {
 	/* 02 - Receive Control File */
 	case 0x2:
 		operands := operands(command[1:], 2)
+		if lpr.controlFileReceived && lpr.dataFileReceived && !isControlFileCommand(operands) {
+			// The job is complete and the client starts the next one
+			// (02 - Receive a printer job) over the same connection.
+			lpr.startNextJob()
+			return lpr.parseDaemonCommand(command)
+		}
+
+		if lpr.controlFileReceived && lpr.dataFileReceived && lpr.compatibility().ImplicitNextJob {
+			err := lpr.startImplicitJob()
+			if err != nil {
+				return err
+			}
+		}
+
 		if len(operands) != 2 {
+			lpr.sendNack(NackFailure)
 			return fmt.Errorf("received job sub command %s, but got %d operands (and expected 2)", string(command), len(operands))
 		}
 
 		controlFileSize, err := strconv.ParseUint(operands[0], 10, 64)
 		if err != nil {
+			lpr.sendNack(NackFailure)
 			return fmt.Errorf("error parsing control file size %q: %w", operands[0], err)
 		}
 
+		if controlFileSize > lpr.daemon.maxControlFileSize() {
+			lpr.sendNack(NackRejected)
+			return fmt.Errorf("control file size %d exceeds the maximum control file size %d", controlFileSize, lpr.daemon.maxControlFileSize())
+		}
+
 		err = lpr.sendAck()
 		if err != nil {
 			return err
@@ -577,6 +1857,7 @@ func (lpr *LprConnection) parseJobSubCommand(command []byte) error {
 
 		err = lpr.receiveControlFile(operands[1], controlFileSize)
 		if err != nil {
+			lpr.sendNack(nackCodeOf(err, NackFailure))
 			return fmt.Errorf("error receiving control file: %w", err)
 		}
 
@@ -587,15 +1868,29 @@ func (lpr *LprConnection) parseJobSubCommand(command []byte) error {
 
 		lpr.controlFileReceived = true
 
+		err = lpr.filesReceived()
+		if err != nil {
+			return err
+		}
+
 	/* 03 - Receive Data File */
 	case 0x3:
+		if lpr.controlFileReceived && lpr.dataFileReceived && lpr.compatibility().ImplicitNextJob {
+			err := lpr.startImplicitJob()
+			if err != nil {
+				return err
+			}
+		}
+
 		operands := operands(command[1:], 2)
 		if len(operands) != 2 {
+			lpr.sendNack(NackFailure)
 			return fmt.Errorf("received job sub command %s, but got %d operands (and expected 2)", string(command), len(operands))
 		}
 
 		dataFileSize, err := strconv.ParseInt(operands[0], 10, 64)
 		if err != nil {
+			lpr.sendNack(NackFailure)
 			return fmt.Errorf("error parsing data file size %q: %w", operands[0], err)
 		}
 		dataFileSizeU := uint64(dataFileSize)
@@ -604,6 +1899,23 @@ func (lpr *LprConnection) parseJobSubCommand(command []byte) error {
 			dataFileSizeU = 0
 		}
 
+		checkedSize := dataFileSizeU
+		if lpr.daemon.WindowsQuirks && dataFileSizeU > WindowsDummyFileSize {
+			logDebugf("Received dummy data file size %d, receiving the data file until the connection is closed", dataFileSizeU)
+			checkedSize = 0
+		}
+
+		if lpr.daemon.MaxJobSize > 0 && checkedSize > lpr.daemon.MaxJobSize {
+			lpr.sendNack(NackRejected)
+			return fmt.Errorf("data file size %d exceeds the maximum job size %d", dataFileSizeU, lpr.daemon.MaxJobSize)
+		}
+
+		err = lpr.checkDiskSpace(checkedSize)
+		if err != nil {
+			lpr.sendNack(NackRetry)
+			return err
+		}
+
 		err = lpr.sendAck()
 		if err != nil {
 			return err
@@ -611,6 +1923,7 @@ func (lpr *LprConnection) parseJobSubCommand(command []byte) error {
 
 		err = lpr.receiveDataFile(operands[1], dataFileSizeU)
 		if err != nil {
+			lpr.sendNack(nackCodeOf(err, NackFailure))
 			return fmt.Errorf("error receiving data file: %w", err)
 		}
 
@@ -621,13 +1934,145 @@ func (lpr *LprConnection) parseJobSubCommand(command []byte) error {
 
 		lpr.dataFileReceived = true
 
+		err = lpr.filesReceived()
+		if err != nil {
+			return err
+		}
+
 	default:
+		lpr.sendNack(NackFailure)
 		return fmt.Errorf("unknown Job Sub command %02x (%c) :: %s", command[0], command[0], string(command))
 	}
 
 	return nil
 }
 
+// isControlFileCommand tells if the given operands of a 02 command are the
+// operands of a "Receive control file" sub command (| 02 | Count | SP | Name | LF |).
+func isControlFileCommand(operands []string) bool {
+	if len(operands) != 2 {
+		return false
+	}
+
+	_, err := strconv.ParseUint(operands[0], 10, 64)
+	return err == nil
+}
+
+// startNextJob delivers the job received so far as finished connection
+// and resets the job related state, so that another job can be received over the connection.
+func (lpr *LprConnection) startNextJob() {
+	logDebug("Job complete, receiving the next job over the same connection")
+
+	lpr.ExternalID = lpr.externalID()
+
+	job := *lpr
+	job.Status = End
+	// the buffers are still used by the connection, so they must not be reused if the job is released
+	job.buffer = nil
+	job.reader = nil
+	job.hash = nil
+	lpr.jobFinished(&job)
+	lpr.auditJob(&job)
+	lpr.daemon.deliver(&job)
+
+	lpr.resetJob()
+
+	lpr.queueExternalID()
+}
+
+// resetJob resets all fields describing a received job. The slices and pointers are replaced
+// instead of being reused, as they are owned by the delivered copy of the previous job.
+func (lpr *LprConnection) resetJob() {
+	lpr.processedDataBytes = 0
+	lpr.Hostname = ""
+	lpr.Filename = ""
+	lpr.PrqName = ""
+	lpr.UserIdentification = ""
+	lpr.JobName = ""
+	lpr.TitleText = ""
+	lpr.MailUser = ""
+	lpr.PrintBanner = false
+	lpr.Unlink = false
+	lpr.unlinkSink = nil
+	lpr.sink = nil
+	lpr.Output = nil
+	lpr.partFileName = ""
+	lpr.BannerUser = ""
+	lpr.ClassName = ""
+	lpr.Filesize = 0
+	lpr.ReceivedSize = 0
+	lpr.SizeVerified = false
+	lpr.IntentingCount = 0
+	lpr.PrintFileWithPr = ""
+	lpr.SaveName = ""
+	lpr.Data = nil
+	lpr.Checksum = nil
+	lpr.hash = nil
+	lpr.PJL = nil
+	lpr.DetectedFormat = ""
+	lpr.DSC = nil
+	lpr.dsc = nil
+	lpr.head = nil
+	lpr.CompressedSize = 0
+	lpr.UncompressedSize = 0
+	lpr.dataFileReceived = false
+	lpr.controlFileReceived = false
+	lpr.ExternalID = 0
+	lpr.ControlFile = nil
+	lpr.ControlFileName = ""
+	lpr.DataFileName = ""
+	lpr.JobNumber = ""
+	lpr.OriginHost = ""
+	lpr.ControlFileSaveName = ""
+	lpr.rawControlFile = nil
+	lpr.Agent = ""
+	lpr.JobList = nil
+	lpr.RemovedJobs = nil
+	lpr.lastProgress = time.Time{}
+	lpr.receivingJob = false
+	lpr.err = nil
+	lpr.connectionType = ConnectionTypeUnknown
+	lpr.startTime = time.Now()
+}
+
+// setJobIdentity sets the JobNumber and OriginHost from the given control or data file name.
+// The name of the control file takes precedence over the name of the data file.
+func (lpr *LprConnection) setJobIdentity(fileName string, controlFile bool) {
+	if lpr.JobNumber != "" && !controlFile {
+		return
+	}
+
+	jobNumber, host, ok := parseSpoolFileName(fileName)
+	if !ok {
+		logDebugf("File name %q does not contain a job number", fileName)
+		return
+	}
+
+	lpr.JobNumber = jobNumber
+	lpr.OriginHost = host
+}
+
+// parseSpoolFileName extracts the job number and the host name from a control or data file
+// name like "cfA123hostname" or "dfA123hostname" (see RFC-1179, chapter 6.2 and 6.3).
+func parseSpoolFileName(fileName string) (jobNumber string, host string, ok bool) {
+	if len(fileName) < 6 || (fileName[:2] != "cf" && fileName[:2] != "df") {
+		return "", "", false
+	}
+
+	letter := fileName[2]
+	if (letter < 'A' || letter > 'Z') && (letter < 'a' || letter > 'z') {
+		return "", "", false
+	}
+
+	for _, digit := range fileName[3:6] {
+		if digit < '0' || digit > '9' {
+			return "", "", false
+		}
+	}
+
+	return fileName[3:6], fileName[6:], true
+}
+
 func (lpr *LprConnection) receiveControlFile(fileName string, bytes uint64) error {
 	logDebugf("Receiving control file %q with %d bytes", fileName, bytes)
 
@@ -635,233 +2080,437 @@ func (lpr *LprConnection) receiveControlFile(fileName string, bytes uint64) erro
 		logErrorf("Receiving an additional control file over the connection %+v: %s (%d bytes)", lpr, fileName, bytes)
 	}
 
+	lpr.ControlFileName = fileName
+	lpr.setJobIdentity(fileName, true)
+
 	// +1, because the sender will add a 0x00 byte to the control file
 	buffer := make([]byte, bytes+1)
 
-	_, err := io.ReadFull(lpr.Connection, buffer)
+	_, err := io.ReadFull(connectionReader{lpr}, buffer)
 	if err != nil {
 		return fmt.Errorf("error reading control file %s with %d bytes: %w", fileName, bytes, err)
 	}
 
-	line := []byte{}
-
 	lastByte := buffer[len(buffer)-1]
 	if lastByte != 0 {
 		return fmt.Errorf("control file does not end with 0x00 but %02x: %s", lastByte, string(buffer))
 	}
 
-	for _, b := range buffer[:len(buffer)-1] {
-		if b == '\n' {
-			// end of control file line
-			err = lpr.parseControlFileLine(line)
-			if err != nil {
-				return fmt.Errorf("error parsing control file line %q: %w", string(line), err)
-			}
+	controlFile, err := parseControlFile(lpr.controlFileLines(buffer[:len(buffer)-1]), lpr.decode, lpr.compatibility().LenientControlFile)
+	if err != nil {
+		return err
+	}
 
-			line = make([]byte, 0)
-		} else {
-			line = append(line, b)
+	lpr.setControlFile(controlFile)
+	lpr.rawControlFile = buffer[:len(buffer)-1]
+	lpr.emit(ControlFileReceived, nil)
+
+	return nil
+}
+
+// filesReceived is called after the control or data file was received and
+// completes the job once both files are available.
+func (lpr *LprConnection) filesReceived() error {
+	if !lpr.controlFileReceived || !lpr.dataFileReceived {
+		return nil
+	}
+
+	lpr.Unlink = lpr.ControlFile != nil && containsString(lpr.ControlFile.UnlinkFiles, lpr.DataFileName)
+
+	err := lpr.saveControlFile()
+	if err != nil {
+		return err
+	}
+
+	return lpr.addBanner()
+}
+
+// containsString tells if values contains value.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
 		}
 	}
 
-	if len(line) > 0 {
-		return fmt.Errorf("garbage at end of control file: %s", string(line))
+	return false
+}
+
+// saveControlFile writes the received control file next to the data file,
+// if SaveControlFile is set and both files were received.
+func (lpr *LprConnection) saveControlFile() error {
+	if !lpr.daemon.SaveControlFile || lpr.rawControlFile == nil || lpr.SaveName == "" {
+		return nil
+	}
+
+	fileName := lpr.SaveName + ".cf"
+	err := os.WriteFile(fileName, lpr.rawControlFile, lpr.daemon.fileMask)
+	if err != nil {
+		return fmt.Errorf("error saving control file %s: %w", fileName, err)
 	}
 
+	lpr.ControlFileSaveName = fileName
+	logDebugf("Saved control file: %s", fileName)
+
 	return nil
 }
 
-func (lpr *LprConnection) parseControlFileLine(line []byte) error {
-	if len(line) == 0 {
-		// empty line
-		return nil
+// setControlFile sets the ControlFile and the fields taken from it.
+func (lpr *LprConnection) setControlFile(controlFile *ControlFile) {
+	lpr.ControlFile = controlFile
+	lpr.ClassName = controlFile.Class
+	lpr.Hostname = controlFile.Host
+	lpr.IntentingCount = controlFile.Indent
+	lpr.JobName = controlFile.JobName
+	lpr.Filename = controlFile.SourceFileName
+	lpr.UserIdentification = controlFile.User
+	lpr.TitleText = controlFile.Title
+	lpr.MailUser = controlFile.MailUser
+	lpr.PrintBanner = controlFile.PrintBanner
+	lpr.BannerUser = controlFile.BannerUser
+	lpr.PrintFileWithPr = controlFile.printFile('p')
+}
+
+func (lpr *LprConnection) receiveDataFile(fileName string, bytes uint64) (err error) {
+	logDebugf("Receiving data file %q with %d bytes", fileName, bytes)
+
+	if lpr.dataFileReceived {
+		logErrorf("Receiving an additional data file over the connection %+v: %s (%d bytes)", lpr, fileName, bytes)
 	}
 
-	var err error
-	switch line[0] {
-	/* C - Class for banner page */
-	case 'C':
-		lpr.ClassName, _, err = lpr.daemon.ensureUTF8(line[1:])
-		if err != nil {
-			return fmt.Errorf("invalid class name %q: %v", lpr.ClassName, err)
-		}
-		logDebugf("Class name: %s", lpr.ClassName)
+	lpr.DataFileName = fileName
+	lpr.setJobIdentity(fileName, false)
 
-	/* H - Host name */
-	case 'H':
-		lpr.Hostname, _, err = lpr.daemon.ensureUTF8(line[1:])
-		if err != nil {
-			return fmt.Errorf("invalid hostname %q: %v", lpr.Hostname, err)
+	lpr.Filesize = bytes
+
+	lpr.processedDataBytes = 0
+	lpr.startInspection()
+
+	lpr.hash = nil
+	if lpr.daemon.ChecksumHash != nil {
+		lpr.hash = lpr.daemon.ChecksumHash()
+	}
+
+	err = lpr.openOutput()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		cErr := lpr.closeOutput()
+		if err == nil {
+			err = cErr
 		}
-		logDebugf("Hostname: %s", lpr.Hostname)
 
-	/* I - Indent Printing */
-	case 'I':
-		lpr.IntentingCount, err = strconv.ParseInt(string(line[1:]), 10, 64)
+		err = lpr.commitPartFile(err)
+	}()
+
+	lpr.lastProgress = time.Time{}
+	lpr.emit(DataFileStarted, nil)
+
+	if lpr.canCopyDataFile() {
+		logDebugf("Copying data file with %d bytes directly into the output file", lpr.Filesize)
+		err = lpr.copyDataFile()
 		if err != nil {
 			return err
 		}
-		logDebugf("indenting_count: %d", lpr.IntentingCount)
+	}
 
-	/* J - Job name for banner page */
-	case 'J':
-		lpr.JobName, _, err = lpr.daemon.ensureUTF8(line[1:])
+	for {
+		bytes, err := lpr.read(lpr.buffer, lpr.daemon.readTimeout)
 		if err != nil {
-			return fmt.Errorf("invalid job name %q: %v", lpr.JobName, err)
-		}
-		logDebugf("Job name: %s", lpr.JobName)
-
-	/* L - Print banner page */
-	case 'L':
-		break
-
-	/* M - Mail When Printed */
-	case 'M':
-		break
+			if errors.Is(err, io.EOF) && lpr.acceptsIncompleteDataFile() {
+				logDebugf("Received error %s, but the file seemed to be transferred (specified %d bytes, got %d bytes)", err.Error(), lpr.Filesize, lpr.processedDataBytes)
+				break
+			}
 
-	/* N - Name of source file */
-	case 'N':
-		lpr.Filename, _, err = lpr.daemon.ensureUTF8(line[1:])
-		if err != nil {
-			return fmt.Errorf("invalid filename %q: %v", lpr.Filename, err)
+			return fmt.Errorf("error reading data: %w", err)
 		}
-		logDebugf("Filename: %s", lpr.Filename)
 
-	/* P - User identification */
-	case 'P':
-		lpr.UserIdentification, _, err = lpr.daemon.ensureUTF8(line[1:])
+		var endReached bool
+		endReached, err = lpr.addToFile(lpr.buffer[:bytes])
 		if err != nil {
-			return fmt.Errorf("invalid user identification %q: %v", lpr.UserIdentification, err)
+			return fmt.Errorf("error writing %d bytes to output file: %w", bytes, err)
 		}
-		logDebugf("User identification: %s", lpr.UserIdentification)
 
-	/* S - Symbolic link data */
-	case 'S':
+		if endReached {
+			break
+		}
+	}
 
-	/* T - Title for pr */
-	case 'T':
-		lpr.TitleText, _, err = lpr.daemon.ensureUTF8(line[1:])
-		if err != nil {
-			return fmt.Errorf("invalid title text %q: %v", lpr.TitleText, err)
+	lpr.ReceivedSize = lpr.processedDataBytes
+	lpr.SizeVerified = lpr.Filesize > 0 && lpr.ReceivedSize == lpr.Filesize
+	if lpr.Filesize > 0 && !lpr.SizeVerified && !lpr.dummyFileSize() {
+		logErrorf("Received %d bytes of data file %q, but %d bytes were announced", lpr.ReceivedSize, fileName, lpr.Filesize)
+		if lpr.daemon.VerifySize {
+			return fmt.Errorf("received %d bytes, but %d bytes were announced", lpr.ReceivedSize, lpr.Filesize)
 		}
-		logDebugf("Title text: %s", lpr.TitleText)
+	}
 
-	/* U - Unlink data file */
-	case 'U':
+	if lpr.hash != nil {
+		lpr.Checksum = lpr.hash.Sum(nil)
+		logDebugf("Checksum of data file: %x", lpr.Checksum)
+	}
 
-	/* W - Width of output */
-	case 'W':
+	lpr.inspectDataFile()
 
-	/* 1 - troff R font */
-	case '1':
+	lpr.Status = JobSubCommand
 
-	/* 2 - troff I font */
-	case '2':
+	return nil
+}
 
-	/* 3 - troff B font */
-	case '3':
+// checkDiskSpace checks if the filesystem of the InputFileSaveDir has enough space available
+// for a data file with the given size (plus DiskSpaceMargin), if CheckDiskSpace is set.
+func (lpr *LprConnection) checkDiskSpace(size uint64) error {
+	if !lpr.daemon.CheckDiskSpace || lpr.daemon.DataSinkFactory != nil ||
+		(size > 0 && size <= lpr.daemon.InMemoryThreshold) {
+		return nil
+	}
 
-	/* 4 - troff S font */
-	case '4':
+	dir := lpr.daemon.InputFileSaveDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
 
-	/* c - Plot CIF file */
-	case 'c':
+	available, supported, err := freeDiskSpace(dir)
+	if !supported {
+		logDebug("Checking the available disk space is not supported on this platform")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error checking available disk space of %s: %w", dir, err)
+	}
 
-	/* d - Print DVI file */
-	case 'd':
+	required := size + lpr.daemon.DiskSpaceMargin
+	if required < size {
+		// overflow
+		required = math.MaxUint64
+	}
+	if available < required {
+		return fmt.Errorf("not enough disk space available at %s: %d bytes required, %d bytes available", dir, required, available)
+	}
 
-	/* f - Print formatted file */
-	case 'f':
+	return nil
+}
 
-	/* g - Plot file */
-	case 'g':
+// openOutput opens the destination of the data file, which is either the sink created
+// by the DataSinkFactory of the daemon or a new file in the InputFileSaveDir.
+func (lpr *LprConnection) openOutput() error {
+	if lpr.daemon.DataSinkFactory != nil {
+		sink, err := lpr.daemon.DataSinkFactory(lpr)
+		if err != nil {
+			return fmt.Errorf("error creating data sink: %w", err)
+		}
 
-	/* l - Print file leaving control characters */
-	case 'l':
+		lpr.sink = sink
+		lpr.unlinkSink, _ = sink.(UnlinkSink)
+		if file, ok := sink.(*os.File); ok {
+			lpr.Output = file
+			lpr.SaveName = file.Name()
+		}
 
-	/* n - Print ditroff output file */
-	case 'n':
+		return nil
+	}
 
-	/* o - Print Postscript output file */
-	case 'o':
+	if lpr.Filesize > 0 && lpr.Filesize <= lpr.daemon.InMemoryThreshold {
+		logDebugf("Receiving data file with %d bytes into memory", lpr.Filesize)
+		lpr.sink = &memorySink{conn: lpr}
+		return nil
+	}
 
-	/* p - Print file with 'pr' format */
-	case 'p':
-		lpr.PrintFileWithPr = string(line[1:])
-		logDebugf("p: %s", lpr.PrintFileWithPr)
+	return lpr.openOutputFile()
+}
 
-	/* r - File to print with FORTRAN carriage control */
-	case 'r':
+// openOutputFile creates a new file in the InputFileSaveDir as destination of the data file.
+func (lpr *LprConnection) openOutputFile() error {
+	sink, err := lpr.openFileSink()
+	if err != nil {
+		return err
+	}
 
-	/* t - Print troff output file */
-	case 't':
+	lpr.sink = sink
 
-	/* v - Print raster file */
-	case 'v':
+	return nil
+}
 
-	case 0x00:
+// openFileSink creates a new file in the InputFileSaveDir (see createOutputFile) and returns
+// the writer for the data file, which compresses the data if a Compressor is set.
+func (lpr *LprConnection) openFileSink() (io.WriteCloser, error) {
+	file, err := lpr.createOutputFile()
+	if err != nil {
+		return nil, err
+	}
 
-	default:
-		return fmt.Errorf("unknown control file line %02x (%c): %s", line[0], line[0], string(line))
+	if lpr.daemon.Compressor == nil {
+		return file, nil
+	}
 
+	sink, err := lpr.newCompressedSink(file)
+	if err != nil {
+		file.Close()
+		return nil, err
 	}
 
-	return nil
+	return sink, nil
 }
 
-func (lpr *LprConnection) receiveDataFile(fileName string, bytes uint64) error {
-	logDebugf("Receiving data file %q with %d bytes", fileName, bytes)
+// createOutputFile creates a new file in the InputFileSaveDir and sets it as Output.
+// If AtomicWrites is set, the file is a temporary part file, which is renamed
+// to the SaveName by commitPartFile once the data file was received completely.
+func (lpr *LprConnection) createOutputFile() (*os.File, error) {
+	if lpr.daemon.AtomicWrites {
+		file, err := lpr.createSpoolFile(".lpr_part_*")
+		if err != nil {
+			return nil, fmt.Errorf("error while creating part file at %s! %w", lpr.daemon.InputFileSaveDir, err)
+		}
 
-	if lpr.dataFileReceived {
-		logErrorf("Receiving an additional data file over the connection %+v: %s (%d bytes)", lpr, fileName, bytes)
+		lpr.Output = file
+		lpr.partFileName = file.Name()
+		logDebugf("New part file: %s", lpr.partFileName)
+
+		return file, nil
 	}
 
-	var err error
+	file, err := lpr.createTempFile()
+	if err != nil {
+		return nil, fmt.Errorf("error while creating temporary file at %s! %w", lpr.daemon.InputFileSaveDir, err)
+	}
 
-	lpr.Filesize = bytes
+	lpr.Output = file
+	lpr.SaveName = file.Name()
+	logDebugf("New data file: %s", lpr.SaveName)
 
-	lpr.processedDataBytes = 0
+	return file, nil
+}
+
+// commitPartFile renames the part file written with AtomicWrites to its final SaveName.
+// If err is not nil, the data file was not received completely and the part file is removed instead.
+func (lpr *LprConnection) commitPartFile(err error) error {
+	partFileName := lpr.partFileName
+	if partFileName == "" {
+		return err
+	}
+	lpr.partFileName = ""
 
-	lpr.Output, err = lpr.createTempFile()
 	if err != nil {
-		return fmt.Errorf("error while creating temporary file at %s! %w", lpr.daemon.InputFileSaveDir, err)
+		if rErr := os.Remove(partFileName); rErr != nil {
+			logErrorf("Error removing part file %s: %v", partFileName, rErr)
+		}
+		return err
 	}
 
-	defer func() {
-		err := lpr.Output.Close()
+	// reserve a unique file name, which is replaced by the part file
+	file, err := lpr.createTempFile()
+	if err != nil {
+		os.Remove(partFileName)
+		return fmt.Errorf("error while creating file at %s! %w", lpr.daemon.InputFileSaveDir, err)
+	}
+	file.Close()
+
+	err = os.Rename(partFileName, file.Name())
+	if err != nil {
+		os.Remove(partFileName)
+		os.Remove(file.Name())
+		return fmt.Errorf("error renaming part file %s to %s: %w", partFileName, file.Name(), err)
+	}
+
+	if lpr.daemon.SyncFiles {
+		err = syncDir(filepath.Dir(file.Name()))
 		if err != nil {
-			logErrorf("error closing output file %q: %s", lpr.Output.Name(), err.Error())
-			return
+			return err
 		}
+	}
 
-		lpr.Output = nil
-	}()
-
-	lpr.SaveName = lpr.Output.Name()
+	lpr.SaveName = file.Name()
 	logDebugf("New data file: %s", lpr.SaveName)
 
-	for {
-		bytes, err := lpr.Connection.Read(lpr.buffer)
-		if err != nil {
-			if errors.Is(err, io.EOF) && (lpr.Filesize == 0 || lpr.Filesize > 2*1024*1024*1024) {
-				logDebugf("Received error %s, but the file seemed to be transferred (specified %d bytes, got %d bytes)", err.Error(), lpr.Filesize, lpr.processedDataBytes)
-				break
-			}
+	return nil
+}
 
-			return fmt.Errorf("error reading data: %w", err)
+// syncDir flushes the directory entries of dir to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("error opening directory %s: %w", dir, err)
+	}
+	defer d.Close()
+
+	err = d.Sync()
+	if err != nil {
+		return fmt.Errorf("error syncing directory %s: %w", dir, err)
+	}
+
+	return nil
+}
+
+// memorySink keeps a data file in memory. If more than InMemoryThreshold bytes are written,
+// the data is moved into a file in the InputFileSaveDir.
+type memorySink struct {
+	conn   *LprConnection
+	buffer bytes.Buffer
+	file   io.WriteCloser
+}
+
+func (m *memorySink) Write(data []byte) (int, error) {
+	if m.file == nil && uint64(m.buffer.Len()+len(data)) > m.conn.daemon.InMemoryThreshold {
+		logDebugf("Data file exceeds %d bytes, moving it into a file", m.conn.daemon.InMemoryThreshold)
+
+		file, err := m.conn.openFileSink()
+		if err != nil {
+			return 0, err
 		}
 
-		endReached, err := lpr.addToFile(lpr.buffer[:bytes])
+		m.file = file
+
+		_, err = file.Write(m.buffer.Bytes())
 		if err != nil {
-			return fmt.Errorf("error writing %d bytes to output file: %w", bytes, err)
+			return 0, err
 		}
+		m.buffer.Reset()
+	}
 
-		if endReached {
-			break
+	if m.file != nil {
+		return m.file.Write(data)
+	}
+
+	return m.buffer.Write(data)
+}
+
+func (m *memorySink) Sync() error {
+	if syncer, ok := m.file.(interface{ Sync() error }); ok {
+		return syncer.Sync()
+	}
+
+	return nil
+}
+
+func (m *memorySink) Close() error {
+	if m.file != nil {
+		return m.file.Close()
+	}
+
+	m.conn.Data = m.buffer.Bytes()
+	return nil
+}
+
+// closeOutput closes the destination of the data file.
+func (lpr *LprConnection) closeOutput() error {
+	sink := lpr.sink
+	lpr.sink = nil
+	lpr.Output = nil
+
+	if syncer, ok := sink.(interface{ Sync() error }); ok && lpr.daemon.SyncFiles {
+		err := syncer.Sync()
+		if err != nil {
+			sink.Close()
+			return fmt.Errorf("error syncing output %q: %w", lpr.SaveName, err)
 		}
 	}
 
-	lpr.Status = JobSubCommand
+	err := sink.Close()
+	if err != nil {
+		return fmt.Errorf("error closing output %q: %w", lpr.SaveName, err)
+	}
 
 	return nil
 }
@@ -876,6 +2525,15 @@ func (lpr *LprConnection) sendAck() error {
 	return nil
 }
 
+// sendNack sends a negative acknowledgement using the given code.
+// Errors are only logged, because a negative acknowledgement is always followed by closing the connection.
+func (lpr *LprConnection) sendNack(code NackCode) {
+	_, err := lpr.Connection.Write([]byte{byte(code)})
+	if err != nil {
+		logErrorf("Sending NACK %d failed: %s", code, err.Error())
+	}
+}
+
 // addToFile This method add the data to the output file
 func (lpr *LprConnection) addToFile(data []uint8) (bool, error) {
 	if len(data) == 0 {
@@ -893,39 +2551,197 @@ func (lpr *LprConnection) addToFile(data []uint8) (bool, error) {
 		end = true
 	}
 
+	err = lpr.writeToFile(data)
+	if err != nil {
+		return false, err
+	}
+
+	lpr.reportProgress(end)
+
+	return end, nil
+}
+
+// writeToFile writes the given part of the data file to the output and adds it to the checksum.
+func (lpr *LprConnection) writeToFile(data []uint8) error {
 	lpr.processedDataBytes += uint64(len(data))
 
-	_, err = lpr.Output.Write(data)
+	if lpr.daemon.MaxJobSize > 0 && lpr.processedDataBytes > lpr.daemon.MaxJobSize {
+		return &NackError{Code: NackRejected, Err: fmt.Errorf("received more than the maximum job size of %d bytes", lpr.daemon.MaxJobSize)}
+	}
+
+	_, err := lpr.sink.Write(data)
 	if err != nil {
-		return false, fmt.Errorf("write failed: %w", err)
+		return fmt.Errorf("write failed: %w", err)
 	}
 
-	return end, nil
+	if lpr.hash != nil {
+		lpr.hash.Write(data)
+	}
+
+	lpr.inspect(data)
+
+	return nil
 }
 
+// createTempFile creates a new, uniquely named data file in the InputFileSaveDir.
+// The file is named by the FileNameTemplate if set, otherwise "lpr_data_" followed by a random number.
 func (lpr *LprConnection) createTempFile() (*os.File, error) {
-	try := 0
-	for {
-		fileName := filepath.Join(lpr.daemon.InputFileSaveDir, strconv.FormatUint(uint64(rand.Int63()), 16))
+	if lpr.daemon.FileNameTemplate != "" {
+		return lpr.createTemplateFile()
+	}
+
+	return lpr.createSpoolFile("lpr_data_*" + lpr.daemon.fileExtension())
+}
+
+// createSpoolFile creates a new file in the InputFileSaveDir using os.CreateTemp, which guarantees
+// a unique name for the given pattern, and applies the file mask of the daemon.
+func (lpr *LprConnection) createSpoolFile(pattern string) (*os.File, error) {
+	file, err := os.CreateTemp(lpr.daemon.InputFileSaveDir, pattern)
+	if err != nil {
+		return nil, err
+	}
 
+	err = file.Chmod(lpr.daemon.fileMask)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("error changing mode of file %s: %w", file.Name(), err)
+	}
+
+	return file, nil
+}
+
+// createTemplateFile creates a new file in the InputFileSaveDir named by the FileNameTemplate.
+// If the file already exists, a counter is appended to the name.
+func (lpr *LprConnection) createTemplateFile() (*os.File, error) {
+	name := lpr.expandFileNameTemplate(lpr.daemon.FileNameTemplate)
+	if name == "" || name == "." || name == ".." {
+		name = "job" + name
+	}
+	baseName := filepath.Join(lpr.daemon.InputFileSaveDir, name)
+	extension := lpr.daemon.fileExtension()
+
+	fileName := baseName + extension
+	for try := 1; try < 10000; try++ {
 		f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_EXCL, lpr.daemon.fileMask)
-		if os.IsExist(err) {
-			if try++; try < 10000 {
-				continue
-			}
-			return nil, fmt.Errorf("error creating temporary file! Giving up after %d tries", try)
+		if !os.IsExist(err) {
+			return f, err
 		}
-		return f, err
+
+		fileName = fmt.Sprintf("%s_%d%s", baseName, try, extension)
+	}
+
+	return nil, fmt.Errorf("error creating file %s! Giving up after %d tries", baseName, 10000)
+}
+
+// expandFileNameTemplate replaces the placeholders of template with the values of the job.
+// Fields of the control file are only available if the control file was received before the data file.
+func (lpr *LprConnection) expandFileNameTemplate(template string) string {
+	replacer := strings.NewReplacer(
+		"{queue}", SanitizeFileName(lpr.PrqName),
+		"{jobnumber}", SanitizeFileName(lpr.JobNumber),
+		"{host}", SanitizeFileName(lpr.OriginHost),
+		"{user}", SanitizeFileName(lpr.UserIdentification),
+		"{jobname}", SanitizeFileName(lpr.JobName),
+		"{filename}", SanitizeFileName(sourceBaseName(lpr.Filename)),
+		"{timestamp}", time.Now().Format("20060102150405"),
+		"{random}", randomHex(),
+	)
+
+	return replacer.Replace(template)
+}
+
+// sourceBaseName returns the last element of the given source file name,
+// which may use slashes or backslashes as separator.
+func sourceBaseName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[i+1:]
 	}
+
+	return name
+}
+
+// randomHex returns a random hexadecimal number.
+func randomHex() string {
+	random := make([]byte, 8)
+	_, err := rand.Read(random)
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(random)
+}
+
+// SanitizeFileName replaces all characters of value which must not be used in a file name
+// (path separators and control characters) with '_', so that the result can be used as name
+// of a file in a directory without referring to another directory.
+// The names "." and ".." are replaced with "_".
+func SanitizeFileName(value string) string {
+	if value == "." || value == ".." {
+		return "_"
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < 0x20 || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// SanitizedFilename returns the Filename (N - Name of source file) sanitized by SanitizeFileName.
+// Filename itself is kept as received from the client.
+func (lpr *LprConnection) SanitizedFilename() string {
+	return SanitizeFileName(lpr.Filename)
+}
+
+// SanitizedControlFileName returns the ControlFileName sanitized by SanitizeFileName.
+func (lpr *LprConnection) SanitizedControlFileName() string {
+	return SanitizeFileName(lpr.ControlFileName)
+}
+
+// SanitizedDataFileName returns the DataFileName sanitized by SanitizeFileName.
+func (lpr *LprConnection) SanitizedDataFileName() string {
+	return SanitizeFileName(lpr.DataFileName)
 }
 
 func (lpr *LprConnection) replyQueueState(queue string, list string, long bool) error {
+	var err error
+	lpr.PrqName, _, err = lpr.daemon.ensureUTF8([]byte(queue))
+	if err != nil {
+		logErrorf("Invalid printer queue name %q: %v", lpr.PrqName, err)
+	}
+
+	err = lpr.checkQueue()
+	if err != nil {
+		_, wErr := lpr.Connection.Write([]byte(err.Error() + "\n"))
+		if wErr != nil {
+			logErrorf("Sending queue state failed: %s", wErr.Error())
+		}
+		return err
+	}
+
+	request := QueueStateRequest{
+		RemoteAddr: lpr.Connection.RemoteAddr(),
+		Queue:      lpr.PrqName,
+		List:       strings.Fields(list),
+		Long:       long,
+	}
+
 	state := "Idle\n"
-	if lpr.daemon.GetQueueState != nil {
+	if lpr.daemon.GetQueueStateContext != nil {
+		state, err = lpr.daemon.GetQueueStateContext(lpr.ctx, request)
+	} else if lpr.daemon.GetQueueState != nil {
 		state = lpr.daemon.GetQueueState(queue, list, long)
+	} else if spooler := lpr.daemon.runningSpooler(); spooler != nil {
+		state, err = spooler.QueueState(lpr.ctx, request)
+	}
+	if err != nil {
+		return fmt.Errorf("error getting the state of queue %s: %w", lpr.PrqName, err)
 	}
 
-	_, err := lpr.Connection.Write([]byte(state))
+	_, err = lpr.Connection.Write([]byte(state))
 	if err != nil {
 		logErrorf("Sending queue state failed: %s", err.Error())
 	}
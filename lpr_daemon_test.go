@@ -1,14 +1,22 @@
 package lprlib
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"io/ioutil"
 	"log"
+	"math"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -43,6 +51,12 @@ func TestDaemonSingleConnection(t *testing.T) {
 
 	require.Equal(t, "räw", conn.PrqName)
 	require.Equal(t, "TestÜser", conn.UserIdentification)
+	require.Equal(t, "TestÜser", conn.ControlFile.User)
+	hostname, err := os.Hostname()
+	require.Nil(t, err)
+	require.Regexp(t, "^[0-9]{3}$", conn.JobNumber)
+	require.Equal(t, hostname, conn.OriginHost)
+	require.Equal(t, filepath.Base(name), conn.ControlFile.SourceFileName)
 	fi, err := os.Stat(conn.SaveName)
 	require.Nil(t, err)
 	require.Equal(t, fs.FileMode(0600), fi.Mode().Perm())
@@ -414,8 +428,8 @@ func TestDaemonClose(t *testing.T) {
 	require.Equal(t, End, conn.Status)
 
 	// no new connection may be opened
-	lprs = LprSend{}
-	err = lprs.Init("127.0.0.1", name, port, "raw", "TestUser", time.Minute)
+	var lprs2 LprSend
+	err = lprs2.Init("127.0.0.1", name, port, "raw", "TestUser", time.Minute)
 	require.NotNil(t, err)
 }
 
@@ -953,3 +967,1819 @@ func customSendConfiguration(lpr *LprSend, customConfigPrefix string) error {
 
 	return nil
 }
+
+func TestDaemonContextCancel(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var lprd LprDaemon
+	err = lprd.InitContext(ctx, port, "")
+	require.Nil(t, err)
+
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", name, port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	err = lprs.SendConfiguration()
+	require.Nil(t, err)
+
+	cancel()
+
+	// the running connection must be aborted
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+
+	// the daemon must stop delivering connections
+	_, ok := <-lprd.FinishedConnections()
+	require.False(t, ok)
+
+	require.Nil(t, lprs.Close())
+
+	// no new connection may be opened
+	var lprs2 LprSend
+	err = lprs2.Init("127.0.0.1", name, port, "raw", "TestUser", time.Minute)
+	require.NotNil(t, err)
+}
+
+func TestDaemonServeListener(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	text := "Text for the file"
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	var lprd LprDaemon
+	err = lprd.ServeListener(listener)
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+
+	out, err := os.ReadFile(conn.SaveName)
+	require.Nil(t, err)
+	require.Nil(t, os.Remove(conn.SaveName))
+	require.Equal(t, text, string(out))
+}
+
+func TestDaemonUnixSocket(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	socketPath := filepath.Join(t.TempDir(), "lpd.sock")
+
+	var lprd LprDaemon
+	err := lprd.InitUnix(socketPath, 0660)
+	require.Nil(t, err)
+
+	fi, err := os.Stat(socketPath)
+	require.Nil(t, err)
+	require.Equal(t, fs.FileMode(0660), fi.Mode().Perm())
+
+	socket, err := net.Dial("unix", socketPath)
+	require.Nil(t, err)
+
+	_, err = socket.Write([]byte("\x03raw\n"))
+	require.Nil(t, err)
+
+	status, err := io.ReadAll(socket)
+	require.Nil(t, err)
+	require.Equal(t, "Idle\n", string(status))
+	require.Nil(t, socket.Close())
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+
+	lprd.Close()
+
+	// the socket file is removed by Close
+	_, err = os.Stat(socketPath)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestDaemonMaxConcurrentConnections(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.MaxConcurrentConnections = 1
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", name, port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	err = lprs.SendConfiguration()
+	require.Nil(t, err)
+
+	// the second connection must be rejected while the first one is running
+	var lprs2 LprSend
+	err = lprs2.Init("127.0.0.1", name, port, "raw", "TestUser", 2*time.Second)
+	require.Nil(t, err)
+
+	err = lprs2.SendConfiguration()
+	require.NotNil(t, err)
+	require.Nil(t, lprs2.Close())
+
+	err = lprs.SendFile()
+	require.Nil(t, err)
+	require.Nil(t, lprs.Close())
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// after the first connection finished, new connections are accepted again
+	time.Sleep(100 * time.Millisecond)
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Nil(t, os.Remove(conn.SaveName))
+}
+
+func TestDaemonConnectionTimeout(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	lprd.SetConnectionTimeout(500*time.Millisecond, 500*time.Millisecond)
+
+	ack := make([]byte, 1)
+
+	// client stalls while the daemon waits for the next command
+	socket, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.Nil(t, err)
+	defer socket.Close()
+
+	_, err = socket.Write([]byte("\x02raw\n"))
+	require.Nil(t, err)
+	_, err = io.ReadFull(socket, ack)
+	require.Nil(t, err)
+
+	start := time.Now()
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	// client stalls in the middle of the control file
+	socket2, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.Nil(t, err)
+	defer socket2.Close()
+
+	_, err = socket2.Write([]byte("\x02raw\n"))
+	require.Nil(t, err)
+	_, err = io.ReadFull(socket2, ack)
+	require.Nil(t, err)
+
+	_, err = socket2.Write([]byte("\x02100 cfA000host\n"))
+	require.Nil(t, err)
+	_, err = io.ReadFull(socket2, ack)
+	require.Nil(t, err)
+
+	_, err = socket2.Write([]byte("Hhost\n"))
+	require.Nil(t, err)
+
+	start = time.Now()
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestDaemonShutdown(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	// all connections finish within the deadline
+	var lprd LprDaemon
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.Nil(t, lprd.Shutdown(ctx))
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// a stalled connection is interrupted
+	var lprd2 LprDaemon
+	err = lprd2.Init(port, "")
+	require.Nil(t, err)
+
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", name, port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	defer lprs.Close()
+
+	err = lprs.SendConfiguration()
+	require.Nil(t, err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	err = lprd2.Shutdown(ctx)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "interrupted 1 connections")
+	require.Contains(t, err.Error(), `queue "raw"`)
+
+	conn = <-lprd2.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+}
+
+func TestDaemonShutdownReleasedConnection(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	// the aborted connection is released as soon as it is delivered
+	delivered := make(chan ConnectionStatus, 1)
+	var lprd LprDaemon
+	lprd.ReuseConnections = true
+	lprd.OnFinishedConnection = func(conn *LprConnection) {
+		status := conn.Status
+		conn.Release()
+		delivered <- status
+	}
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", name, port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	defer lprs.Close()
+
+	err = lprs.SendConfiguration()
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	err = lprd.Shutdown(ctx)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "interrupted 1 connections")
+	require.Contains(t, err.Error(), `queue "raw", user "TestUser"`)
+
+	require.Equal(t, Error, <-delivered)
+}
+
+func TestDaemonRemoveJobs(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	removeJobs := func(command string) byte {
+		socket, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		require.Nil(t, err)
+		defer socket.Close()
+
+		_, err = socket.Write([]byte(command))
+		require.Nil(t, err)
+
+		ack := make([]byte, 1)
+		_, err = io.ReadFull(socket, ack)
+		require.Nil(t, err)
+
+		return ack[0]
+	}
+
+	// not supported without callback
+	require.NotEqual(t, byte(0), removeJobs("\x05raw alice 12\n"))
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+
+	var removed []string
+	lprd.RemoveJobs = func(queue string, agent string, jobs []string) error {
+		require.Equal(t, "raw", queue)
+		if agent != "alice" {
+			return fmt.Errorf("%s is not allowed to remove jobs", agent)
+		}
+		removed = jobs
+		return nil
+	}
+
+	require.Equal(t, byte(0), removeJobs("\x05raw alice 12 13  bob\n"))
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "raw", conn.PrqName)
+	require.Equal(t, "alice", conn.Agent)
+	require.Equal(t, []string{"12", "13", "bob"}, conn.JobList)
+	require.Equal(t, []string{"12", "13", "bob"}, removed)
+
+	require.NotEqual(t, byte(0), removeJobs("\x05raw mallory 12\n"))
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+}
+
+func TestDaemonPrintWaitingJobs(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	queues := make(chan string, 1)
+
+	var lprd LprDaemon
+	lprd.PrintWaitingJobs = func(queue string) error {
+		queues <- queue
+		return nil
+	}
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	socket, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.Nil(t, err)
+
+	_, err = socket.Write([]byte("\x01raw\n"))
+	require.Nil(t, err)
+
+	// the daemon closes the connection without a response
+	rest, err := io.ReadAll(socket)
+	require.Nil(t, err)
+	require.Empty(t, rest)
+	require.Nil(t, socket.Close())
+
+	require.Equal(t, "raw", <-queues)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "raw", conn.PrqName)
+}
+
+func TestDaemonMultipleJobsPerConnection(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	nextExternalID := uint64(0)
+	lprd.GetExternalID = func() uint64 {
+		nextExternalID++
+		return nextExternalID
+	}
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", name, port, "raw", "TestUser1", time.Minute)
+	require.Nil(t, err)
+
+	err = lprs.SendConfiguration()
+	require.Nil(t, err)
+	err = lprs.SendFile()
+	require.Nil(t, err)
+
+	// second job over the same connection
+	lprs.printJobStarted = false
+	lprs.queue = "second"
+	lprs.Config['P'] = "TestUser2"
+
+	err = lprs.SendConfiguration()
+	require.Nil(t, err)
+	err = lprs.SendFile()
+	require.Nil(t, err)
+	require.Nil(t, lprs.Close())
+
+	for i, expected := range []struct {
+		queue string
+		user  string
+	}{{"raw", "TestUser1"}, {"second", "TestUser2"}} {
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+		require.Equal(t, expected.queue, conn.PrqName)
+		require.Equal(t, expected.user, conn.UserIdentification)
+		require.Equal(t, uint64(i+1), conn.ExternalID)
+
+		out, err := os.ReadFile(conn.SaveName)
+		require.Nil(t, err)
+		require.Nil(t, os.Remove(conn.SaveName))
+		require.Equal(t, text, string(out))
+	}
+}
+
+func TestDaemonMultipleJobsPerConnectionState(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	first, err := generateTempFile("", "", "Text for the first file")
+	require.Nil(t, err)
+	defer os.Remove(first)
+	second, err := generateTempFile("", "", "Second")
+	require.Nil(t, err)
+	defer os.Remove(second)
+
+	var lprd LprDaemon
+	lprd.ChecksumHash = sha256.New
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", first, port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	lprs.Config['J'] = "FirstJob"
+	lprs.Config['T'] = "FirstTitle"
+
+	require.Nil(t, lprs.SendConfiguration())
+	require.Nil(t, lprs.SendFile())
+
+	// the second job has neither a job name nor a title
+	lprs.printJobStarted = false
+	lprs.inputFileName = second
+	delete(lprs.Config, 'J')
+	delete(lprs.Config, 'T')
+
+	require.Nil(t, lprs.SendConfiguration())
+	require.Nil(t, lprs.SendFile())
+	require.Nil(t, lprs.Close())
+
+	for _, expected := range []struct {
+		jobName string
+		title   string
+		data    string
+	}{{"FirstJob", "FirstTitle", "Text for the first file"}, {"", "", "Second"}} {
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+		require.Equal(t, expected.jobName, conn.JobName)
+		require.Equal(t, expected.title, conn.TitleText)
+		require.Equal(t, expected.title, conn.ControlFile.Title)
+		require.Equal(t, uint64(len(expected.data)), conn.ReceivedSize)
+		require.True(t, conn.SizeVerified)
+		require.Empty(t, conn.Agent)
+		require.Empty(t, conn.JobList)
+		require.Empty(t, conn.RemovedJobs)
+
+		checksum := sha256.Sum256([]byte(expected.data))
+		require.Equal(t, checksum[:], conn.Checksum)
+
+		out, err := os.ReadFile(conn.SaveName)
+		require.Nil(t, err)
+		require.Nil(t, os.Remove(conn.SaveName))
+		require.Equal(t, expected.data, string(out))
+	}
+
+	// the fields not set by the jobs above are reset as well
+	conn := LprConnection{Agent: "root", JobList: []string{"1"}, RemovedJobs: []string{"1"}, hash: sha256.New()}
+	conn.resetJob()
+	require.Empty(t, conn.Agent)
+	require.Nil(t, conn.JobList)
+	require.Nil(t, conn.RemovedJobs)
+	require.Nil(t, conn.hash)
+}
+
+func TestDaemonSaveControlFile(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.SaveControlFile = true
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	for _, dataFirst := range []bool{false, true} {
+		var lprs LprSend
+		err = lprs.Init("127.0.0.1", name, port, "raw", "TestUser", time.Minute)
+		require.Nil(t, err)
+
+		if dataFirst {
+			require.Nil(t, lprs.SendFile())
+			require.Nil(t, lprs.SendConfiguration())
+		} else {
+			require.Nil(t, lprs.SendConfiguration())
+			require.Nil(t, lprs.SendFile())
+		}
+		require.Nil(t, lprs.Close())
+
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+		require.Equal(t, conn.SaveName+".cf", conn.ControlFileSaveName)
+
+		data, err := os.ReadFile(conn.ControlFileSaveName)
+		require.Nil(t, err)
+
+		cf, err := ParseControlFile(data)
+		require.Nil(t, err)
+		require.Equal(t, conn.ControlFile, cf)
+	}
+}
+
+func TestParseSpoolFileName(t *testing.T) {
+	tests := []struct {
+		fileName  string
+		jobNumber string
+		host      string
+		ok        bool
+	}{
+		{fileName: "cfA123hostname", jobNumber: "123", host: "hostname", ok: true},
+		{fileName: "dfB007host.example.com", jobNumber: "007", host: "host.example.com", ok: true},
+		{fileName: "dfa000", jobNumber: "000", host: "", ok: true},
+		{fileName: "cfA12", ok: false},
+		{fileName: "cfAabchost", ok: false},
+		{fileName: "xfA123host", ok: false},
+		{fileName: "cf1123host", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fileName, func(t *testing.T) {
+			jobNumber, host, ok := parseSpoolFileName(tt.fileName)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.jobNumber, jobNumber)
+			require.Equal(t, tt.host, host)
+		})
+	}
+}
+
+type bufferSink struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *bufferSink) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestDaemonDataSinkFactory(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	sinks := map[*LprConnection]*bufferSink{}
+
+	var lprd LprDaemon
+	lprd.DataSinkFactory = func(job *LprConnection) (io.WriteCloser, error) {
+		sink := &bufferSink{}
+		sinks[job] = sink
+		return sink, nil
+	}
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Empty(t, conn.SaveName)
+	require.Nil(t, conn.Output)
+
+	sink := sinks[conn]
+	require.NotNil(t, sink)
+	require.True(t, sink.closed)
+	require.Equal(t, text, sink.String())
+
+	// errors of the factory abort the connection
+	lprd.DataSinkFactory = func(job *LprConnection) (io.WriteCloser, error) {
+		return nil, errors.New("no sink available")
+	}
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", 2*time.Second)
+	require.NotNil(t, err)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+}
+
+func TestDaemonInMemoryThreshold(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.InMemoryThreshold = 32
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	// small job is kept in memory
+	text := "Text for the file"
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Empty(t, conn.SaveName)
+	require.Equal(t, text, string(conn.Data))
+
+	// large job is saved to disk
+	largeText := strings.Repeat("Text for the file", 10)
+	largeName, err := generateTempFile("", "", largeText)
+	require.Nil(t, err)
+	defer os.Remove(largeName)
+
+	err = Send(largeName, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Nil(t, conn.Data)
+	out, err := os.ReadFile(conn.SaveName)
+	require.Nil(t, err)
+	require.Equal(t, largeText, string(out))
+
+	// job exceeding the announced size is moved to disk
+	file, err := os.Open(largeName)
+	require.Nil(t, err)
+	defer file.Close()
+
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", largeName, port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	require.Nil(t, lprs.SendConfiguration())
+	require.Nil(t, lprs.sendFile(file, 1))
+	require.Nil(t, lprs.Close())
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Nil(t, conn.Data)
+	out, err = os.ReadFile(conn.SaveName)
+	require.Nil(t, err)
+	require.Equal(t, largeText, string(out))
+}
+
+func TestDaemonFileNameTemplate(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.FileNameTemplate = "{queue}_{jobnumber}_{user}"
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	for _, expected := range []string{"raw_000_Test_User", "raw_000_Test_User_1"} {
+		// both jobs use the same job number
+		atomic.StoreUint32(&lastJobNumber, 0)
+
+		err = Send(name, "127.0.0.1", port, "raw", "Test/User", time.Minute)
+		require.Nil(t, err)
+
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+		require.Equal(t, filepath.Join(lprd.InputFileSaveDir, expected), conn.SaveName)
+
+		out, err := os.ReadFile(conn.SaveName)
+		require.Nil(t, err)
+		require.Equal(t, text, string(out))
+	}
+}
+
+func TestDaemonMaxJobSize(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := strings.Repeat("Text for the file", 10)
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.MaxJobSize = 100
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	// announced size exceeds the limit
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "Printer reported an error")
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+	require.Empty(t, conn.SaveName)
+
+	// received size exceeds the limit
+	file, err := os.Open(name)
+	require.Nil(t, err)
+	defer file.Close()
+
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", name, port, "raw", "TestUser", 2*time.Second)
+	require.Nil(t, err)
+	require.Nil(t, lprs.SendConfiguration())
+	require.NotNil(t, lprs.sendFile(file, 10))
+	require.Nil(t, lprs.Close())
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+}
+
+func TestDaemonChecksum(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.ChecksumHash = sha256.New
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+
+	expected := sha256.Sum256([]byte(text))
+	require.Equal(t, expected[:], conn.Checksum)
+}
+
+func TestDaemonAtomicWrites(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.AtomicWrites = true
+	lprd.SyncFiles = true
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, lprd.InputFileSaveDir, filepath.Dir(conn.SaveName))
+
+	out, err := os.ReadFile(conn.SaveName)
+	require.Nil(t, err)
+	require.Equal(t, text, string(out))
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// the part file of an aborted transfer is removed
+	file, err := os.Open(name)
+	require.Nil(t, err)
+	defer file.Close()
+
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", name, port, "raw", "TestUser", 2*time.Second)
+	require.Nil(t, err)
+	require.Nil(t, lprs.SendConfiguration())
+	require.NotNil(t, lprs.sendFile(file, 1024))
+	require.Nil(t, lprs.Close())
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+	require.Empty(t, conn.SaveName)
+
+	entries, err := os.ReadDir(lprd.InputFileSaveDir)
+	require.Nil(t, err)
+	require.Empty(t, entries)
+}
+
+func TestDaemonCheckDiskSpace(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.CheckDiskSpace = true
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+
+	if _, supported, _ := freeDiskSpace(lprd.InputFileSaveDir); !supported {
+		t.Skip("checking the available disk space is not supported")
+	}
+
+	// no filesystem has that much space available
+	lprd.DiskSpaceMargin = math.MaxUint64 - 1024
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.NotNil(t, err)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+	require.Empty(t, conn.SaveName)
+}
+
+func TestDaemonSpoolFileNames(t *testing.T) {
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.SetFileMask(0640)
+
+	conn := LprConnection{daemon: &lprd}
+
+	names := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		file, err := conn.createTempFile()
+		require.Nil(t, err)
+		require.Nil(t, file.Close())
+
+		require.False(t, names[file.Name()])
+		names[file.Name()] = true
+
+		require.True(t, strings.HasPrefix(filepath.Base(file.Name()), "lpr_data_"))
+
+		fi, err := os.Stat(file.Name())
+		require.Nil(t, err)
+		require.Equal(t, fs.FileMode(0640), fi.Mode().Perm())
+	}
+}
+
+func TestDaemonCompressor(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := strings.Repeat("Text for the file", 100)
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.Compressor = GzipCompressor{}
+	lprd.AtomicWrites = true
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, ".gz", filepath.Ext(conn.SaveName))
+	require.Equal(t, uint64(len(text)), conn.UncompressedSize)
+
+	fi, err := os.Stat(conn.SaveName)
+	require.Nil(t, err)
+	require.Equal(t, uint64(fi.Size()), conn.CompressedSize)
+	require.Less(t, conn.CompressedSize, conn.UncompressedSize)
+
+	file, err := os.Open(conn.SaveName)
+	require.Nil(t, err)
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	require.Nil(t, err)
+	out, err := io.ReadAll(reader)
+	require.Nil(t, err)
+	require.Equal(t, text, string(out))
+}
+
+func TestDaemonOnReceiveJob(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.OnReceiveJob = func(remoteAddr net.Addr, queue string) error {
+		require.NotNil(t, remoteAddr)
+		if queue != "raw" {
+			return fmt.Errorf("unknown queue %s", queue)
+		}
+		return nil
+	}
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	err = Send(name, "127.0.0.1", port, "unknown", "TestUser", time.Minute)
+	require.NotNil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+	require.Equal(t, "unknown", conn.PrqName)
+	require.Empty(t, conn.SaveName)
+
+	entries, err := os.ReadDir(lprd.InputFileSaveDir)
+	require.Nil(t, err)
+	require.Empty(t, entries)
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Nil(t, os.Remove(conn.SaveName))
+}
+
+func TestDaemonNackCodes(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.MaxJobSize = 10
+	lprd.OnReceiveJob = func(remoteAddr net.Addr, queue string) error {
+		switch queue {
+		case "raw":
+			return nil
+		case "disabled":
+			return &NackError{Code: NackRetry, Err: errors.New("queue disabled")}
+		default:
+			return fmt.Errorf("unknown queue %s", queue)
+		}
+	}
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	// send sends the given commands and returns the last acknowledgement
+	send := func(commands ...string) byte {
+		socket, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		require.Nil(t, err)
+		defer socket.Close()
+
+		ack := make([]byte, 1)
+		for _, command := range commands {
+			_, err = socket.Write([]byte(command))
+			require.Nil(t, err)
+
+			_, err = io.ReadFull(socket, ack)
+			require.Nil(t, err)
+			if ack[0] != 0 {
+				break
+			}
+		}
+
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, Error, conn.Status)
+
+		return ack[0]
+	}
+
+	require.Equal(t, byte(NackRetry), send("\x02disabled\n"))
+	require.Equal(t, byte(NackFailure), send("\x02unknown\n"))
+	require.Equal(t, byte(NackRejected), send("\x02raw\n", "\x0311 dfA001host\n"))
+	require.Equal(t, byte(NackFailure), send("\x02raw\n", "\x03abc dfA001host\n"))
+	require.Equal(t, byte(NackFailure), send("\x02raw\n", "\x07unknown\n"))
+	require.Equal(t, byte(NackRejected), send("\x05raw alice 12\n"))
+
+	// a data file without size which exceeds the maximum job size
+	require.Equal(t, byte(NackRejected), send("\x02raw\n", "\x030 dfA001host\n", strings.Repeat("x", 20)))
+}
+
+func TestDaemonAuthorizeRemoveJobs(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var removed []string
+
+	var lprd LprDaemon
+	lprd.RemoveJobs = func(queue string, agent string, jobs []string) error {
+		removed = jobs
+		return nil
+	}
+	lprd.AuthorizeRemoveJobs = func(remoteAddr net.Addr, agent string, queue string, jobs []string) error {
+		require.NotNil(t, remoteAddr)
+		require.Equal(t, "raw", queue)
+		if agent == "root" {
+			return nil
+		}
+		for _, job := range jobs {
+			if job != agent {
+				return fmt.Errorf("%s may only remove own jobs", agent)
+			}
+		}
+		return nil
+	}
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	removeJobs := func(command string) byte {
+		socket, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		require.Nil(t, err)
+		defer socket.Close()
+
+		_, err = socket.Write([]byte(command))
+		require.Nil(t, err)
+
+		ack := make([]byte, 1)
+		_, err = io.ReadFull(socket, ack)
+		require.Nil(t, err)
+
+		return ack[0]
+	}
+
+	require.Equal(t, byte(NackRejected), removeJobs("\x05raw alice bob\n"))
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+	require.Nil(t, removed)
+
+	require.Equal(t, byte(0), removeJobs("\x05raw alice alice\n"))
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, []string{"alice"}, removed)
+
+	require.Equal(t, byte(0), removeJobs("\x05raw root bob\n"))
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, []string{"bob"}, removed)
+}
+
+func TestDaemonOnMailRequest(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	requests := make(chan string, 10)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.OnMailRequest = func(job *LprConnection, mailbox string) {
+		require.Equal(t, "TestUser", job.UserIdentification)
+		requests <- mailbox
+	}
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	for _, mailbox := range []string{"alice@example.com", ""} {
+		var lprs LprSend
+		err = lprs.Init("127.0.0.1", name, port, "raw", "TestUser", time.Minute)
+		require.Nil(t, err)
+		if mailbox != "" {
+			lprs.Config['M'] = mailbox
+		}
+
+		require.Nil(t, lprs.SendConfiguration())
+		require.Nil(t, lprs.SendFile())
+		require.Nil(t, lprs.Close())
+
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+		require.Equal(t, mailbox, conn.MailUser)
+		require.Nil(t, os.Remove(conn.SaveName))
+	}
+
+	close(requests)
+	mailboxes := []string{}
+	for mailbox := range requests {
+		mailboxes = append(mailboxes, mailbox)
+	}
+	require.Equal(t, []string{"alice@example.com"}, mailboxes)
+}
+
+func TestDaemonGenerateBanner(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	tests := []struct {
+		name              string
+		printBanner       bool
+		inMemoryThreshold uint64
+		compressor        Compressor
+	}{
+		{name: "file", printBanner: true},
+		{name: "no banner", printBanner: false},
+		{name: "memory", printBanner: true, inMemoryThreshold: 1024},
+		{name: "compressed", printBanner: true, compressor: GzipCompressor{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lprd := &LprDaemon{}
+			lprd.InputFileSaveDir = t.TempDir()
+			lprd.InMemoryThreshold = test.inMemoryThreshold
+			lprd.Compressor = test.compressor
+			lprd.GenerateBanner = func(job *LprConnection) ([]byte, error) {
+				return []byte(fmt.Sprintf("Banner %s %s %s\n", job.ClassName, job.JobName, job.BannerUser)), nil
+			}
+			err := lprd.Init(port, "")
+			require.Nil(t, err)
+			defer lprd.Close()
+
+			var lprs LprSend
+			err = lprs.Init("127.0.0.1", name, port, "raw", "TestUser", time.Minute)
+			require.Nil(t, err)
+			lprs.Config['C'] = "A"
+			lprs.Config['J'] = "Report"
+			if test.printBanner {
+				lprs.Config['L'] = "alice"
+			}
+
+			require.Nil(t, lprs.SendConfiguration())
+			require.Nil(t, lprs.SendFile())
+			require.Nil(t, lprs.Close())
+
+			conn := <-lprd.FinishedConnections()
+			require.Equal(t, End, conn.Status)
+			require.Equal(t, test.printBanner, conn.PrintBanner)
+
+			expected := text
+			if test.printBanner {
+				require.Equal(t, "alice", conn.BannerUser)
+				expected = "Banner A Report alice\n" + text
+			}
+
+			var data []byte
+			switch {
+			case test.inMemoryThreshold > 0:
+				data = conn.Data
+			case test.compressor != nil:
+				file, err := os.Open(conn.SaveName)
+				require.Nil(t, err)
+				defer file.Close()
+
+				reader, err := gzip.NewReader(file)
+				require.Nil(t, err)
+
+				data, err = io.ReadAll(reader)
+				require.Nil(t, err)
+			default:
+				data, err = os.ReadFile(conn.SaveName)
+				require.Nil(t, err)
+			}
+			require.Equal(t, expected, string(data))
+
+			// no part files are left
+			parts, err := filepath.Glob(filepath.Join(lprd.InputFileSaveDir, ".lpr_part_*"))
+			require.Nil(t, err)
+			require.Empty(t, parts)
+		})
+	}
+}
+
+func TestDaemonMaxControlFileSize(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	// the default limit rejects huge control files before allocating them
+	socket, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.Nil(t, err)
+	defer socket.Close()
+
+	ack := make([]byte, 1)
+	_, err = socket.Write([]byte("\x02raw\n"))
+	require.Nil(t, err)
+	_, err = io.ReadFull(socket, ack)
+	require.Nil(t, err)
+	require.Equal(t, byte(0), ack[0])
+
+	_, err = socket.Write([]byte(fmt.Sprintf("\x02%d cfA001host\n", uint64(math.MaxUint64))))
+	require.Nil(t, err)
+	_, err = io.ReadFull(socket, ack)
+	require.Nil(t, err)
+	require.Equal(t, byte(NackRejected), ack[0])
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+
+	// a configured limit
+	lprd.MaxControlFileSize = 10
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.NotNil(t, err)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+	require.Nil(t, conn.ControlFile)
+}
+
+func TestDaemonCommandLimits(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	lprd.MaxCommandDuration = 300 * time.Millisecond
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	// a client trickling the command is disconnected
+	socket, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.Nil(t, err)
+	defer socket.Close()
+
+	start := time.Now()
+	for _, b := range []byte("\x03raw queue") {
+		_, err = socket.Write([]byte{b})
+		if err != nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+	require.Less(t, time.Since(start), time.Second)
+
+	// a command without line feed exceeding the default maximum size is rejected
+	socket2, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.Nil(t, err)
+	defer socket2.Close()
+
+	// the daemon may close the connection before all bytes were written
+	socket2.Write([]byte("\x03" + strings.Repeat("x", DefaultMaxCommandSize+1)))
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+
+	// the command size can be limited further
+	lprd.MaxCommandSize = 10
+
+	status, _ := GetStatus("127.0.0.1", port, "a_long_queue_name", false, 2*time.Second)
+	require.Empty(t, status)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+
+	_, err = GetStatus("127.0.0.1", port, "raw", false, 2*time.Second)
+	require.Nil(t, err)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+}
+
+func TestDaemonQueues(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.Queues = []string{"raw", "text"}
+	lprd.RemoveJobs = func(queue string, agent string, jobs []string) error {
+		return nil
+	}
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	// send sends command and returns the response
+	send := func(command string) string {
+		socket, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		require.Nil(t, err)
+		defer socket.Close()
+
+		_, err = socket.Write([]byte(command))
+		require.Nil(t, err)
+
+		response, _ := io.ReadAll(socket)
+		return string(response)
+	}
+
+	tests := []struct {
+		command  string
+		response string
+		status   ConnectionStatus
+	}{
+		{command: "\x02other\n", response: "\x03", status: Error},
+		{command: "\x03other\n", response: "unknown queue other\n", status: Error},
+		{command: "\x04other alice\n", response: "unknown queue other\n", status: Error},
+		{command: "\x05other alice 12\n", response: "\x03", status: Error},
+		{command: "\x01other\n", response: "", status: Error},
+		{command: "\x03text\n", response: "Idle\n", status: End},
+		{command: "\x05raw alice 12\n", response: "\x00", status: End},
+		{command: "\x01raw\n", response: "", status: End},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.response, send(test.command), test.command)
+
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, test.status, conn.Status, test.command)
+	}
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Nil(t, os.Remove(conn.SaveName))
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"../../etc/passwd", ".._.._etc_passwd"},
+		{"C:\\Users\\alice\\report.pdf", "C:_Users_alice_report.pdf"},
+		{"line\nbreak\x7f", "line_break_"},
+		{".", "_"},
+		{"..", "_"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, SanitizeFileName(test.value), test.value)
+	}
+}
+
+func TestDaemonSanitizedFileNames(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.FileNameTemplate = "{filename}"
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	for _, fileName := range []string{"../../report.pdf", "C:\\Users\\alice\\report.pdf"} {
+		var lprs LprSend
+		err = lprs.Init("127.0.0.1", name, port, "raw", "TestUser", time.Minute)
+		require.Nil(t, err)
+		lprs.Config['N'] = fileName
+
+		require.Nil(t, lprs.SendConfiguration())
+		require.Nil(t, lprs.SendFile())
+		require.Nil(t, lprs.Close())
+
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+		require.Equal(t, fileName, conn.Filename)
+		require.Equal(t, SanitizeFileName(fileName), conn.SanitizedFilename())
+		require.Equal(t, filepath.Join(lprd.InputFileSaveDir, "report.pdf"), conn.SaveName)
+		require.Nil(t, os.Remove(conn.SaveName))
+	}
+}
+
+func TestDaemonPipelinedCommands(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+	controlFile := "Hhost\nPTestUser\nldfA001host\n"
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	socket, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.Nil(t, err)
+	defer socket.Close()
+
+	// all commands and files are sent at once without waiting for the acknowledgements
+	job := "\x02raw\n" +
+		fmt.Sprintf("\x02%d cfA001host\n", len(controlFile)) + controlFile + "\x00" +
+		fmt.Sprintf("\x03%d dfA001host\n", len(text)) + text + "\x00"
+	_, err = socket.Write([]byte(job))
+	require.Nil(t, err)
+
+	acks := make([]byte, 5)
+	_, err = io.ReadFull(socket, acks)
+	require.Nil(t, err)
+	require.Equal(t, make([]byte, 5), acks)
+	require.Nil(t, socket.Close())
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "TestUser", conn.UserIdentification)
+
+	data, err := os.ReadFile(conn.SaveName)
+	require.Nil(t, err)
+	require.Equal(t, text, string(data))
+}
+
+func TestDaemonReceiveBufferSize(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := strings.Repeat("Text for the file\n", 50000)
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.ReceiveBufferSize = 256 * 1024
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, int64(256*1024), conn.BufferSize)
+
+	data, err := os.ReadFile(conn.SaveName)
+	require.Nil(t, err)
+	require.Equal(t, text, string(data))
+}
+
+func TestDaemonWorkers(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.Workers = 1
+	lprd.AcceptQueueSize = 1
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	// the first connection occupies the only worker
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", name, port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	require.Nil(t, lprs.SendConfiguration())
+
+	// the second connection waits in the accept queue
+	queued, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.Nil(t, err)
+	defer queued.Close()
+	_, err = queued.Write([]byte("\x03raw\n"))
+	require.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	// the third connection is rejected, because the queue is full
+	rejected, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.Nil(t, err)
+	defer rejected.Close()
+	rejected.SetReadDeadline(time.Now().Add(5 * time.Second))
+	response, _ := io.ReadAll(rejected)
+	require.Empty(t, response)
+
+	require.Nil(t, lprs.SendFile())
+	require.Nil(t, lprs.Close())
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, ConnectionTypeReceivePrintJob, conn.connectionType)
+
+	// the queued connection is processed once the worker is free
+	queued.SetReadDeadline(time.Now().Add(5 * time.Second))
+	response, err = io.ReadAll(queued)
+	require.Nil(t, err)
+	require.Equal(t, "Idle\n", string(response))
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+}
+
+func TestDaemonWorkersShutdown(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	lprd.Workers = 1
+	lprd.AcceptQueueSize = 1
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+
+	running, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.Nil(t, err)
+	defer running.Close()
+	_, err = running.Write([]byte("\x02raw\n"))
+	require.Nil(t, err)
+
+	queued, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.Nil(t, err)
+	defer queued.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	// the queued connection must not block the shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- lprd.Shutdown(ctx)
+	}()
+
+	select {
+	case err = <-done:
+		require.NotNil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not return")
+	}
+}
+
+func TestDaemonOnFinishedConnection(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	finished := make(chan *LprConnection, 10)
+
+	lprd := &LprDaemon{}
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.OnFinishedConnection = func(conn *LprConnection) {
+		finished <- conn
+	}
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+		require.Nil(t, err)
+
+		conn := <-finished
+		require.Equal(t, End, conn.Status)
+		require.Nil(t, os.Remove(conn.SaveName))
+	}
+
+	lprd.Close()
+
+	// the channel is not used, but closed
+	_, ok := <-lprd.FinishedConnections()
+	require.False(t, ok)
+
+	// the capacity of the channel can be configured
+	lprd2 := &LprDaemon{}
+	lprd2.FinishedConnectionsSize = 5
+	err = lprd2.Init(port, "")
+	require.Nil(t, err)
+	defer lprd2.Close()
+	require.Equal(t, 5, cap(lprd2.FinishedConnections()))
+}
+
+func TestDaemonReuseConnections(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	lprd := &LprDaemon{}
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.ReuseConnections = true
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	for i := 0; i < 5; i++ {
+		text := fmt.Sprintf("Text for file %d", i)
+		name, err := generateTempFile("", "", text)
+		require.Nil(t, err)
+		defer os.Remove(name)
+
+		err = Send(name, "127.0.0.1", port, "raw", fmt.Sprintf("User%d", i), time.Minute)
+		require.Nil(t, err)
+
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+		require.Equal(t, fmt.Sprintf("User%d", i), conn.UserIdentification)
+
+		data, err := os.ReadFile(conn.SaveName)
+		require.Nil(t, err)
+		require.Equal(t, text, string(data))
+		require.Nil(t, os.Remove(conn.SaveName))
+
+		conn.Release()
+		require.Empty(t, conn.SaveName)
+		require.Empty(t, conn.UserIdentification)
+		require.Nil(t, conn.Connection)
+	}
+
+	// without ReuseConnections, Release does nothing
+	lprd.ReuseConnections = false
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	conn.Release()
+	require.Equal(t, "TestUser", conn.UserIdentification)
+	require.Nil(t, os.Remove(conn.SaveName))
+}
+
+func TestDaemonVerifySize(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.ChecksumHash = sha256.New
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, uint64(17), conn.ReceivedSize)
+	require.True(t, conn.SizeVerified)
+	checksum := sha256.Sum256([]byte("Text for the file"))
+	require.Equal(t, checksum[:], conn.Checksum)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// sendJob announces a data file with 10 bytes, but sends 15 bytes
+	sendJob := func() byte {
+		socket, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		require.Nil(t, err)
+		defer socket.Close()
+
+		ack := make([]byte, 1)
+		_, err = socket.Write([]byte("\x02raw\n"))
+		require.Nil(t, err)
+		_, err = io.ReadFull(socket, ack)
+		require.Nil(t, err)
+		require.Equal(t, byte(0), ack[0])
+
+		_, err = socket.Write([]byte("\x0310 dfA001host\n"))
+		require.Nil(t, err)
+		_, err = io.ReadFull(socket, ack)
+		require.Nil(t, err)
+		require.Equal(t, byte(0), ack[0])
+
+		_, err = socket.Write([]byte("123456789012345\x00"))
+		require.Nil(t, err)
+		_, err = io.ReadFull(socket, ack)
+		require.Nil(t, err)
+		if ack[0] != 0 {
+			return ack[0]
+		}
+
+		controlFile := "Hhost\nPTestUser\nldfA001host\n"
+		_, err = socket.Write([]byte(fmt.Sprintf("\x02%d cfA001host\n", len(controlFile))))
+		require.Nil(t, err)
+		_, err = io.ReadFull(socket, ack)
+		require.Nil(t, err)
+		require.Equal(t, byte(0), ack[0])
+
+		_, err = socket.Write([]byte(controlFile + "\x00"))
+		require.Nil(t, err)
+		_, err = io.ReadFull(socket, ack)
+		require.Nil(t, err)
+
+		return ack[0]
+	}
+
+	// the mismatch is only reported
+	require.Equal(t, byte(0), sendJob())
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, uint64(10), conn.Filesize)
+	require.Equal(t, uint64(15), conn.ReceivedSize)
+	require.False(t, conn.SizeVerified)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// the job is rejected
+	lprd.VerifySize = true
+	require.Equal(t, byte(NackFailure), sendJob())
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+	require.False(t, conn.SizeVerified)
+}
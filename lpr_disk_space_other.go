@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package lprlib
+
+// freeDiskSpace is not supported on this platform.
+func freeDiskSpace(dir string) (uint64, bool, error) {
+	return 0, false, nil
+}
@@ -0,0 +1,20 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package lprlib
+
+import (
+	"syscall"
+)
+
+// freeDiskSpace returns the number of bytes available to unprivileged users on the filesystem of dir.
+// The second return value states if the available space could be determined on this platform.
+func freeDiskSpace(dir string) (uint64, bool, error) {
+	var stat syscall.Statfs_t
+
+	err := syscall.Statfs(dir, &stat)
+	if err != nil {
+		return 0, true, err
+	}
+
+	return uint64(stat.Bavail) * uint64(stat.Bsize), true, nil
+}
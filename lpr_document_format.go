@@ -0,0 +1,128 @@
+package lprlib
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/documatrix/go-lprlib/pjl"
+)
+
+// DocumentFormat is the MIME media type of the content of a data file (see DetectDocumentFormat).
+type DocumentFormat string
+
+// Document formats detected by DetectDocumentFormat
+const (
+	// DocumentFormatUnknown is used for data files of an unknown format
+	DocumentFormatUnknown DocumentFormat = "application/octet-stream"
+
+	// DocumentFormatPDF is used for PDF documents
+	DocumentFormatPDF DocumentFormat = "application/pdf"
+
+	// DocumentFormatPostScript is used for PostScript documents
+	DocumentFormatPostScript DocumentFormat = "application/postscript"
+
+	// DocumentFormatPCL is used for HP PCL (including PCL XL) print data
+	DocumentFormatPCL DocumentFormat = "application/vnd.hp-PCL"
+
+	// DocumentFormatZPL is used for Zebra ZPL label data
+	DocumentFormatZPL DocumentFormat = "application/vnd.zebra-zpl"
+
+	// DocumentFormatText is used for plain text
+	DocumentFormatText DocumentFormat = "text/plain"
+
+	// DocumentFormatPNG is used for PNG images
+	DocumentFormatPNG DocumentFormat = "image/png"
+
+	// DocumentFormatTIFF is used for TIFF images
+	DocumentFormatTIFF DocumentFormat = "image/tiff"
+)
+
+// pjlLanguageFormats contains the document formats of the PJL printer languages.
+var pjlLanguageFormats = map[string]DocumentFormat{
+	"PCL":        DocumentFormatPCL,
+	"PCLXL":      DocumentFormatPCL,
+	"POSTSCRIPT": DocumentFormatPostScript,
+	"PDF":        DocumentFormatPDF,
+	"ZPL":        DocumentFormatZPL,
+}
+
+// DetectDocumentFormat detects the format of a data file from its first bytes, e.g. the first
+// 64 KiB (see pjl.MaxHeaderSize). A PJL job header in front of the print data is skipped,
+// if the print data is not recognized, the printer language of the header is used.
+func DetectDocumentFormat(data []byte) DocumentFormat {
+	header, err := pjl.Parse(data)
+	if err != nil {
+		return detectDocumentFormat(data)
+	}
+
+	format := detectDocumentFormat(data[header.Size:])
+	if format == DocumentFormatUnknown || format == DocumentFormatText {
+		if languageFormat, ok := pjlLanguageFormats[header.Language]; ok {
+			return languageFormat
+		}
+	}
+
+	return format
+}
+
+// detectDocumentFormat detects the format of print data by its signature.
+func detectDocumentFormat(data []byte) DocumentFormat {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return DocumentFormatPNG
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return DocumentFormatTIFF
+	case bytes.HasPrefix(data, []byte("\xc5\xd0\xd3\xc6")):
+		// DOS EPS binary file header
+		return DocumentFormatPostScript
+	}
+
+	// PCL jobs may start with UEL commands without PJL header
+	for bytes.HasPrefix(data, []byte(pjl.UEL)) {
+		data = data[len(pjl.UEL):]
+	}
+
+	switch {
+	case len(data) > 1 && data[0] == 0x1b && strings.IndexByte("E%&*(", data[1]) >= 0,
+		bytes.HasPrefix(data, []byte(") HP-PCL XL;")):
+		return DocumentFormatPCL
+	}
+
+	trimmed := bytes.TrimLeft(data, " \t\r\n\x04")
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("%PDF-")):
+		return DocumentFormatPDF
+	case bytes.HasPrefix(trimmed, []byte("%!")):
+		return DocumentFormatPostScript
+	case bytes.HasPrefix(trimmed, []byte("^XA")),
+		bytes.HasPrefix(trimmed, []byte("~")) && bytes.Contains(trimmed, []byte("^XA")):
+		return DocumentFormatZPL
+	case isText(data):
+		return DocumentFormatText
+	}
+
+	return DocumentFormatUnknown
+}
+
+// isText tells if the data is UTF-8 encoded text without control characters other than
+// tabs, line breaks and form feeds. An incomplete character at the end of the data is ignored.
+func isText(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size <= 1 {
+			return !utf8.FullRune(data)
+		}
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' || r == 0x7f {
+			return false
+		}
+
+		data = data[size:]
+	}
+
+	return true
+}
@@ -0,0 +1,77 @@
+package lprlib
+
+import (
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/documatrix/go-lprlib/pjl"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectDocumentFormat(t *testing.T) {
+	tests := []struct {
+		data   string
+		format DocumentFormat
+	}{
+		{"%PDF-1.7\n%\xe2\xe3\xcf\xd3\n", DocumentFormatPDF},
+		{"\n%!PS-Adobe-3.0\n", DocumentFormatPostScript},
+		{"\x04%!PS-Adobe-3.0\n", DocumentFormatPostScript},
+		{"\xc5\xd0\xd3\xc6\x20\x00", DocumentFormatPostScript},
+		{"\x1bE\x1b&l0O", DocumentFormatPCL},
+		{pjl.UEL + "\x1bE\x1b&l0O", DocumentFormatPCL},
+		{") HP-PCL XL;2;0\n", DocumentFormatPCL},
+		{"^XA^FO50,50^FDLabel^FS^XZ", DocumentFormatZPL},
+		{"~SD15\n^XA^XZ", DocumentFormatZPL},
+		{"\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR", DocumentFormatPNG},
+		{"II*\x00\x08\x00", DocumentFormatTIFF},
+		{"MM\x00*\x00\x08", DocumentFormatTIFF},
+		{"Hello\tWorld\r\n\fÄÖÜ", DocumentFormatText},
+		{"Gr\xc3", DocumentFormatText},
+		{"Gr\xc3 n", DocumentFormatUnknown},
+		{"\x00\x01\x02", DocumentFormatUnknown},
+		{"", DocumentFormatUnknown},
+
+		// PJL headers are skipped
+		{pjl.UEL + "@PJL JOB\r\n@PJL ENTER LANGUAGE=POSTSCRIPT\r\n%!PS-Adobe-3.0\n", DocumentFormatPostScript},
+		{pjl.UEL + "@PJL ENTER LANGUAGE=PDF\n%PDF-1.4\n", DocumentFormatPDF},
+		{pjl.UEL + "@PJL ENTER LANGUAGE=PCLXL\n\x00\x01", DocumentFormatPCL},
+		{pjl.UEL + "@PJL SET COPIES=2\n", DocumentFormatUnknown},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.format, DetectDocumentFormat([]byte(test.data)), "%q", test.data)
+	}
+}
+
+func TestDaemonDetectFormat(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.DetectFormat = true
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+
+	for _, data := range []string{"%PDF-1.7\n" + strings.Repeat("\x00\xff", 50000), "^XA^FDLabel^FS^XZ"} {
+		err = SendStream(strings.NewReader(data), int64(len(data)), "label", "127.0.0.1", port, "raw", "TestUser", time.Minute)
+		require.Nil(t, err)
+	}
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, DocumentFormatPDF, conn.DetectedFormat)
+	require.Nil(t, conn.PJL)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, DocumentFormatZPL, conn.DetectedFormat)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	lprd.Close()
+}
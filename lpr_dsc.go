@@ -0,0 +1,189 @@
+package lprlib
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxDSCLineLength is the maximum length of a DSC comment line (see DSC specification 3.0, chapter 3).
+// Longer lines are truncated.
+const maxDSCLineLength = 255
+
+// DSCComments contains the document structuring conventions (DSC) comments of a PostScript document.
+type DSCComments struct {
+	// Title is the title of the document (%%Title)
+	Title string
+
+	// Creator is the application which created the document (%%Creator)
+	Creator string
+
+	// For is the user the document was created for (%%For)
+	For string
+
+	// CreationDate is the creation date of the document as written by the creator (%%CreationDate)
+	CreationDate string
+
+	// Pages is the number of pages of the document (%%Pages).
+	// If the document doesn't announce it, the pages marked by %%Page comments are counted.
+	Pages int
+}
+
+// ParseDSC parses the DSC comments of the PostScript document read from the reader.
+// Comments of embedded documents (%%BeginDocument ... %%EndDocument) are ignored.
+func ParseDSC(reader io.Reader) (*DSCComments, error) {
+	scanner := newDSCScanner()
+
+	_, err := io.Copy(scanner, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanner.finish(), nil
+}
+
+// dscScanner parses the DSC comments of a PostScript document written to it in arbitrary chunks.
+type dscScanner struct {
+	comments DSCComments
+
+	// line contains the start of the current line (up to maxDSCLineLength bytes)
+	line []byte
+
+	// lineStart tells if the next byte starts a new line
+	lineStart bool
+
+	// comment tells if the current line is a DSC comment
+	comment bool
+
+	// pagesAnnounced tells if the number of pages was set by a %%Pages comment
+	pagesAnnounced bool
+
+	// pageComments is the number of %%Page comments
+	pageComments int
+
+	// embedded is the nesting depth of embedded documents
+	embedded int
+}
+
+// newDSCScanner creates a scanner expecting the start of a document.
+func newDSCScanner() *dscScanner {
+	return &dscScanner{lineStart: true, line: make([]byte, 0, maxDSCLineLength)}
+}
+
+// Write parses the given part of the document.
+func (s *dscScanner) Write(data []byte) (int, error) {
+	written := len(data)
+
+	for len(data) > 0 {
+		if s.lineStart {
+			s.lineStart = false
+			s.comment = data[0] == '%'
+			s.line = s.line[:0]
+		}
+
+		end := bytes.IndexAny(data, "\r\n")
+		part := data
+		if end >= 0 {
+			part = data[:end]
+		}
+
+		if s.comment {
+			if missing := maxDSCLineLength - len(s.line); missing > 0 {
+				if len(part) > missing {
+					part = part[:missing]
+				}
+				s.line = append(s.line, part...)
+			}
+		}
+
+		if end < 0 {
+			break
+		}
+
+		if s.comment {
+			s.parseLine(string(s.line))
+		}
+		s.lineStart = true
+		data = data[end+1:]
+	}
+
+	return written, nil
+}
+
+// parseLine parses a complete comment line.
+func (s *dscScanner) parseLine(line string) {
+	if !strings.HasPrefix(line, "%%") {
+		return
+	}
+
+	keyword, value, _ := strings.Cut(line[2:], ":")
+	value = strings.TrimSpace(value)
+
+	switch keyword {
+	case "BeginDocument":
+		s.embedded++
+		return
+	case "EndDocument":
+		if s.embedded > 0 {
+			s.embedded--
+		}
+		return
+	}
+
+	if s.embedded > 0 {
+		return
+	}
+
+	switch keyword {
+	case "Title":
+		setDSCText(&s.comments.Title, value)
+	case "Creator":
+		setDSCText(&s.comments.Creator, value)
+	case "For":
+		setDSCText(&s.comments.For, value)
+	case "CreationDate":
+		setDSCText(&s.comments.CreationDate, value)
+	case "Pages":
+		// the number of pages may be deferred to the trailer by (atend)
+		fields := strings.Fields(value)
+		if len(fields) > 0 {
+			pages, err := strconv.Atoi(fields[0])
+			if err == nil && pages >= 0 {
+				s.comments.Pages = pages
+				s.pagesAnnounced = true
+			}
+		}
+	case "Page":
+		s.pageComments++
+	}
+}
+
+// setDSCText sets the text of a comment if it wasn't set before.
+// Texts may be enclosed in parentheses and deferred to the trailer by (atend).
+func setDSCText(text *string, value string) {
+	if *text != "" || value == "" || value == "(atend)" {
+		return
+	}
+
+	if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+		value = value[1 : len(value)-1]
+	}
+
+	*text = value
+}
+
+// finish returns the comments of the document after it was written completely.
+func (s *dscScanner) finish() *DSCComments {
+	if !s.lineStart && s.comment {
+		s.parseLine(string(s.line))
+		s.lineStart = true
+	}
+
+	comments := s.comments
+	if !s.pagesAnnounced {
+		comments.Pages = s.pageComments
+	}
+
+	return &comments
+}
@@ -0,0 +1,92 @@
+package lprlib
+
+import (
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/documatrix/go-lprlib/pjl"
+	"github.com/stretchr/testify/require"
+)
+
+const testPostScript = "%!PS-Adobe-3.0\r\n" +
+	"%%Title: (Invoice 42)\r\n" +
+	"%%Creator: Writer\r\n" +
+	"%%For: jdoe\r\n" +
+	"%%CreationDate: (D:20240101120000)\r\n" +
+	"%%Pages: (atend)\r\n" +
+	"%%EndComments\r\n" +
+	"%%Page: 1 1\r\n" +
+	"%%BeginDocument: logo.eps\r\n" +
+	"%%Title: Logo\r\n" +
+	"%%Pages: 1\r\n" +
+	"%%Page: 1 1\r\n" +
+	"%%EndDocument\r\n" +
+	"showpage\r\n" +
+	"%%Page: 2 2\r\n" +
+	"showpage\r\n" +
+	"%%Trailer\r\n" +
+	"%%Pages: 2\r\n" +
+	"%%EOF"
+
+func TestParseDSC(t *testing.T) {
+	comments, err := ParseDSC(strings.NewReader(testPostScript))
+	require.Nil(t, err)
+	require.Equal(t, &DSCComments{Title: "Invoice 42", Creator: "Writer", For: "jdoe", CreationDate: "D:20240101120000", Pages: 2}, comments)
+
+	// the document may be written in arbitrary chunks
+	scanner := newDSCScanner()
+	for i := 0; i < len(testPostScript); i += 3 {
+		end := i + 3
+		if end > len(testPostScript) {
+			end = len(testPostScript)
+		}
+		scanner.Write([]byte(testPostScript[i:end]))
+	}
+	require.Equal(t, comments, scanner.finish())
+
+	// without %%Pages, the pages are counted
+	comments, err = ParseDSC(strings.NewReader("%!PS\n%%Title: " + strings.Repeat("x", 300) + "\n%%Page: 1 1\n%%Page: 2 2\n%%Page: 3 3\n"))
+	require.Nil(t, err)
+	require.Equal(t, 3, comments.Pages)
+	require.Equal(t, maxDSCLineLength-len("%%Title: "), len(comments.Title))
+}
+
+func TestDaemonParseDSC(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.ParseDSC = true
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+
+	documents := []string{
+		pjl.UEL + "@PJL ENTER LANGUAGE=POSTSCRIPT\n" + testPostScript + pjl.UEL,
+		"%PDF-1.7\n%%Pages: 2\n",
+	}
+	for _, data := range documents {
+		err = SendStream(strings.NewReader(data), int64(len(data)), "document", "127.0.0.1", port, "raw", "TestUser", time.Minute)
+		require.Nil(t, err)
+	}
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.NotNil(t, conn.DSC)
+	require.Equal(t, "Invoice 42", conn.DSC.Title)
+	require.Equal(t, "Writer", conn.DSC.Creator)
+	require.Equal(t, 2, conn.DSC.Pages)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// no PostScript document
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Nil(t, conn.DSC)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	lprd.Close()
+}
@@ -0,0 +1,123 @@
+package lprlib
+
+import "time"
+
+// JobEventType is the type of a JobEvent.
+type JobEventType int
+
+const (
+	// JobAccepted means, that a client started to send a print job (02 - Receive a printer job).
+	JobAccepted JobEventType = 0
+
+	// ControlFileReceived means, that the control file of the job was received.
+	ControlFileReceived JobEventType = 1
+
+	// DataFileStarted means, that the client started to send the data file of the job.
+	DataFileStarted JobEventType = 2
+
+	// DataFileProgress means, that a part of the data file was received (see JobEvent.BytesReceived).
+	DataFileProgress JobEventType = 3
+
+	// JobFinished means, that the job was received completely.
+	JobFinished JobEventType = 4
+
+	// JobFailed means, that receiving the job failed (see JobEvent.Err).
+	JobFailed JobEventType = 5
+)
+
+// String returns the name of the event type.
+func (t JobEventType) String() string {
+	switch t {
+	case JobAccepted:
+		return "JobAccepted"
+	case ControlFileReceived:
+		return "ControlFileReceived"
+	case DataFileStarted:
+		return "DataFileStarted"
+	case DataFileProgress:
+		return "DataFileProgress"
+	case JobFinished:
+		return "JobFinished"
+	case JobFailed:
+		return "JobFailed"
+	default:
+		return "Unknown"
+	}
+}
+
+// JobEvent describes a change of the state of a job received by the LprDaemon.
+type JobEvent struct {
+	// Type is the type of the event
+	Type JobEventType
+
+	// Job is the job the event belongs to.
+	// The job is still processed by the daemon, so it may only be read within the OnJobEvent callback.
+	Job *LprConnection
+
+	// BytesReceived is the number of bytes of the data file received so far
+	BytesReceived uint64
+
+	// TotalBytes is the size of the data file announced by the client (0 if unknown)
+	TotalBytes uint64
+
+	// Err is the reason why the job failed (JobFailed only)
+	Err error
+}
+
+// JobEventFunc is called for every JobEvent.
+type JobEventFunc func(event JobEvent)
+
+// emit calls the OnJobEvent callback of the daemon (if set) with the given event.
+func (lpr *LprConnection) emit(eventType JobEventType, err error) {
+	lpr.emitFor(lpr, eventType, err)
+}
+
+// emitFor is like emit, but for the given job.
+func (lpr *LprConnection) emitFor(job *LprConnection, eventType JobEventType, err error) {
+	if lpr.daemon.OnJobEvent == nil {
+		return
+	}
+
+	lpr.daemon.OnJobEvent(JobEvent{
+		Type:          eventType,
+		Job:           job,
+		BytesReceived: job.processedDataBytes,
+		TotalBytes:    job.Filesize,
+		Err:           err,
+	})
+}
+
+// ProgressFunc is called with the number of bytes of the data file of job received so far
+// and the size announced by the client (0 if unknown).
+type ProgressFunc func(job *LprConnection, bytesReceived uint64, totalBytes uint64)
+
+// reportProgress calls OnProgress and emits a DataFileProgress event, if the ProgressInterval
+// elapsed since the last report or if the data file was received completely.
+func (lpr *LprConnection) reportProgress(end bool) {
+	if lpr.daemon.OnProgress == nil && lpr.daemon.OnJobEvent == nil {
+		return
+	}
+
+	now := time.Now()
+	if !end && lpr.daemon.ProgressInterval > 0 && now.Sub(lpr.lastProgress) < lpr.daemon.ProgressInterval {
+		return
+	}
+	lpr.lastProgress = now
+
+	if lpr.daemon.OnProgress != nil {
+		lpr.daemon.OnProgress(lpr, lpr.processedDataBytes, lpr.Filesize)
+	}
+
+	lpr.emit(DataFileProgress, nil)
+}
+
+// jobFinished is called for every completely received job.
+// It emits a JobFinished event and calls the OnMailRequest callback if the client requested a mail.
+func (lpr *LprConnection) jobFinished(job *LprConnection) {
+	lpr.emitFor(job, JobFinished, nil)
+
+	if job.MailUser != "" && lpr.daemon.OnMailRequest != nil {
+		logDebugf("Mail to %s requested", job.MailUser)
+		lpr.daemon.OnMailRequest(job, job.MailUser)
+	}
+}
@@ -0,0 +1,145 @@
+package lprlib
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemonJobEvents(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var mutex sync.Mutex
+	events := []JobEvent{}
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.OnJobEvent = func(event JobEvent) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		events = append(events, event)
+	}
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	types := []JobEventType{}
+	for _, event := range events {
+		require.Equal(t, conn, event.Job)
+		if len(types) == 0 || types[len(types)-1] != event.Type {
+			types = append(types, event.Type)
+		}
+	}
+	require.Equal(t, []JobEventType{JobAccepted, ControlFileReceived, DataFileStarted, DataFileProgress, JobFinished}, types)
+
+	last := events[len(events)-1]
+	require.Nil(t, last.Err)
+	require.Equal(t, uint64(len(text)), last.BytesReceived)
+	require.Equal(t, uint64(len(text)), last.TotalBytes)
+}
+
+func TestDaemonJobEventsFailed(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	eventChan := make(chan JobEvent, 100)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.MaxJobSize = 4
+	lprd.OnJobEvent = func(event JobEvent) {
+		eventChan <- event
+	}
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.NotNil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+
+	close(eventChan)
+	var last JobEvent
+	for event := range eventChan {
+		last = event
+	}
+	require.Equal(t, JobFailed, last.Type)
+	require.NotNil(t, last.Err)
+}
+
+func TestDaemonProgress(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := strings.Repeat("Text for the file\n", 100000)
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	for _, interval := range []time.Duration{0, time.Hour} {
+		var mutex sync.Mutex
+		received := []uint64{}
+
+		lprd := &LprDaemon{}
+		lprd.InputFileSaveDir = t.TempDir()
+		lprd.ProgressInterval = interval
+		lprd.OnProgress = func(job *LprConnection, bytesReceived uint64, totalBytes uint64) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			require.Equal(t, uint64(len(text)), totalBytes)
+			received = append(received, bytesReceived)
+		}
+		err = lprd.Init(port, "")
+		require.Nil(t, err)
+
+		err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+		require.Nil(t, err)
+
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+		require.Nil(t, os.Remove(conn.SaveName))
+		lprd.Close()
+
+		mutex.Lock()
+		if interval == 0 {
+			require.Greater(t, len(received), 1)
+			for i := 1; i < len(received); i++ {
+				require.Greater(t, received[i], received[i-1])
+			}
+		} else {
+			// only the first and the last block are reported
+			require.Len(t, received, 2)
+		}
+		require.Equal(t, uint64(len(text)), received[len(received)-1])
+		mutex.Unlock()
+	}
+}
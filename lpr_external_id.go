@@ -0,0 +1,89 @@
+package lprlib
+
+// queueExternalID prepares the external ID generation of the next job of the connection.
+// If the order of the GetExternalID calls has to be preserved, the job is queued behind the last arrived job.
+func (lpr *LprConnection) queueExternalID() {
+	lpr.externalIDChan = nil
+	lpr.externalIDTurn = nil
+	lpr.externalIDDone = nil
+
+	if !lpr.daemon.preserveExternalIDOrder() {
+		return
+	}
+
+	done := make(chan struct{})
+
+	lpr.daemon.externalIDMutex.Lock()
+	lpr.externalIDTurn = lpr.daemon.lastExternalIDTurn
+	lpr.daemon.lastExternalIDTurn = done
+	lpr.daemon.externalIDMutex.Unlock()
+
+	lpr.externalIDDone = done
+}
+
+// startExternalID generates the external ID of the received job in the background.
+func (lpr *LprConnection) startExternalID() {
+	if lpr.externalIDChan != nil {
+		return
+	}
+
+	idChan := make(chan uint64, 1)
+	lpr.externalIDChan = idChan
+
+	daemon, turn, done := lpr.daemon, lpr.externalIDTurn, lpr.externalIDDone
+	go func() {
+		idChan <- daemon.generateExternalID(turn, done)
+	}()
+}
+
+// externalID returns the external ID of the current job, or 0 if the connection did not receive a print job.
+func (lpr *LprConnection) externalID() uint64 {
+	if lpr.externalIDChan != nil {
+		return <-lpr.externalIDChan
+	}
+
+	// pass the turn on to the next job without waiting for the previous jobs
+	turn, done := lpr.externalIDTurn, lpr.externalIDDone
+	if done != nil {
+		if turn == nil {
+			close(done)
+		} else {
+			go func() {
+				<-turn
+				close(done)
+			}()
+		}
+	}
+
+	return 0
+}
+
+// generateExternalID calls GetExternalID once the previous job started its generation (turn)
+// and a slot is available (see ExternalIDConcurrency). done is closed once the call is started.
+func (lpr *LprDaemon) generateExternalID(turn <-chan struct{}, done chan struct{}) uint64 {
+	if turn != nil {
+		<-turn
+	}
+
+	if lpr.externalIDSlots != nil {
+		lpr.externalIDSlots <- struct{}{}
+		defer func() {
+			<-lpr.externalIDSlots
+		}()
+	}
+
+	if done != nil {
+		close(done)
+	}
+
+	if lpr.GetExternalID == nil {
+		return 0
+	}
+
+	return lpr.GetExternalID()
+}
+
+// preserveExternalIDOrder tells if the GetExternalID calls have to be started in the order the jobs arrived.
+func (lpr *LprDaemon) preserveExternalIDOrder() bool {
+	return lpr.PreserveExternalIDOrder || lpr.ExternalIDConcurrency <= 0
+}
@@ -0,0 +1,100 @@
+package lprlib
+
+// Format is the control file command describing how a data file should be printed (see RFC-1179, chapter 7).
+type Format byte
+
+// Formats of data files
+const (
+	// FormatCIF plots a CIF (Caltech Intermediate Form) file (c)
+	FormatCIF Format = 'c'
+
+	// FormatDVI prints a DVI (TeX output) file (d)
+	FormatDVI Format = 'd'
+
+	// FormatFormatted prints a plain text file, interpreting control characters (f)
+	FormatFormatted Format = 'f'
+
+	// FormatPlot plots a file produced by the Berkeley Unix plot library (g)
+	FormatPlot Format = 'g'
+
+	// FormatRaw prints the file leaving control characters, e.g. for PCL or other printer languages (l)
+	FormatRaw Format = 'l'
+
+	// FormatDitroff prints a ditroff output file (n)
+	FormatDitroff Format = 'n'
+
+	// FormatPostScript prints a PostScript file (o)
+	FormatPostScript Format = 'o'
+
+	// FormatPr prints a text file with a heading, page numbers and pagination like 'pr' (p)
+	FormatPr Format = 'p'
+
+	// FormatFortran prints a file with FORTRAN carriage control (r)
+	FormatFortran Format = 'r'
+
+	// FormatTroff prints a troff output file (t)
+	FormatTroff Format = 't'
+
+	// FormatRaster prints a Sun raster file (v)
+	FormatRaster Format = 'v'
+)
+
+// Valid tells if the format is one of the print commands defined by RFC-1179.
+func (f Format) Valid() bool {
+	return isPrintCommand(byte(f))
+}
+
+// String returns the name of the format.
+func (f Format) String() string {
+	switch f {
+	case FormatCIF:
+		return "CIF"
+	case FormatDVI:
+		return "DVI"
+	case FormatFormatted:
+		return "formatted"
+	case FormatPlot:
+		return "plot"
+	case FormatRaw:
+		return "raw"
+	case FormatDitroff:
+		return "ditroff"
+	case FormatPostScript:
+		return "PostScript"
+	case FormatPr:
+		return "pr"
+	case FormatFortran:
+		return "FORTRAN"
+	case FormatTroff:
+		return "troff"
+	case FormatRaster:
+		return "raster"
+	default:
+		return "unknown (" + string(rune(f)) + ")"
+	}
+}
+
+// SetFormat sets the format the data file is printed with, replacing the print command of the Config
+// (per default FormatRaw). Data files queued by AddFile and AddReader are printed with the same format.
+func (lpr *LprSend) SetFormat(format Format) error {
+	if !format.Valid() {
+		return &LprError{"Invalid format " + format.String()}
+	}
+
+	lpr.DefaultFormat = format
+
+	for key, value := range lpr.printCommands() {
+		delete(lpr.Config, key)
+		lpr.Config[byte(format)] = value
+	}
+
+	return nil
+}
+
+// defaultFormat returns the DefaultFormat or FormatRaw if it isn't set.
+func (lpr *LprSend) defaultFormat() Format {
+	if lpr.DefaultFormat == 0 {
+		return FormatRaw
+	}
+	return lpr.DefaultFormat
+}
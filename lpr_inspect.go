@@ -0,0 +1,66 @@
+package lprlib
+
+import "github.com/documatrix/go-lprlib/pjl"
+
+// headSize returns the number of bytes at the start of the data file which are inspected
+// after the data file was received, 0 if it isn't inspected.
+func (lpr *LprConnection) headSize() int {
+	if lpr.daemon.ParsePJL || lpr.daemon.DetectFormat || lpr.daemon.ParseDSC {
+		return pjl.MaxHeaderSize
+	}
+
+	return 0
+}
+
+// startInspection prepares inspecting the data file which is received next.
+func (lpr *LprConnection) startInspection() {
+	lpr.head = lpr.head[:0]
+
+	lpr.dsc = nil
+	if lpr.daemon.ParseDSC {
+		lpr.dsc = newDSCScanner()
+	}
+}
+
+// inspect inspects the given part of the data file while it is received.
+func (lpr *LprConnection) inspect(data []byte) {
+	if lpr.dsc != nil {
+		lpr.dsc.Write(data)
+	}
+
+	missing := lpr.headSize() - len(lpr.head)
+	if missing <= 0 {
+		return
+	}
+
+	if len(data) > missing {
+		data = data[:missing]
+	}
+	lpr.head = append(lpr.head, data...)
+}
+
+// inspectDataFile extracts the information of the received data file,
+// e.g. the PJL job header if LprDaemon.ParsePJL is set.
+func (lpr *LprConnection) inspectDataFile() {
+	if lpr.daemon.ParsePJL {
+		lpr.parsePJL()
+	}
+
+	format := DocumentFormatUnknown
+	if lpr.daemon.DetectFormat || lpr.dsc != nil {
+		format = DetectDocumentFormat(lpr.head)
+	}
+
+	if lpr.daemon.DetectFormat {
+		lpr.DetectedFormat = format
+		logDebugf("Detected format of data file: %s", lpr.DetectedFormat)
+	}
+
+	if lpr.dsc != nil {
+		if format == DocumentFormatPostScript {
+			lpr.DSC = lpr.dsc.finish()
+			logDebugf("DSC comments of data file: title %q, creator %q, %d pages", lpr.DSC.Title, lpr.DSC.Creator, lpr.DSC.Pages)
+		}
+		lpr.dsc = nil
+	}
+}
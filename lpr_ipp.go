@@ -0,0 +1,471 @@
+package lprlib
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultIppPort is the port of the Internet Printing Protocol (used for ipp and ipps URIs).
+const DefaultIppPort = 631
+
+// DefaultIppDocumentFormat is the document format of jobs sent by an IppClient, if DocumentFormat is not set.
+// It lets the printer detect the format of the data.
+const DefaultIppDocumentFormat = "application/octet-stream"
+
+// IPP operations (see RFC 8011, chapter 5.4.15)
+const (
+	ippOperationPrintJob             uint16 = 0x0002
+	ippOperationValidateJob          uint16 = 0x0004
+	ippOperationGetPrinterAttributes uint16 = 0x000B
+)
+
+// IPP delimiter and value tags (see RFC 8010, chapter 3.5)
+const (
+	ippTagOperationAttributes byte = 0x01
+	ippTagJobAttributes       byte = 0x02
+	ippTagEnd                 byte = 0x03
+	ippTagPrinterAttributes   byte = 0x04
+
+	ippTagInteger         byte = 0x21
+	ippTagBoolean         byte = 0x22
+	ippTagEnum            byte = 0x23
+	ippTagBeginCollection byte = 0x34
+	ippTagEndCollection   byte = 0x37
+	ippTagText            byte = 0x41
+	ippTagName            byte = 0x42
+	ippTagKeyword         byte = 0x44
+	ippTagURI             byte = 0x45
+	ippTagCharset         byte = 0x47
+	ippTagNaturalLanguage byte = 0x48
+	ippTagMimeMediaType   byte = 0x49
+	ippTagMemberAttrName  byte = 0x4A
+)
+
+// IppStatusError is returned if the printer answers an IPP request with an error status,
+// e.g. 0x0400 (client-error-bad-request) or 0x0507 (server-error-busy).
+type IppStatusError struct {
+	// Operation is the name of the refused operation
+	Operation string
+
+	// Status is the status code sent by the printer
+	Status uint16
+
+	// Message is the status message sent by the printer (may be empty)
+	Message string
+}
+
+func (e *IppStatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("PRINTER_ERROR Printer reported an error (0x%04x) for %s!", e.Status, e.Operation)
+	}
+	return fmt.Sprintf("PRINTER_ERROR Printer reported an error (0x%04x) for %s: %s", e.Status, e.Operation, e.Message)
+}
+
+// Temporary tells if the printer is busy or temporarily unable to accept jobs.
+func (e *IppStatusError) Temporary() bool {
+	switch e.Status {
+	case 0x0502, 0x0506, 0x0507: // service-unavailable, not-accepting-jobs, busy
+		return true
+	}
+	return false
+}
+
+// IppClient sends jobs to a printer using the Internet Printing Protocol (IPP/1.1, RFC 8011),
+// e.g. for printers which disabled LPD.
+type IppClient struct {
+	// PrinterURI is the URI of the printer, e.g. ipp://printer/ipp/print.
+	// ipps URIs are sent using TLS. The port is per default 631.
+	PrinterURI string
+
+	// Username is sent as requesting-user-name.
+	Username string
+
+	// DocumentFormat is the MIME type of the data sent by Print, e.g. application/pdf.
+	// If empty, DefaultIppDocumentFormat is used.
+	DocumentFormat string
+
+	// Timeout limits each request including the transfer of the data.
+	// If 0, the requests are not limited.
+	Timeout time.Duration
+
+	// TLSConfig is used for ipps URIs, e.g. to set custom root CAs.
+	TLSConfig *tls.Config
+
+	// DialContext replaces the dialer used to connect to the printer if set (see LprSend.DialContext).
+	DialContext func(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+// lastIppRequestID counts the IPP requests of this process.
+var lastIppRequestID uint32
+
+// Print sends the data read from the reader as a new job (Print-Job) with the given name to the printer
+// and returns the job id assigned by the printer. If copies is less than 2, one copy is printed.
+func (c *IppClient) Print(reader io.Reader, jobName string, copies int) (int, error) {
+	format := c.DocumentFormat
+	if format == "" {
+		format = DefaultIppDocumentFormat
+	}
+
+	request, err := c.newRequest(ippOperationPrintJob)
+	if err != nil {
+		return 0, err
+	}
+	if c.Username != "" {
+		request.addString(ippTagName, "requesting-user-name", c.Username)
+	}
+	if jobName != "" {
+		request.addString(ippTagName, "job-name", jobName)
+	}
+	request.addString(ippTagMimeMediaType, "document-format", format)
+
+	if copies > 1 {
+		request.buffer.WriteByte(ippTagJobAttributes)
+		request.addInteger(ippTagInteger, "copies", int32(copies))
+	}
+
+	attributes, err := c.do("Print-Job", request, reader)
+	if err != nil {
+		return 0, err
+	}
+
+	jobID := 0
+	if values := attributes["job-id"]; len(values) > 0 {
+		jobID, _ = strconv.Atoi(values[0])
+	}
+	logDebugf("Printer %s accepted job %d", c.PrinterURI, jobID)
+
+	return jobID, nil
+}
+
+// GetPrinterAttributes requests the attributes with the given names (e.g. printer-state or
+// document-format-supported) from the printer, or all attributes if no names are given.
+// Integers, enums and booleans are returned in decimal notation ("true" or "false"),
+// the members of collections are not decoded.
+func (c *IppClient) GetPrinterAttributes(names ...string) (map[string][]string, error) {
+	request, err := c.newRequest(ippOperationGetPrinterAttributes)
+	if err != nil {
+		return nil, err
+	}
+	if c.Username != "" {
+		request.addString(ippTagName, "requesting-user-name", c.Username)
+	}
+	if len(names) > 0 {
+		request.addString(ippTagKeyword, "requested-attributes", names...)
+	}
+
+	return c.do("Get-Printer-Attributes", request, nil)
+}
+
+// newRequest starts an IPP request with the given operation and the required operation attributes.
+func (c *IppClient) newRequest(operation uint16) (*ippMessage, error) {
+	if c.PrinterURI == "" {
+		return nil, &LprError{"No printer URI given"}
+	}
+
+	request := newIppMessage(operation, atomic.AddUint32(&lastIppRequestID, 1))
+	request.addString(ippTagURI, "printer-uri", c.PrinterURI)
+
+	return request, nil
+}
+
+// do posts the request (followed by the data, if not nil) to the printer
+// and returns the attributes of the response.
+func (c *IppClient) do(operation string, request *ippMessage, data io.Reader) (map[string][]string, error) {
+	address, err := ippHTTPURL(c.PrinterURI)
+	if err != nil {
+		return nil, err
+	}
+
+	request.buffer.WriteByte(ippTagEnd)
+
+	var body io.Reader = &request.buffer
+	if data != nil {
+		body = io.MultiReader(&request.buffer, data)
+	}
+
+	httpRequest, err := http.NewRequest(http.MethodPost, address, body)
+	if err != nil {
+		return nil, &LprError{"Invalid IPP request: " + err.Error()}
+	}
+	httpRequest.Header.Set("Content-Type", "application/ipp")
+
+	transport := &http.Transport{
+		TLSClientConfig: c.TLSConfig,
+		DialContext:     c.DialContext,
+	}
+	defer transport.CloseIdleConnections()
+	client := http.Client{Transport: transport, Timeout: c.Timeout}
+
+	logDebugf("Sending IPP request %s to %s", operation, address)
+	response, err := client.Do(httpRequest)
+	if err != nil {
+		return nil, &LprError{"Can't send IPP request to printer: " + err.Error()}
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, &LprError{fmt.Sprintf("PRINTER_ERROR Printer answered %s with HTTP status %s", operation, response.Status)}
+	}
+
+	responseData, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, &LprError{"Error while reading IPP response: " + err.Error()}
+	}
+
+	status, _, attributes, _, err := parseIppMessage(responseData)
+	if err != nil {
+		return nil, &LprError{"Invalid IPP response: " + err.Error()}
+	}
+
+	// status codes below 0x0100 are successful
+	if status >= 0x0100 {
+		message := ""
+		if values := attributes["status-message"]; len(values) > 0 {
+			message = values[0]
+		}
+		return nil, &IppStatusError{Operation: operation, Status: status, Message: message}
+	}
+
+	return attributes, nil
+}
+
+// ippHTTPURL returns the HTTP URL requests for the given printer URI are posted to.
+func ippHTTPURL(printerURI string) (string, error) {
+	uri, err := url.Parse(printerURI)
+	if err != nil {
+		return "", &LprError{"Invalid printer URI: " + err.Error()}
+	}
+
+	switch uri.Scheme {
+	case "ipp", "http":
+		uri.Scheme = "http"
+	case "ipps", "https":
+		uri.Scheme = "https"
+	default:
+		return "", &LprError{fmt.Sprintf("Invalid printer URI %q: unsupported scheme", printerURI)}
+	}
+
+	if uri.Port() == "" {
+		uri.Host = net.JoinHostPort(uri.Hostname(), strconv.Itoa(DefaultIppPort))
+	}
+
+	return uri.String(), nil
+}
+
+// ippMessage builds an encoded IPP request or response (see RFC 8010, chapter 3).
+type ippMessage struct {
+	buffer bytes.Buffer
+}
+
+// newIppMessage starts an IPP request or response with the given operation or status code,
+// followed by the operation attributes group with the charset and natural language.
+func newIppMessage(code uint16, requestID uint32) *ippMessage {
+	message := &ippMessage{}
+	message.buffer.Write([]byte{1, 1})
+	binary.Write(&message.buffer, binary.BigEndian, code)
+	binary.Write(&message.buffer, binary.BigEndian, requestID)
+
+	message.buffer.WriteByte(ippTagOperationAttributes)
+	message.addString(ippTagCharset, "attributes-charset", "utf-8")
+	message.addString(ippTagNaturalLanguage, "attributes-natural-language", "en")
+
+	return message
+}
+
+// addValue adds an attribute with the given values of the given tag to the current group.
+func (m *ippMessage) addValue(tag byte, name string, values ...[]byte) {
+	for i, value := range values {
+		m.buffer.WriteByte(tag)
+		if i == 0 {
+			binary.Write(&m.buffer, binary.BigEndian, uint16(len(name)))
+			m.buffer.WriteString(name)
+		} else {
+			// additional values have no name
+			binary.Write(&m.buffer, binary.BigEndian, uint16(0))
+		}
+		binary.Write(&m.buffer, binary.BigEndian, uint16(len(value)))
+		m.buffer.Write(value)
+	}
+}
+
+// addString adds an attribute with the given string values.
+func (m *ippMessage) addString(tag byte, name string, values ...string) {
+	encoded := make([][]byte, len(values))
+	for i, value := range values {
+		encoded[i] = []byte(value)
+	}
+	m.addValue(tag, name, encoded...)
+}
+
+// addInteger adds an integer or enum attribute.
+func (m *ippMessage) addInteger(tag byte, name string, value int32) {
+	encoded := make([]byte, 4)
+	binary.BigEndian.PutUint32(encoded, uint32(value))
+	m.addValue(tag, name, encoded)
+}
+
+// parseIppMessage parses an encoded IPP request or response (see readIppMessage)
+// and returns the data following the attributes as well.
+func parseIppMessage(data []byte) (code uint16, requestID uint32, attributes map[string][]string, rest []byte, err error) {
+	reader := bytes.NewReader(data)
+
+	code, requestID, attributes, err = readIppMessage(reader)
+	if err != nil {
+		return 0, 0, nil, nil, err
+	}
+
+	return code, requestID, attributes, data[len(data)-reader.Len():], nil
+}
+
+// readIppMessage reads the header and the attributes of an encoded IPP request or response and returns
+// its operation or status code, its request id and the attributes of all groups.
+// The reader is left at the data following the attributes (e.g. the document of a Print-Job request).
+// If the attributes are invalid, the code and request id of the header are returned with the error.
+func readIppMessage(reader io.Reader) (code uint16, requestID uint32, attributes map[string][]string, err error) {
+	header := make([]byte, 8)
+	_, err = io.ReadFull(reader, header)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("error reading header: %w", err)
+	}
+	if header[0] < 1 {
+		return 0, 0, nil, fmt.Errorf("unsupported version %d.%d", header[0], header[1])
+	}
+
+	code = binary.BigEndian.Uint16(header[2:4])
+	requestID = binary.BigEndian.Uint32(header[4:8])
+	attributes = make(map[string][]string)
+
+	tag := make([]byte, 1)
+	name := ""
+	for {
+		_, err = io.ReadFull(reader, tag)
+		if err != nil {
+			return code, requestID, nil, fmt.Errorf("missing end of attributes: %w", err)
+		}
+
+		if tag[0] == ippTagEnd {
+			return code, requestID, attributes, nil
+		}
+		if tag[0] < 0x10 {
+			// begin of an attribute group
+			continue
+		}
+
+		attributeName, err := readIppField(reader)
+		if err != nil {
+			return code, requestID, nil, fmt.Errorf("truncated attribute name: %w", err)
+		}
+		if len(attributeName) > 0 {
+			name = string(attributeName)
+		}
+
+		value, err := readIppField(reader)
+		if err != nil {
+			return code, requestID, nil, fmt.Errorf("truncated value of attribute %s: %w", name, err)
+		}
+
+		switch tag[0] {
+		case ippTagBeginCollection, ippTagEndCollection, ippTagMemberAttrName:
+			continue
+		}
+		attributes[name] = append(attributes[name], formatIppValue(tag[0], value))
+	}
+}
+
+// readIppField reads a field with a two bytes length prefix (e.g. the name or value of an attribute).
+func readIppField(reader io.Reader) ([]byte, error) {
+	length := make([]byte, 2)
+	_, err := io.ReadFull(reader, length)
+	if err != nil {
+		return nil, err
+	}
+
+	field := make([]byte, binary.BigEndian.Uint16(length))
+	_, err = io.ReadFull(reader, field)
+	if err != nil {
+		return nil, err
+	}
+
+	return field, nil
+}
+
+// formatIppValue returns the string representation of an attribute value with the given tag.
+func formatIppValue(tag byte, value []byte) string {
+	switch tag {
+	case ippTagInteger, ippTagEnum:
+		if len(value) == 4 {
+			return strconv.Itoa(int(int32(binary.BigEndian.Uint32(value))))
+		}
+	case ippTagBoolean:
+		if len(value) == 1 {
+			return strconv.FormatBool(value[0] != 0)
+		}
+	}
+
+	return string(value)
+}
+
+// ippFallbackURI returns the printer URI the job is sent to by IPP, if the connection
+// to the LPD port failed and IppFallback is set, otherwise "".
+func (lpr *LprSend) ippFallbackURI(err error) string {
+	if err == nil || lpr.connected || !lpr.IppFallback {
+		return ""
+	}
+
+	if lpr.IppFallbackURI != "" {
+		return lpr.IppFallbackURI
+	}
+
+	return "ipp://" + net.JoinHostPort(lpr.hostname, strconv.Itoa(DefaultIppPort)) + "/ipp/print"
+}
+
+// sendIpp sends the job to the printer with the given URI using an IppClient,
+// configured by the Config (user, job name and copies) and the connection settings of the LprSend.
+func (lpr *LprSend) sendIpp(printerURI string, reader io.Reader, lprErr error) error {
+	logErrorf("Can't connect to the LPD port of printer %s, falling back to IPP (%s): %v", lpr.hostname, printerURI, lprErr)
+
+	client := IppClient{
+		PrinterURI:  printerURI,
+		Username:    lpr.Config['P'],
+		Timeout:     lpr.Timeout,
+		TLSConfig:   lpr.TLSConfig,
+		DialContext: lpr.DialContext,
+	}
+	if _, ok := lpr.Config[byte(FormatPostScript)]; ok {
+		client.DocumentFormat = "application/postscript"
+	}
+
+	jobName := lpr.Config['J']
+	if jobName == "" {
+		jobName = lpr.Config['N']
+	}
+
+	_, err := client.Print(reader, jobName, lpr.Copies)
+	if err != nil {
+		return fmt.Errorf("Error sending job to IPP printer %s! %w", printerURI, err)
+	}
+
+	return nil
+}
+
+// sendIppFile sends the given file like sendIpp.
+func (lpr *LprSend) sendIppFile(printerURI string, file string, lprErr error) error {
+	reader, err := os.Open(file)
+	if err != nil {
+		return &LprError{fmt.Sprintf("Can't open file %s: %s", file, err)}
+	}
+	defer reader.Close()
+
+	return lpr.sendIpp(printerURI, reader, lprErr)
+}
@@ -0,0 +1,306 @@
+package lprlib
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// IPP status codes sent by the daemon (see RFC 8011, chapter 5.4.15)
+const (
+	ippStatusOK                    uint16 = 0x0000
+	ippStatusNotFound              uint16 = 0x0406
+	ippStatusRequestTooLarge       uint16 = 0x0409
+	ippStatusInternalError         uint16 = 0x0500
+	ippStatusOperationNotSupported uint16 = 0x0501
+	ippStatusNotAcceptingJobs      uint16 = 0x0506
+	ippStatusBusy                  uint16 = 0x0507
+)
+
+// IPP job and printer states (see RFC 8011, chapter 5.3.7 and 5.4.11)
+const (
+	ippJobStatePending  int32 = 3
+	ippPrinterStateIdle int32 = 3
+)
+
+// lastIppJobID counts the jobs received by the IPP listeners of this process.
+var lastIppJobID uint32
+
+// ippStartTime is the time the printer-up-time of the IPP listeners is counted from.
+var ippStartTime = time.Now()
+
+// InitIpp starts an IPP listener (see ServeIppListener) on the given tcp port, which is per default 631.
+// The daemon must have been initialized before (e.g. by Init).
+func (lpr *LprDaemon) InitIpp(port uint16, ipAddress string) error {
+	if port == 0 {
+		port = DefaultIppPort
+	}
+
+	listenAddr := net.JoinHostPort(ipAddress, strconv.Itoa(int(port)))
+	logDebugf("Listening for IPP requests on: %s", listenAddr)
+
+	socket, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return &LprError{"Can't listen to " + listenAddr + " : " + err.Error()}
+	}
+
+	return lpr.ServeIppListener(socket)
+}
+
+// ServeIppListener accepts IPP/1.1 requests (RFC 8011) from the given listener in addition to the LPR
+// connections of the daemon, so clients without LPR support can send jobs as well.
+// The jobs of Print-Job requests are processed like jobs received over LPR (e.g. saved into the
+// InputFileSaveDir and delivered by FinishedConnections). The queue is taken from the path of the
+// printer URI (e.g. ipp://host/printers/<queue> or ipp://host/ipp/<queue>), the user and job name
+// from the requesting-user-name and job-name attributes. Get-Printer-Attributes and Validate-Job
+// are answered with the basic attributes clients require, other operations are not supported.
+// The daemon must have been initialized before (e.g. by Init), the listener will be closed by Close.
+func (lpr *LprDaemon) ServeIppListener(listener net.Listener) error {
+	return lpr.serveAdditionalListener(listener, protocolIpp)
+}
+
+// ippQueue returns the queue name of the given path of a printer URI.
+func ippQueue(path string) string {
+	path = strings.Trim(path, "/")
+	for _, prefix := range []string{"printers/", "ipp/"} {
+		if strings.HasPrefix(path, prefix) {
+			return path[len(prefix):]
+		}
+	}
+
+	return path
+}
+
+// serveIpp answers the HTTP requests of a connection accepted by an IPP listener
+// until the client closes the connection.
+func (lpr *LprConnection) serveIpp() error {
+	reader := bufio.NewReader(connectionReader{lpr})
+
+	for {
+		if reader.Buffered() == 0 {
+			err := lpr.setReadTimeout(lpr.daemon.idleTimeout)
+			if err != nil {
+				return err
+			}
+
+			_, err = lpr.reader.Peek(1)
+			if errors.Is(err, io.EOF) {
+				logDebug("IPP connection was closed by the client")
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error reading from IPP connection: %w", err)
+			}
+		}
+
+		request, err := http.ReadRequest(reader)
+		if err != nil {
+			return fmt.Errorf("error reading HTTP request: %w", err)
+		}
+
+		keepAlive, err := lpr.handleIppRequest(request)
+		if err != nil || !keepAlive {
+			return err
+		}
+	}
+}
+
+// handleIppRequest answers the given HTTP request and tells if the connection may be kept open.
+func (lpr *LprConnection) handleIppRequest(request *http.Request) (bool, error) {
+	if request.Method != http.MethodPost || request.Header.Get("Content-Type") != "application/ipp" {
+		logErrorf("Rejecting %s request for %s: no IPP request", request.Method, request.URL)
+		return false, lpr.writeHTTPResponse(request, http.StatusBadRequest, "text/plain", []byte("IPP requests only\n"))
+	}
+
+	if strings.EqualFold(request.Header.Get("Expect"), "100-continue") {
+		_, err := io.WriteString(lpr.Connection, "HTTP/1.1 100 Continue\r\n\r\n")
+		if err != nil {
+			return false, fmt.Errorf("error writing HTTP response: %w", err)
+		}
+	}
+
+	// the attributes are limited like the command lines of LPR connections
+	maxSize := lpr.daemon.maxCommandSize()
+	header := &io.LimitedReader{R: request.Body, N: int64(maxSize)}
+	operation, requestID, attributes, err := readIppMessage(header)
+	if err != nil && header.N == 0 {
+		response := newIppMessage(ippStatusRequestTooLarge, requestID)
+		response.buffer.WriteByte(ippTagEnd)
+		lpr.writeHTTPResponse(request, http.StatusOK, "application/ipp", response.buffer.Bytes())
+		return false, fmt.Errorf("attributes of IPP request exceed %d bytes", maxSize)
+	}
+	if err != nil {
+		lpr.writeHTTPResponse(request, http.StatusBadRequest, "text/plain", []byte("Invalid IPP request\n"))
+		return false, fmt.Errorf("invalid IPP request: %w", err)
+	}
+	logDebugf("Received IPP operation 0x%04x for %s", operation, request.URL.Path)
+
+	var status uint16
+	var response *ippMessage
+	var jobErr error
+	switch operation {
+	case ippOperationPrintJob:
+		status, jobErr = lpr.receiveIppJob(request, attributes)
+		response = newIppMessage(status, requestID)
+		if jobErr == nil {
+			jobID, _ := strconv.Atoi(lpr.JobNumber)
+			response.buffer.WriteByte(ippTagJobAttributes)
+			response.addString(ippTagURI, "job-uri", fmt.Sprintf("%s/%d", ippPrinterURI(request), jobID))
+			response.addInteger(ippTagInteger, "job-id", int32(jobID))
+			response.addInteger(ippTagEnum, "job-state", ippJobStatePending)
+			response.addString(ippTagKeyword, "job-state-reasons", "none")
+		}
+
+	case ippOperationGetPrinterAttributes, ippOperationValidateJob:
+		if !lpr.receivingJob {
+			lpr.setConnectionType(ConnectionTypeIppPrinterAttributes)
+		}
+		response = newIppMessage(ippStatusOK, requestID)
+		if operation == ippOperationGetPrinterAttributes {
+			lpr.addIppPrinterAttributes(request, response)
+		}
+
+	default:
+		response = newIppMessage(ippStatusOperationNotSupported, requestID)
+	}
+
+	if jobErr != nil {
+		response.addString(ippTagText, "status-message", jobErr.Error())
+	}
+	response.buffer.WriteByte(ippTagEnd)
+
+	err = lpr.writeHTTPResponse(request, http.StatusOK, "application/ipp", response.buffer.Bytes())
+	if jobErr != nil {
+		return false, jobErr
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// the rest of the request (e.g. the document of an unsupported operation) has to be skipped
+	_, err = io.Copy(io.Discard, request.Body)
+	if err != nil {
+		return false, fmt.Errorf("error reading HTTP request: %w", err)
+	}
+
+	return !request.Close, nil
+}
+
+// receiveIppJob receives the job of a Print-Job request and returns the IPP status of the request.
+func (lpr *LprConnection) receiveIppJob(request *http.Request, attributes map[string][]string) (uint16, error) {
+	if lpr.receivingJob {
+		// the job received by a previous request is complete
+		lpr.startNextJob()
+	}
+
+	lpr.setConnectionType(ConnectionTypeIppPrintJob)
+	lpr.PrqName = ippQueue(request.URL.Path)
+	if values := attributes["requesting-user-name"]; len(values) > 0 {
+		lpr.UserIdentification = values[0]
+	}
+	if values := attributes["job-name"]; len(values) > 0 {
+		lpr.JobName = values[0]
+	}
+	lpr.JobNumber = strconv.Itoa(int(atomic.AddUint32(&lastIppJobID, 1)))
+
+	err := lpr.checkQueue()
+	if err != nil {
+		return ippStatusNotFound, err
+	}
+
+	if lpr.daemon.OnReceiveJob != nil {
+		err = lpr.daemon.OnReceiveJob(lpr.Connection.RemoteAddr(), lpr.PrqName)
+		if err != nil {
+			return ippStatusNotAcceptingJobs, fmt.Errorf("IPP print job for queue %s from %s rejected: %w", lpr.PrqName, lpr.Connection.RemoteAddr(), err)
+		}
+	}
+
+	lpr.receivingJob = true
+	lpr.emit(JobAccepted, nil)
+
+	err = lpr.checkDiskSpace(0)
+	if err != nil {
+		return ippStatusBusy, err
+	}
+
+	err = lpr.receiveStreamData(request.Body)
+	if err != nil {
+		if nackCodeOf(err, NackFailure) == NackRejected {
+			return ippStatusRequestTooLarge, fmt.Errorf("error receiving IPP data: %w", err)
+		}
+		return ippStatusInternalError, fmt.Errorf("error receiving IPP data: %w", err)
+	}
+
+	lpr.dataFileReceived = true
+
+	return ippStatusOK, nil
+}
+
+// ippPrinterURI returns the URI of the printer the given request was sent to.
+func ippPrinterURI(request *http.Request) string {
+	return "ipp://" + request.Host + request.URL.Path
+}
+
+// addIppPrinterAttributes adds the printer attributes required by IPP/1.1 clients to the response.
+func (lpr *LprConnection) addIppPrinterAttributes(request *http.Request, response *ippMessage) {
+	queue := ippQueue(request.URL.Path)
+	accepting := []byte{1}
+	if len(lpr.daemon.Queues) > 0 && !containsString(lpr.daemon.Queues, queue) {
+		accepting = []byte{0}
+	}
+
+	operations := make([][]byte, 0, 3)
+	for _, operation := range []uint16{ippOperationPrintJob, ippOperationValidateJob, ippOperationGetPrinterAttributes} {
+		operations = append(operations, []byte{0, 0, byte(operation >> 8), byte(operation)})
+	}
+
+	response.buffer.WriteByte(ippTagPrinterAttributes)
+	response.addString(ippTagURI, "printer-uri-supported", ippPrinterURI(request))
+	response.addString(ippTagKeyword, "uri-security-supported", "none")
+	response.addString(ippTagKeyword, "uri-authentication-supported", "none")
+	response.addString(ippTagName, "printer-name", queue)
+	response.addInteger(ippTagEnum, "printer-state", ippPrinterStateIdle)
+	response.addString(ippTagKeyword, "printer-state-reasons", "none")
+	response.addValue(ippTagBoolean, "printer-is-accepting-jobs", accepting)
+	response.addString(ippTagKeyword, "ipp-versions-supported", "1.0", "1.1")
+	response.addValue(ippTagEnum, "operations-supported", operations...)
+	response.addString(ippTagCharset, "charset-configured", "utf-8")
+	response.addString(ippTagCharset, "charset-supported", "utf-8")
+	response.addString(ippTagNaturalLanguage, "natural-language-configured", "en")
+	response.addString(ippTagNaturalLanguage, "generated-natural-language-supported", "en")
+	response.addString(ippTagMimeMediaType, "document-format-default", DefaultIppDocumentFormat)
+	response.addString(ippTagMimeMediaType, "document-format-supported", DefaultIppDocumentFormat)
+	response.addString(ippTagKeyword, "pdl-override-supported", "not-attempted")
+	response.addString(ippTagKeyword, "compression-supported", "none")
+	response.addInteger(ippTagInteger, "queued-job-count", 0)
+	response.addInteger(ippTagInteger, "printer-up-time", int32(time.Since(ippStartTime).Seconds())+1)
+}
+
+// writeHTTPResponse writes an HTTP response with the given status and body to the connection.
+func (lpr *LprConnection) writeHTTPResponse(request *http.Request, statusCode int, contentType string, body []byte) error {
+	response := http.Response{
+		StatusCode:    statusCode,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       request,
+		Header:        http.Header{"Content-Type": []string{contentType}},
+		ContentLength: int64(len(body)),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		Close:         request.Close || statusCode != http.StatusOK,
+	}
+
+	err := response.Write(lpr.Connection)
+	if err != nil {
+		return fmt.Errorf("error writing HTTP response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package lprlib
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemonIpp(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+	ippPort := uint16(2349)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.Queues = []string{"office"}
+	lprd.MaxCommandSize = 1024
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	err = lprd.InitIpp(ippPort, "")
+	require.Nil(t, err)
+
+	client := IppClient{PrinterURI: "ipp://127.0.0.1:2349/printers/office", Username: "TestUser", Timeout: time.Minute}
+
+	attributes, err := client.GetPrinterAttributes()
+	require.Nil(t, err)
+	require.Equal(t, []string{"office"}, attributes["printer-name"])
+	require.Equal(t, []string{"true"}, attributes["printer-is-accepting-jobs"])
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "IppPrinterAttributes", conn.connectionType.String())
+
+	jobID, err := client.Print(strings.NewReader("Text for the file"), "TestJob", 1)
+	require.Nil(t, err)
+	require.Greater(t, jobID, 0)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "office", conn.PrqName)
+	require.Equal(t, "TestUser", conn.UserIdentification)
+	require.Equal(t, "TestJob", conn.JobName)
+
+	data, err := os.ReadFile(conn.SaveName)
+	require.Nil(t, err)
+	require.Equal(t, "Text for the file", string(data))
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// unknown queue
+	client.PrinterURI = "ipp://127.0.0.1:2349/printers/unknown"
+	_, err = client.Print(strings.NewReader("Text for the file"), "TestJob", 1)
+	var statusErr *IppStatusError
+	require.True(t, errors.As(err, &statusErr))
+	require.Equal(t, uint16(0x0406), statusErr.Status)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+
+	// the attributes are limited by the MaxCommandSize
+	client.PrinterURI = "ipp://127.0.0.1:2349/printers/office"
+	_, err = client.Print(strings.NewReader("Text for the file"), strings.Repeat("TestJob", 200), 1)
+	require.True(t, errors.As(err, &statusErr))
+	require.Equal(t, uint16(0x0409), statusErr.Status)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+
+	// jobs sent with the IPP fallback end up in the same pipeline
+	name, err := generateTempFile("", "", "Text of the fallback")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	err = Send(name, "127.0.0.1", 2350, "office", "TestUser", time.Minute, WithIppFallback("ipp://127.0.0.1:2349/ipp/office"))
+	require.Nil(t, err)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "office", conn.PrqName)
+	data, err = os.ReadFile(conn.SaveName)
+	require.Nil(t, err)
+	require.Equal(t, "Text of the fallback", string(data))
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	lprd.Close()
+
+	_, ok := <-lprd.FinishedConnections()
+	require.False(t, ok)
+}
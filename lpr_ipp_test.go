@@ -0,0 +1,135 @@
+package lprlib
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// ippTestRequest is a request received by the test IPP printer.
+type ippTestRequest struct {
+	operation  uint16
+	attributes map[string][]string
+	data       string
+}
+
+// startTestIppPrinter starts an IPP printer answering every request with the given status
+// and returns its URI and the received requests.
+func startTestIppPrinter(t *testing.T, status uint16) (string, chan ippTestRequest) {
+	requests := make(chan ippTestRequest, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil || r.Header.Get("Content-Type") != "application/ipp" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		operation, requestID, attributes, data, err := parseIppMessage(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests <- ippTestRequest{operation: operation, attributes: attributes, data: string(data)}
+
+		response := newIppMessage(status, requestID)
+		response.buffer.WriteByte(ippTagJobAttributes)
+		response.addInteger(ippTagInteger, "job-id", 42)
+		response.buffer.WriteByte(ippTagPrinterAttributes)
+		response.addInteger(ippTagEnum, "printer-state", 3)
+		response.addValue(ippTagBoolean, "printer-is-accepting-jobs", []byte{1})
+		response.addString(ippTagKeyword, "document-format-supported", "application/pdf", "application/postscript")
+		response.buffer.WriteByte(ippTagEnd)
+
+		w.Header().Set("Content-Type", "application/ipp")
+		w.Write(response.buffer.Bytes())
+	}))
+	t.Cleanup(server.Close)
+
+	return "ipp://" + strings.TrimPrefix(server.URL, "http://") + "/ipp/print", requests
+}
+
+func TestIppClient(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	uri, requests := startTestIppPrinter(t, 0)
+
+	client := IppClient{PrinterURI: uri, Username: "TestUser", Timeout: time.Minute}
+
+	jobID, err := client.Print(strings.NewReader("Text for the file"), "TestJob", 2)
+	require.Nil(t, err)
+	require.Equal(t, 42, jobID)
+
+	request := <-requests
+	require.Equal(t, ippOperationPrintJob, request.operation)
+	require.Equal(t, []string{uri}, request.attributes["printer-uri"])
+	require.Equal(t, []string{"TestUser"}, request.attributes["requesting-user-name"])
+	require.Equal(t, []string{"TestJob"}, request.attributes["job-name"])
+	require.Equal(t, []string{DefaultIppDocumentFormat}, request.attributes["document-format"])
+	require.Equal(t, []string{"2"}, request.attributes["copies"])
+	require.Equal(t, "Text for the file", request.data)
+
+	attributes, err := client.GetPrinterAttributes("printer-state", "document-format-supported")
+	require.Nil(t, err)
+	require.Equal(t, []string{"3"}, attributes["printer-state"])
+	require.Equal(t, []string{"true"}, attributes["printer-is-accepting-jobs"])
+	require.Equal(t, []string{"application/pdf", "application/postscript"}, attributes["document-format-supported"])
+
+	request = <-requests
+	require.Equal(t, ippOperationGetPrinterAttributes, request.operation)
+	require.Equal(t, []string{"printer-state", "document-format-supported"}, request.attributes["requested-attributes"])
+
+	// the printer is busy
+	uri, _ = startTestIppPrinter(t, 0x0507)
+	client.PrinterURI = uri
+	_, err = client.Print(strings.NewReader("Text for the file"), "TestJob", 1)
+	var statusErr *IppStatusError
+	require.True(t, errors.As(err, &statusErr))
+	require.Equal(t, uint16(0x0507), statusErr.Status)
+	require.True(t, statusErr.Temporary())
+
+	client.PrinterURI = "lpd://printer/queue"
+	_, err = client.GetPrinterAttributes()
+	require.NotNil(t, err)
+}
+
+func TestSendIppFallback(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	uri, requests := startTestIppPrinter(t, 0)
+
+	// nobody is listening on the LPD port
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute, WithJobName("TestJob"), WithIppFallback(uri))
+	require.Nil(t, err)
+
+	request := <-requests
+	require.Equal(t, []string{"TestUser"}, request.attributes["requesting-user-name"])
+	require.Equal(t, []string{"TestJob"}, request.attributes["job-name"])
+	require.Equal(t, "Text for the file", request.data)
+
+	err = SendStream(strings.NewReader("Text of the stream"), 18, "stream.txt", "127.0.0.1", port, "raw", "TestUser", time.Minute, WithIppFallback(uri))
+	require.Nil(t, err)
+
+	request = <-requests
+	require.Equal(t, []string{"stream.txt"}, request.attributes["job-name"])
+	require.Equal(t, "Text of the stream", request.data)
+
+	// without fallback, the error is returned
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.NotNil(t, err)
+	require.Equal(t, 0, len(requests))
+}
@@ -0,0 +1,132 @@
+package lprlib
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultJanitorPatterns are the patterns of the files created by the LprDaemon using the default naming:
+// trace files, data files, saved control files and part files of aborted atomic writes.
+var DefaultJanitorPatterns = []string{"lpr_trace_*", "lpr_data_*", ".lpr_part_*"}
+
+// Janitor removes stale files from a spool directory.
+type Janitor struct {
+	// Dir is the directory which should be cleaned.
+	// If empty, the default system temp directory will be used.
+	Dir string
+
+	// MaxAge is the age (time since the last modification) after which a file is removed.
+	MaxAge time.Duration
+
+	// Interval is the time between two cleanups done by Run.
+	// If 0, MaxAge is used.
+	Interval time.Duration
+
+	// Patterns are the file name patterns (see filepath.Match) of the files which may be removed.
+	// If empty, DefaultJanitorPatterns is used.
+	Patterns []string
+
+	// OnRemove will be called for every removed file.
+	OnRemove func(fileName string)
+}
+
+// Run removes stale files every Interval until ctx is done.
+func (j *Janitor) Run(ctx context.Context) {
+	interval := j.Interval
+	if interval <= 0 {
+		interval = j.MaxAge
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := j.Clean()
+		if err != nil {
+			logErrorf("Error cleaning up %s: %v", j.dir(), err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Clean removes all files matching the Patterns which were not modified for MaxAge
+// and returns their names.
+func (j *Janitor) Clean() ([]string, error) {
+	if j.MaxAge <= 0 {
+		return nil, fmt.Errorf("invalid maximum age %v", j.MaxAge)
+	}
+
+	patterns := j.Patterns
+	if len(patterns) == 0 {
+		patterns = DefaultJanitorPatterns
+	}
+
+	entries, err := os.ReadDir(j.dir())
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []string{}
+	deadline := time.Now().Add(-j.MaxAge)
+
+	for _, entry := range entries {
+		if entry.IsDir() || !matchesAny(entry.Name(), patterns) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			// the file was removed in the meantime
+			continue
+		}
+
+		if info.ModTime().After(deadline) {
+			continue
+		}
+
+		fileName := filepath.Join(j.dir(), entry.Name())
+		err = os.Remove(fileName)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				logErrorf("Error removing stale file %s: %v", fileName, err)
+			}
+			continue
+		}
+
+		logDebugf("Removed stale file %s", fileName)
+		removed = append(removed, fileName)
+
+		if j.OnRemove != nil {
+			j.OnRemove(fileName)
+		}
+	}
+
+	return removed, nil
+}
+
+func (j *Janitor) dir() string {
+	if j.Dir == "" {
+		return os.TempDir()
+	}
+
+	return j.Dir
+}
+
+// matchesAny tells if name matches any of the given patterns.
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
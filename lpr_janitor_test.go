@@ -0,0 +1,48 @@
+package lprlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJanitorClean(t *testing.T) {
+	dir := t.TempDir()
+
+	old := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{"lpr_trace_1", "lpr_data_1", "lpr_data_1.cf", ".lpr_part_1", "other", "lpr_data_2"} {
+		fileName := filepath.Join(dir, name)
+		require.Nil(t, os.WriteFile(fileName, []byte("data"), 0600))
+		if name != "lpr_data_2" {
+			require.Nil(t, os.Chtimes(fileName, old, old))
+		}
+	}
+
+	removedFiles := []string{}
+	janitor := Janitor{
+		Dir:    dir,
+		MaxAge: time.Hour,
+		OnRemove: func(fileName string) {
+			removedFiles = append(removedFiles, filepath.Base(fileName))
+		},
+	}
+
+	removed, err := janitor.Clean()
+	require.Nil(t, err)
+	require.Len(t, removed, 4)
+	require.ElementsMatch(t, []string{"lpr_trace_1", "lpr_data_1", "lpr_data_1.cf", ".lpr_part_1"}, removedFiles)
+
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	remaining := []string{}
+	for _, entry := range entries {
+		remaining = append(remaining, entry.Name())
+	}
+	require.ElementsMatch(t, []string{"other", "lpr_data_2"}, remaining)
+
+	_, err = (&Janitor{Dir: dir}).Clean()
+	require.NotNil(t, err)
+}
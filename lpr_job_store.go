@@ -0,0 +1,167 @@
+package lprlib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrJobNotFound is returned by a JobStore if there is no job with the requested ID.
+var ErrJobNotFound = errors.New("spooled job not found")
+
+// JobStore stores the metadata of the jobs of a Spooler, e.g. in a database.
+// The data files of the jobs are stored in the spool directory nevertheless.
+// The methods are called with the lock of the spooler held, so they are not called concurrently
+// by a single spooler, and a job is stored once Put or UpdateState returned without error.
+type JobStore interface {
+	// Put stores the job, replacing the stored job with the same ID.
+	Put(job SpoolJob) error
+
+	// Get returns the job with the given ID or ErrJobNotFound.
+	// The Spooler reads the stored jobs once by List and keeps them in memory afterwards,
+	// so it doesn't call Get, which is meant for applications inspecting the store
+	// (e.g. a status page of another process sharing the database).
+	Get(id uint64) (SpoolJob, error)
+
+	// List returns all stored jobs.
+	List() ([]SpoolJob, error)
+
+	// Delete removes the job with the given ID. Deleting an unknown job is no error.
+	Delete(id uint64) error
+
+	// UpdateState changes the state of the job with the given ID or returns ErrJobNotFound.
+	UpdateState(id uint64, state SpoolJobState) error
+}
+
+// FileJobStore is the JobStore used by a Spooler if no Store is set. It stores the metadata
+// of each job as JSON file next to the data files in the spool directory.
+type FileJobStore struct {
+	// Dir is the directory the files are stored in.
+	Dir string
+}
+
+// Put stores the job. The file is replaced atomically and flushed to disk.
+func (f *FileJobStore) Put(job SpoolJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(f.Dir, ".lpr_spool_*")
+	if err != nil {
+		return fmt.Errorf("error writing spooled job %d: %w", job.ID, err)
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cErr := file.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filepath.Join(f.Dir, spoolJobName(job.ID)))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("error writing spooled job %d: %w", job.ID, err)
+	}
+
+	return nil
+}
+
+// Get reads the job with the given ID.
+func (f *FileJobStore) Get(id uint64) (SpoolJob, error) {
+	job, err := f.read(spoolJobName(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return SpoolJob{}, fmt.Errorf("%w: %d", ErrJobNotFound, id)
+	}
+	if err != nil {
+		return SpoolJob{}, fmt.Errorf("error reading spooled job %d: %w", id, err)
+	}
+
+	return job, nil
+}
+
+// List reads all jobs of the directory ordered by their IDs. Invalid files are ignored.
+func (f *FileJobStore) List() ([]SpoolJob, error) {
+	entries, err := os.ReadDir(f.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []SpoolJob{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading spool directory: %w", err)
+	}
+
+	jobs := []SpoolJob{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), spoolJobExtension) {
+			continue
+		}
+
+		job, err := f.read(entry.Name())
+		if err != nil {
+			logErrorf("Ignoring spooled job %s: %v", entry.Name(), err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].ID < jobs[j].ID
+	})
+
+	return jobs, nil
+}
+
+// Delete removes the file of the job with the given ID.
+func (f *FileJobStore) Delete(id uint64) error {
+	err := os.Remove(filepath.Join(f.Dir, spoolJobName(id)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing spooled job %d: %w", id, err)
+	}
+
+	return nil
+}
+
+// UpdateState changes the state of the job with the given ID.
+func (f *FileJobStore) UpdateState(id uint64, state SpoolJobState) error {
+	job, err := f.Get(id)
+	if err != nil {
+		return err
+	}
+
+	job.State = state
+
+	return f.Put(job)
+}
+
+// read reads the metadata of a job from the file with the given name.
+func (f *FileJobStore) read(name string) (SpoolJob, error) {
+	data, err := os.ReadFile(filepath.Join(f.Dir, name))
+	if err != nil {
+		return SpoolJob{}, err
+	}
+
+	job := SpoolJob{}
+	err = json.Unmarshal(data, &job)
+	if err != nil {
+		return SpoolJob{}, err
+	}
+
+	if name != spoolJobName(job.ID) {
+		return SpoolJob{}, fmt.Errorf("job file contains job %d", job.ID)
+	}
+
+	return job, nil
+}
+
+// spoolJobName returns the name of the metadata file of the job with the given ID.
+func spoolJobName(id uint64) string {
+	return strconv.FormatUint(id, 10) + spoolJobExtension
+}
@@ -0,0 +1,231 @@
+package lprlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memoryJobStore is a JobStore keeping the jobs in memory.
+type memoryJobStore struct {
+	mutex sync.Mutex
+	jobs  map[uint64]SpoolJob
+}
+
+func (m *memoryJobStore) Put(job SpoolJob) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.jobs[job.ID] = job
+	return nil
+}
+
+func (m *memoryJobStore) Get(id uint64) (SpoolJob, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return SpoolJob{}, fmt.Errorf("%w: %d", ErrJobNotFound, id)
+	}
+	return job, nil
+}
+
+func (m *memoryJobStore) List() ([]SpoolJob, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	jobs := []SpoolJob{}
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].ID < jobs[j].ID
+	})
+	return jobs, nil
+}
+
+func (m *memoryJobStore) Delete(id uint64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.jobs, id)
+	return nil
+}
+
+func (m *memoryJobStore) UpdateState(id uint64, state SpoolJobState) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrJobNotFound, id)
+	}
+	job.State = state
+	m.jobs[id] = job
+	return nil
+}
+
+// exclusiveJobStore is a FileJobStore recording if its methods are called concurrently.
+type exclusiveJobStore struct {
+	FileJobStore
+	calls      int32
+	concurrent int32
+}
+
+func (e *exclusiveJobStore) enter() func() {
+	if atomic.AddInt32(&e.calls, 1) > 1 {
+		atomic.StoreInt32(&e.concurrent, 1)
+	}
+	return func() {
+		atomic.AddInt32(&e.calls, -1)
+	}
+}
+
+func (e *exclusiveJobStore) Put(job SpoolJob) error {
+	defer e.enter()()
+	time.Sleep(time.Millisecond)
+	return e.FileJobStore.Put(job)
+}
+
+func (e *exclusiveJobStore) Delete(id uint64) error {
+	defer e.enter()()
+	return e.FileJobStore.Delete(id)
+}
+
+func (e *exclusiveJobStore) UpdateState(id uint64, state SpoolJobState) error {
+	defer e.enter()()
+	return e.FileJobStore.UpdateState(id, state)
+}
+
+func TestFileJobStore(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	store := &FileJobStore{Dir: t.TempDir()}
+
+	jobs, err := store.List()
+	require.Nil(t, err)
+	require.Empty(t, jobs)
+
+	for _, id := range []uint64{2, 1} {
+		err = store.Put(SpoolJob{ID: id, State: SpoolJobPending, Queue: "raw", User: "TestUser"})
+		require.Nil(t, err)
+	}
+
+	// invalid files are ignored
+	err = os.WriteFile(filepath.Join(store.Dir, "3.job"), []byte("{"), 0o600)
+	require.Nil(t, err)
+
+	jobs, err = store.List()
+	require.Nil(t, err)
+	require.Equal(t, 2, len(jobs))
+	require.Equal(t, uint64(1), jobs[0].ID)
+	require.Equal(t, "TestUser", jobs[1].User)
+
+	err = store.UpdateState(2, SpoolJobFailed)
+	require.Nil(t, err)
+
+	job, err := store.Get(2)
+	require.Nil(t, err)
+	require.Equal(t, SpoolJobFailed, job.State)
+	require.Equal(t, "raw", job.Queue)
+
+	require.Nil(t, store.Delete(2))
+	require.Nil(t, store.Delete(2))
+
+	_, err = store.Get(2)
+	require.True(t, errors.Is(err, ErrJobNotFound))
+	require.True(t, errors.Is(store.UpdateState(2, SpoolJobPending), ErrJobNotFound))
+}
+
+func TestSpoolerJobStore(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	dir := t.TempDir()
+	store := &memoryJobStore{jobs: map[uint64]SpoolJob{}}
+
+	spooler := &Spooler{Dir: dir, Store: store}
+	job, err := spooler.Enqueue(&LprConnection{PrqName: "raw", Data: []byte("stored job"), Status: End})
+	require.Nil(t, err)
+
+	stored, err := store.Get(job.ID)
+	require.Nil(t, err)
+	require.Equal(t, SpoolJobPending, stored.State)
+	require.Equal(t, job.DataFile, stored.DataFile)
+
+	// only the data file is stored in the spool directory
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(entries))
+
+	// jobs interrupted while being processed are pending again
+	require.Nil(t, store.UpdateState(job.ID, SpoolJobProcessing))
+
+	processed := make(chan SpoolJob, 1)
+	spooler = &Spooler{Dir: dir, Store: store}
+	spooler.Processor = func(ctx context.Context, job SpoolJob) error {
+		stored, err := store.Get(job.ID)
+		if err != nil {
+			return err
+		}
+		processed <- stored
+		return nil
+	}
+
+	jobs, err := spooler.Jobs("raw")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(jobs))
+	require.Equal(t, SpoolJobPending, jobs[0].State)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error)
+	go func() {
+		finished <- spooler.Run(ctx)
+	}()
+
+	require.Equal(t, SpoolJobProcessing, (<-processed).State)
+
+	require.Eventually(t, func() bool {
+		jobs, err := store.List()
+		return err == nil && len(jobs) == 0
+	}, 10*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.Nil(t, <-finished)
+
+	entries, err = os.ReadDir(dir)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(entries))
+}
+
+func TestSpoolerJobStoreNotConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	store := &exclusiveJobStore{FileJobStore: FileJobStore{Dir: dir}}
+	spooler := &Spooler{Dir: dir, Store: store}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			job, err := spooler.Enqueue(&LprConnection{PrqName: "raw", Data: []byte("stored job"), Status: End})
+			if err == nil {
+				err = spooler.Remove(job.ID)
+			}
+			require.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(0), atomic.LoadInt32(&store.concurrent))
+}
@@ -0,0 +1,15 @@
+package lprlib
+
+import "github.com/documatrix/go-lprlib/pjl"
+
+// parsePJL parses the PJL job header at the start of the received data file.
+func (lpr *LprConnection) parsePJL() {
+	header, err := pjl.Parse(lpr.head)
+	if err != nil {
+		logDebugf("Data file has no PJL header: %v", err)
+		return
+	}
+
+	logDebugf("PJL header of data file: job name %q, user %q, %d copies, language %s", header.JobName, header.UserName, header.Copies, header.Language)
+	lpr.PJL = header
+}
@@ -0,0 +1,70 @@
+package lprlib
+
+import (
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/documatrix/go-lprlib/pjl"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemonParsePJL(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+	rawPort := uint16(2348)
+
+	text := pjl.UEL + "@PJL JOB NAME=\"Invoice 42\"\r\n@PJL SET USERNAME=\"jdoe\"\r\n@PJL SET COPIES=2\r\n@PJL ENTER LANGUAGE=PCL\r\n" +
+		strings.Repeat("\x1bE PCL data", 10000) + pjl.UEL
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.ParsePJL = true
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	err = lprd.InitRaw(rawPort, "")
+	require.Nil(t, err)
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute, WithJobName("job.prn"))
+	require.Nil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "job.prn", conn.JobName)
+	require.NotNil(t, conn.PJL)
+	require.Equal(t, "Invoice 42", conn.PJL.JobName)
+	require.Equal(t, "jdoe", conn.PJL.UserName)
+	require.Equal(t, 2, conn.PJL.Copies)
+	require.Equal(t, "PCL", conn.PJL.Language)
+
+	data, err := os.ReadFile(conn.SaveName)
+	require.Nil(t, err)
+	require.Equal(t, text, string(data))
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	err = SendRaw(name, "127.0.0.1", rawPort, time.Minute)
+	require.Nil(t, err)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.NotNil(t, conn.PJL)
+	require.Equal(t, "Invoice 42", conn.PJL.JobName)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// data files without PJL header
+	err = SendStream(strings.NewReader("%PDF-1.7\n"), 9, "doc.pdf", "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Nil(t, conn.PJL)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	lprd.Close()
+}
@@ -0,0 +1,245 @@
+package lprlib
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SOCKS5 protocol constants (see RFC-1928 and RFC-1929)
+const (
+	socks5Version        = 5
+	socks5NoAuth         = 0
+	socks5PasswordAuth   = 2
+	socks5NoAcceptable   = 0xff
+	socks5Connect        = 1
+	socks5AddrIPv4       = 1
+	socks5AddrDomainName = 3
+	socks5AddrIPv6       = 4
+)
+
+// dialProxy connects to the printer through the Proxy.
+// The host name of the printer is resolved by the proxy.
+func (lpr *LprSend) dialProxy(hostname string, port uint16) (net.Conn, error) {
+	proxyAddress := lpr.Proxy.Host
+	if lpr.Proxy.Port() == "" {
+		switch lpr.Proxy.Scheme {
+		case "http":
+			proxyAddress = net.JoinHostPort(lpr.Proxy.Hostname(), "80")
+		default:
+			proxyAddress = net.JoinHostPort(lpr.Proxy.Hostname(), "1080")
+		}
+	}
+
+	var dialer net.Dialer
+	if lpr.Dialer != nil {
+		dialer = *lpr.Dialer
+	}
+	if lpr.DialTimeout > 0 {
+		dialer.Timeout = lpr.DialTimeout
+	}
+
+	socket, err := dialer.Dial("tcp", proxyAddress)
+	if err != nil {
+		return nil, fmt.Errorf("Can't connect to proxy %s: %w", proxyAddress, err)
+	}
+
+	// the handshake is limited like the connection attempt
+	if dialer.Timeout > 0 {
+		socket.SetDeadline(time.Now().Add(dialer.Timeout))
+	}
+
+	switch lpr.Proxy.Scheme {
+	case "socks5", "socks5h":
+		err = socks5Handshake(socket, lpr.Proxy.User, hostname, port)
+	case "http":
+		err = httpConnect(socket, lpr.Proxy.User, hostname, port)
+	default:
+		err = fmt.Errorf("Unsupported proxy scheme %q", lpr.Proxy.Scheme)
+	}
+	if err != nil {
+		socket.Close()
+		return nil, fmt.Errorf("Proxy %s: %w", proxyAddress, err)
+	}
+
+	socket.SetDeadline(time.Time{})
+	return socket, nil
+}
+
+// socks5Handshake requests a connection to the host and port from a SOCKS5 proxy.
+func socks5Handshake(socket net.Conn, user *url.Userinfo, hostname string, port uint16) error {
+	methods := []byte{socks5NoAuth}
+	if user != nil {
+		methods = []byte{socks5PasswordAuth}
+	}
+
+	_, err := socket.Write(append([]byte{socks5Version, byte(len(methods))}, methods...))
+	if err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(socket, reply)
+	if err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("Unexpected SOCKS version %d", reply[0])
+	}
+
+	switch reply[1] {
+	case socks5NoAuth:
+	case socks5PasswordAuth:
+		if user == nil {
+			return fmt.Errorf("SOCKS proxy requires authentication")
+		}
+		err = socks5Authenticate(socket, user)
+		if err != nil {
+			return err
+		}
+	case socks5NoAcceptable:
+		return fmt.Errorf("SOCKS proxy accepts no authentication method")
+	default:
+		return fmt.Errorf("Unsupported SOCKS authentication method %d", reply[1])
+	}
+
+	request := []byte{socks5Version, socks5Connect, 0}
+	if ip := net.ParseIP(hostname); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			request = append(append(request, socks5AddrIPv4), ip4...)
+		} else {
+			request = append(append(request, socks5AddrIPv6), ip.To16()...)
+		}
+	} else {
+		if len(hostname) > 255 {
+			return fmt.Errorf("Host name %q is too long", hostname)
+		}
+		request = append(append(request, socks5AddrDomainName, byte(len(hostname))), hostname...)
+	}
+	request = append(request, byte(port>>8), byte(port))
+
+	_, err = socket.Write(request)
+	if err != nil {
+		return err
+	}
+
+	// VER, REP, RSV and ATYP of the reply
+	reply = make([]byte, 4)
+	_, err = io.ReadFull(socket, reply)
+	if err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return fmt.Errorf("SOCKS proxy refused the connection to %s (reply %d)", net.JoinHostPort(hostname, strconv.Itoa(int(port))), reply[1])
+	}
+
+	// skip the bound address and port
+	var length int
+	switch reply[3] {
+	case socks5AddrIPv4:
+		length = net.IPv4len
+	case socks5AddrIPv6:
+		length = net.IPv6len
+	case socks5AddrDomainName:
+		size := make([]byte, 1)
+		_, err = io.ReadFull(socket, size)
+		if err != nil {
+			return err
+		}
+		length = int(size[0])
+	default:
+		return fmt.Errorf("Unsupported SOCKS address type %d", reply[3])
+	}
+	_, err = io.ReadFull(socket, make([]byte, length+2))
+	return err
+}
+
+// socks5Authenticate authenticates with user name and password at a SOCKS5 proxy (see RFC-1929).
+func socks5Authenticate(socket net.Conn, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return fmt.Errorf("SOCKS user name or password is too long")
+	}
+
+	request := append([]byte{1, byte(len(username))}, username...)
+	request = append(append(request, byte(len(password))), password...)
+	_, err := socket.Write(request)
+	if err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(socket, reply)
+	if err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return fmt.Errorf("SOCKS authentication failed")
+	}
+
+	return nil
+}
+
+// httpConnect requests a tunnel to the host and port from an HTTP proxy (CONNECT method).
+func httpConnect(socket net.Conn, user *url.Userinfo, hostname string, port uint16) error {
+	address := net.JoinHostPort(hostname, strconv.Itoa(int(port)))
+
+	request := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", address, address)
+	if user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		request += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	request += "\r\n"
+
+	_, err := socket.Write([]byte(request))
+	if err != nil {
+		return err
+	}
+
+	// the reader must not read beyond the response, as the tunnel starts right after it
+	reader := bufio.NewReader(oneByteReader{socket})
+	status, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	status = strings.TrimSpace(status)
+
+	fields := strings.Fields(status)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "HTTP/") {
+		return fmt.Errorf("Invalid response of HTTP proxy: %q", status)
+	}
+	if fields[1] != "200" {
+		return fmt.Errorf("HTTP proxy refused the connection to %s: %s", address, status)
+	}
+
+	// skip the header lines
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(line) == "" {
+			return nil
+		}
+	}
+}
+
+// oneByteReader reads at most one byte per call, so no data behind the HTTP response is consumed.
+type oneByteReader struct {
+	reader io.Reader
+}
+
+func (r oneByteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return r.reader.Read(p)
+}
@@ -0,0 +1,185 @@
+package lprlib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startTestProxy starts a proxy which uses handshake to read the requested address
+// and forwards the connection to target. The requested addresses are sent to the returned channel.
+func startTestProxy(t *testing.T, target string, handshake func(conn net.Conn, reader *bufio.Reader) (string, error)) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	requested := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				reader := bufio.NewReader(conn)
+				address, err := handshake(conn, reader)
+				if err != nil {
+					return
+				}
+				requested <- address
+
+				upstream, err := net.Dial("tcp", target)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+
+				go func() {
+					io.Copy(upstream, reader)
+					upstream.Close()
+				}()
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), requested
+}
+
+// socks5TestHandshake is the server side of a SOCKS5 handshake with user name and password.
+func socks5TestHandshake(conn net.Conn, reader *bufio.Reader) (string, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(reader, header)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.ReadFull(reader, make([]byte, header[1]))
+	if err != nil {
+		return "", err
+	}
+	conn.Write([]byte{socks5Version, socks5PasswordAuth})
+
+	readString := func() string {
+		size, _ := reader.ReadByte()
+		value := make([]byte, size)
+		io.ReadFull(reader, value)
+		return string(value)
+	}
+	reader.ReadByte()
+	username := readString()
+	password := readString()
+	if username != "user" || password != "secret" {
+		conn.Write([]byte{1, 1})
+		return "", fmt.Errorf("authentication failed")
+	}
+	conn.Write([]byte{1, 0})
+
+	request := make([]byte, 4)
+	_, err = io.ReadFull(reader, request)
+	if err != nil {
+		return "", err
+	}
+	if request[3] != socks5AddrDomainName {
+		return "", fmt.Errorf("unexpected address type %d", request[3])
+	}
+	host := readString()
+	port := make([]byte, 2)
+	io.ReadFull(reader, port)
+
+	conn.Write([]byte{socks5Version, 0, 0, socks5AddrIPv4, 127, 0, 0, 1, 0, 0})
+	return fmt.Sprintf("%s:%d", host, int(port[0])<<8|int(port[1])), nil
+}
+
+// httpTestHandshake is the server side of an HTTP CONNECT request.
+func httpTestHandshake(conn net.Conn, reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(line)
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if strings.TrimSpace(header) == "" {
+			break
+		}
+	}
+
+	if fields[1] == "refused.invalid:515" {
+		conn.Write([]byte("HTTP/1.1 403 Forbidden\r\n\r\n"))
+		return "", fmt.Errorf("forbidden")
+	}
+
+	conn.Write([]byte("HTTP/1.1 200 Connection established\r\nProxy-Agent: test\r\n\r\n"))
+	return fields[1], nil
+}
+
+func TestSendProxy(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	target := fmt.Sprintf("127.0.0.1:%d", port)
+
+	for scheme, handshake := range map[string]func(net.Conn, *bufio.Reader) (string, error){
+		"socks5://user:secret@": socks5TestHandshake,
+		"http://":               httpTestHandshake,
+	} {
+		address, requested := startTestProxy(t, target, handshake)
+		proxy, err := url.Parse(scheme + address)
+		require.Nil(t, err)
+
+		err = SendStream(strings.NewReader(text), int64(len(text)), "", "printer.invalid", 0, "raw", "TestUser", time.Minute,
+			WithProxy(proxy))
+		require.Nil(t, err, scheme)
+		require.Equal(t, "printer.invalid:515", <-requested)
+
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+		out, err := os.ReadFile(conn.SaveName)
+		require.Nil(t, err)
+		require.Nil(t, os.Remove(conn.SaveName))
+		require.Equal(t, text, string(out))
+	}
+
+	// wrong credentials
+	address, _ := startTestProxy(t, target, socks5TestHandshake)
+	proxy, err := url.Parse("socks5://user:wrong@" + address)
+	require.Nil(t, err)
+	var lprs LprSend
+	lprs.Proxy = proxy
+	err = lprs.Init("printer.invalid", "", 0, "raw", "TestUser", time.Minute)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "SOCKS authentication failed")
+
+	// refused connection
+	address, _ = startTestProxy(t, target, httpTestHandshake)
+	proxy, err = url.Parse("http://" + address)
+	require.Nil(t, err)
+	lprs.Proxy = proxy
+	err = lprs.Init("refused.invalid", "", 0, "raw", "TestUser", time.Minute)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "403 Forbidden")
+}
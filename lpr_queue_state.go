@@ -0,0 +1,164 @@
+package lprlib
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// QueueJob is a job listed in the queue state of a printer.
+type QueueJob struct {
+	// Rank is the position of the job in the queue, e.g. "active" or "1st"
+	Rank string
+
+	// Owner is the user who submitted the job
+	Owner string
+
+	// JobNumber is the number of the job (see LprSend.JobNumber)
+	JobNumber string
+
+	// Files contains the names of the files of the job
+	Files string
+
+	// Size is the total size of the job in bytes (0 if unknown)
+	Size int64
+
+	// Host is the host the job was sent from (only listed by the long format, see FormatQueueState)
+	Host string
+}
+
+// QueueStatus is the parsed (short) queue state of a printer.
+type QueueStatus struct {
+	// Jobs contains the jobs in the order listed by the printer
+	Jobs []QueueJob
+
+	// Messages contains the lines which don't describe a job, e.g. "no entries"
+	// or "printer is ready and printing"
+	Messages []string
+}
+
+// rankPattern matches the rank of a job in a queue state (e.g. "active", "1st" or "22nd")
+var rankPattern = regexp.MustCompile(`^(active|[0-9]+(st|nd|rd|th))$`)
+
+// ParseQueueState parses a short queue state in the format of the BSD lpq, which is returned
+// by most LPD servers, e.g.
+//
+//	Rank   Owner      Job  Files                                 Total Size
+//	active alice      123  report.pdf                            12345 bytes
+//	1st    bob        124  letter.txt                            678 bytes
+//
+// Lines which don't describe a job (including the header) are returned as Messages.
+func ParseQueueState(state string) *QueueStatus {
+	status := &QueueStatus{}
+
+	for _, line := range strings.Split(state, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		job, ok := parseQueueJob(line)
+		if ok {
+			status.Jobs = append(status.Jobs, job)
+		} else if !isQueueStateHeader(line) {
+			status.Messages = append(status.Messages, strings.TrimSpace(line))
+		}
+	}
+
+	return status
+}
+
+// queueStateHeader is the header of the job list of a short queue state.
+const queueStateHeader = "Rank   Owner      Job  Files                                 Total Size\n"
+
+// maxQueueStateFilesLength is the width of the files column of a short queue state.
+const maxQueueStateFilesLength = 37
+
+// FormatQueueState formats the jobs as queue state in the format of the BSD lpq, which can be
+// parsed by ParseQueueState. The short format lists one job per line below a header, e.g.
+//
+//	Rank   Owner      Job  Files                                 Total Size
+//	active alice      123  report.pdf                            12345 bytes
+//
+// while the long format describes each job in a paragraph, e.g.
+//
+//	alice: active                           [job 123 workstation]
+//	        report.pdf                      12345 bytes
+//
+// If there are no jobs, "no entries" is returned.
+func FormatQueueState(jobs []QueueJob, long bool) string {
+	if len(jobs) == 0 {
+		return "no entries\n"
+	}
+
+	builder := strings.Builder{}
+	if !long {
+		builder.WriteString(queueStateHeader)
+	}
+
+	for _, job := range jobs {
+		if long {
+			builder.WriteString(fmt.Sprintf("\n%-40s[job %s %s]\n", job.Owner+": "+job.Rank, job.JobNumber, job.Host))
+			builder.WriteString(fmt.Sprintf("        %-32s%d bytes\n", job.Files, job.Size))
+			continue
+		}
+
+		files := job.Files
+		if utf8.RuneCountInString(files) > maxQueueStateFilesLength {
+			files = string([]rune(files)[:maxQueueStateFilesLength-3]) + "..."
+		}
+		builder.WriteString(fmt.Sprintf("%-7s%-11s%-5s%-38s%d bytes\n", job.Rank, job.Owner, job.JobNumber, files, job.Size))
+	}
+
+	return builder.String()
+}
+
+// queueRank returns the rank of the job at the given position (starting at 1) of a queue, e.g. "2nd".
+func queueRank(position int) string {
+	suffix := "th"
+	if position%100 < 11 || position%100 > 13 {
+		switch position % 10 {
+		case 1:
+			suffix = "st"
+		case 2:
+			suffix = "nd"
+		case 3:
+			suffix = "rd"
+		}
+	}
+
+	return strconv.Itoa(position) + suffix
+}
+
+// parseQueueJob parses a job line of a queue state.
+func parseQueueJob(line string) (QueueJob, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !rankPattern.MatchString(fields[0]) {
+		return QueueJob{}, false
+	}
+	if _, err := strconv.Atoi(fields[2]); err != nil {
+		return QueueJob{}, false
+	}
+
+	job := QueueJob{Rank: fields[0], Owner: fields[1], JobNumber: fields[2]}
+
+	files := fields[3:]
+	if len(files) >= 2 && files[len(files)-1] == "bytes" {
+		size, err := strconv.ParseInt(files[len(files)-2], 10, 64)
+		if err == nil {
+			job.Size = size
+			files = files[:len(files)-2]
+		}
+	}
+	job.Files = strings.Join(files, " ")
+
+	return job, true
+}
+
+// isQueueStateHeader tells if the line is the header of the job list.
+func isQueueStateHeader(line string) bool {
+	fields := strings.Fields(line)
+	return len(fields) >= 3 && fields[0] == "Rank" && fields[1] == "Owner"
+}
@@ -0,0 +1,30 @@
+package lprlib
+
+import "bytes"
+
+// WindowsDummyFileSize is the announced data file size above which the size is considered a dummy.
+// Without "LPR Byte Counting", the LPR port monitor of Windows doesn't know the size of the data file
+// and announces a huge size instead (see LprDaemon.WindowsQuirks).
+const WindowsDummyFileSize = 2 * 1024 * 1024 * 1024
+
+// dummyFileSize tells if the announced size of the data file is a dummy (see WindowsDummyFileSize).
+func (lpr *LprConnection) dummyFileSize() bool {
+	return lpr.daemon.WindowsQuirks && lpr.Filesize > WindowsDummyFileSize
+}
+
+// acceptsIncompleteDataFile tells if a data file may end before the announced size was received,
+// because the client closed the connection. Data files with a dummy size are always received until
+// the connection is closed, but without WindowsQuirks, their received size is checked (see VerifySize).
+func (lpr *LprConnection) acceptsIncompleteDataFile() bool {
+	return lpr.Filesize == 0 || lpr.Filesize > WindowsDummyFileSize || lpr.daemon.WindowsQuirks
+}
+
+// controlFileLines returns the control file data to parse. Windows ends the lines of control files
+// with CR LF, so the carriage returns are removed if LprDaemon.WindowsQuirks is set.
+func (lpr *LprConnection) controlFileLines(data []byte) []byte {
+	if !lpr.daemon.WindowsQuirks {
+		return data
+	}
+
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+}
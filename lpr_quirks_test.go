@@ -0,0 +1,121 @@
+package lprlib
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sendWindowsJob sends a job like the LPR port monitor of Windows, announcing the given data file size,
+// and closes the connection after the data.
+func sendWindowsJob(t *testing.T, port uint16, size uint64, data string) {
+	socket, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.Nil(t, err)
+	defer socket.Close()
+
+	ack := make([]byte, 1)
+	command := func(text string) {
+		_, err := socket.Write([]byte(text))
+		require.Nil(t, err)
+		_, err = socket.Read(ack)
+		require.Nil(t, err)
+		require.Equal(t, byte(0), ack[0])
+	}
+
+	controlFile := "Hwinhost\r\nPjdoe\r\nJDocument\r\nldfA001winhost\r\n"
+	command("\x02raw\n")
+	command(fmt.Sprintf("\x02%d cfA001winhost\n", len(controlFile)))
+	command(controlFile + "\x00")
+	command(fmt.Sprintf("\x03%d dfA001winhost\n", size))
+
+	_, err = socket.Write([]byte(data))
+	require.Nil(t, err)
+	require.Nil(t, socket.(*net.TCPConn).CloseWrite())
+
+	socket.SetReadDeadline(time.Now().Add(time.Minute))
+	socket.Read(ack)
+}
+
+func TestDaemonWindowsQuirks(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.WindowsQuirks = true
+	lprd.MaxJobSize = 1024 * 1024
+	lprd.CheckDiskSpace = true
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+
+	// dummy size without byte counting
+	sendWindowsJob(t, port, 4294967295, "Text for the file")
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "jdoe", conn.UserIdentification)
+	require.Equal(t, "Document", conn.JobName)
+	require.Equal(t, "winhost", conn.Hostname)
+	require.Equal(t, uint64(17), conn.ReceivedSize)
+	require.False(t, conn.SizeVerified)
+
+	data, err := os.ReadFile(conn.SaveName)
+	require.Nil(t, err)
+	require.Equal(t, "Text for the file", string(data))
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// the announced size doesn't match
+	sendWindowsJob(t, port, 100, "Text for the file")
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, uint64(17), conn.ReceivedSize)
+	require.False(t, conn.SizeVerified)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	lprd.Close()
+
+	// without the quirks, data files with a dummy size are received until the connection is closed
+	// (each phase uses its own daemon, as Close doesn't wait for the goroutines of the daemon)
+	var plain LprDaemon
+	plain.InputFileSaveDir = t.TempDir()
+	err = plain.Init(port, "")
+	require.Nil(t, err)
+
+	sendWindowsJob(t, port, 4294967295, "Text for the file")
+
+	conn = <-plain.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "jdoe\r", conn.UserIdentification)
+	require.Equal(t, uint64(17), conn.ReceivedSize)
+	require.False(t, conn.SizeVerified)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// other sizes have to match
+	sendWindowsJob(t, port, 100, "Text for the file")
+
+	conn = <-plain.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+
+	plain.Close()
+
+	// the dummy size is checked by VerifySize
+	var verifying LprDaemon
+	verifying.InputFileSaveDir = t.TempDir()
+	verifying.VerifySize = true
+	err = verifying.Init(port, "")
+	require.Nil(t, err)
+
+	sendWindowsJob(t, port, 4294967295, "Text for the file")
+
+	conn = <-verifying.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+
+	verifying.Close()
+}
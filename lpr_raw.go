@@ -0,0 +1,163 @@
+package lprlib
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// DefaultRawPort is the port printers accept raw socket print jobs on (JetDirect / AppSocket).
+const DefaultRawPort = 9100
+
+// closeWriter is implemented by connections whose sending side can be closed separately,
+// e.g. *net.TCPConn and *tls.Conn.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// InitRaw initializes the LprSend for raw socket printing and connects to the printer.
+// The data sent by SendRawFile or SendRawReader is printed as is, without a control file,
+// so the Config and the queue are not used. The port is per default 9100.
+// The connection settings (e.g. TLSConfig, DialContext, Proxy and the timeouts) apply as for Init.
+func (lpr *LprSend) InitRaw(hostname string, port uint16, timeout time.Duration) error {
+	lpr.initRaw(timeout)
+
+	if port == 0 {
+		port = DefaultRawPort
+	}
+
+	return lpr.connect(hostname, port)
+}
+
+// initRaw initializes the LprSend for raw socket printing without connecting to the printer.
+func (lpr *LprSend) initRaw(timeout time.Duration) {
+	lpr.printJobStarted = false
+	lpr.jobNumber = nextJobNumber()
+	lpr.connected = false
+	lpr.timedOut = false
+	lpr.connectionFailed = false
+	lpr.bytesSent = 0
+	lpr.fileAcked = false
+
+	if lpr.MaxSize == 0 {
+		lpr.MaxSize = 16 * 1024
+	}
+
+	lpr.Timeout = timeout
+	lpr.inputFileName = ""
+}
+
+// SendRawFile sends the given file to the raw port of the printer (see InitRaw).
+func (lpr *LprSend) SendRawFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return &LprError{fmt.Sprintf("Can't open file %s: %s", path, err)}
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return &LprError{fmt.Sprintf("Can't stat file %s: %s", path, err)}
+	}
+
+	lpr.inputFileName = path
+	return lpr.SendRawReader(file, fileInfo.Size())
+}
+
+// SendRawReader sends the data read from the given reader to the raw port of the printer (see InitRaw).
+// The size is only used to report the progress (see OnProgress) and may be -1 if it is unknown.
+// Afterwards, the sending side of the connection is closed to tell the printer that the job is complete.
+func (lpr *LprSend) SendRawReader(reader io.Reader, size int64) error {
+	err := lpr.writeData(reader, size)
+	if err != nil {
+		return err
+	}
+
+	// printers start printing when the client stops sending
+	if conn, ok := lpr.socket.(closeWriter); ok {
+		err = conn.CloseWrite()
+		lpr.checkConnectionError(err)
+		if err != nil {
+			return &LprError{"PRINTER_ERROR: Can't close the connection for writing: " + err.Error()}
+		}
+	}
+
+	return nil
+}
+
+// rawRetryable tells if a failed raw attempt of the LprSend may be retried: the printer has no way
+// to acknowledge a job, so only attempts which failed to connect or timed out before any data
+// was written are retried.
+func (lpr *LprSend) rawRetryable() bool {
+	return !lpr.connected || (lpr.timedOut && lpr.bytesSent == 0)
+}
+
+// SendRaw is a convenience function to send the given file to the raw port of the printer (see InitRaw).
+// Options configuring the connection (e.g. WithTLS or WithProgress) apply, options setting fields
+// of the control file are ignored.
+func SendRaw(file string, hostname string, port uint16, timeout time.Duration, opts ...SendOption) error {
+	return sendRaw(&LprSend{}, hostname, port, timeout, opts, func(lpr *LprSend) error {
+		return lpr.SendRawFile(file)
+	})
+}
+
+// SendRawStream is a convenience function to send the data of the given reader to the raw port
+// of the printer. The size is only used to report the progress and may be -1 if it is unknown.
+func SendRawStream(reader io.Reader, size int64, hostname string, port uint16, timeout time.Duration, opts ...SendOption) error {
+	return sendRaw(&LprSend{}, hostname, port, timeout, opts, func(lpr *LprSend) error {
+		return lpr.SendRawReader(reader, size)
+	})
+}
+
+// SendRawWithRetry sends the given file to the raw port of the printer like SendRaw,
+// but retries failed attempts according to the given RetryPolicy. As the printer doesn't
+// acknowledge raw jobs, attempts are only retried if no data was written (RetryPolicy.Retransmit is not used).
+func SendRawWithRetry(file string, hostname string, port uint16, timeout time.Duration, policy RetryPolicy, opts ...SendOption) (err error) {
+	for attempt := 1; ; attempt++ {
+		lpr := &LprSend{}
+		err = sendRaw(lpr, hostname, port, timeout, opts, func(lpr *LprSend) error {
+			return lpr.SendRawFile(file)
+		})
+		if err == nil || attempt >= policy.MaxAttempts || !lpr.rawRetryable() {
+			return err
+		}
+
+		backoff := policy.backoff(attempt)
+		logErrorf("Attempt %d of %d to send %s failed, retrying in %v: %v", attempt, policy.MaxAttempts, file, backoff, err)
+		time.Sleep(backoff)
+	}
+}
+
+// sendRaw connects the given LprSend to the raw port of the printer and calls sendData to send the data.
+func sendRaw(lpr *LprSend, hostname string, port uint16, timeout time.Duration, opts []SendOption, sendData func(lpr *LprSend) error) (err error) {
+	lpr.initRaw(timeout)
+	lpr.Config = make(map[byte]string)
+
+	for _, opt := range opts {
+		opt(lpr)
+	}
+
+	if port == 0 {
+		port = DefaultRawPort
+	}
+
+	err = lpr.connect(hostname, port)
+	if err != nil {
+		return fmt.Errorf("Error initializing connection to raw printer %s, port %d! %w", hostname, port, err)
+	}
+
+	defer func() {
+		cerr := lpr.Close()
+		if err == nil {
+			err = cerr
+		}
+	}()
+
+	err = sendData(lpr)
+	if err != nil {
+		return fmt.Errorf("Error sending data to raw printer %s, port %d! %w", hostname, port, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package lprlib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultRawQueue is the queue name of jobs received by raw listeners, if LprDaemon.RawQueue is not set.
+const DefaultRawQueue = "raw"
+
+// InitRaw starts a raw listener (see ServeRawListener) on the given tcp port, which is per default 9100.
+// The daemon must have been initialized before (e.g. by Init).
+func (lpr *LprDaemon) InitRaw(port uint16, ipAddress string) error {
+	if port == 0 {
+		port = DefaultRawPort
+	}
+
+	listenAddr := net.JoinHostPort(ipAddress, strconv.Itoa(int(port)))
+	logDebugf("Listening for raw print jobs on: %s", listenAddr)
+
+	socket, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return &LprError{"Can't listen to " + listenAddr + " : " + err.Error()}
+	}
+
+	return lpr.ServeRawListener(socket)
+}
+
+// ServeRawListener accepts raw print jobs (JetDirect / AppSocket) from the given listener in addition
+// to the LPR connections of the daemon. Everything a client sends until it closes the connection
+// is received as data file of a job for the RawQueue, which is processed like a job received
+// over LPR (e.g. saved into the InputFileSaveDir and delivered by FinishedConnections).
+// Raw jobs have no control file, so only the data file related fields of the LprConnection are set.
+// The daemon must have been initialized before (e.g. by Init), the listener will be closed by Close.
+func (lpr *LprDaemon) ServeRawListener(listener net.Listener) error {
+	return lpr.serveAdditionalListener(listener, protocolRaw)
+}
+
+// serveAdditionalListener accepts connections speaking the given protocol from the listener
+// in addition to the listener of the daemon, until the daemon is closed.
+func (lpr *LprDaemon) serveAdditionalListener(listener net.Listener, protocol listenerProtocol) error {
+	lpr.listenersMutex.Lock()
+	defer lpr.listenersMutex.Unlock()
+
+	if lpr.listenDone == nil || lpr.listenersClosed {
+		listener.Close()
+		return &LprError{"Can't serve listener: the daemon is not running"}
+	}
+
+	lpr.additionalSockets = append(lpr.additionalSockets, listener)
+	lpr.additionalListeners.Add(1)
+
+	go func() {
+		defer lpr.additionalListeners.Done()
+
+		wg := sync.WaitGroup{}
+		lpr.accept(listener, protocol, &wg)
+		wg.Wait()
+	}()
+
+	return nil
+}
+
+// rawQueue returns the RawQueue or DefaultRawQueue.
+func (lpr *LprDaemon) rawQueue() string {
+	if lpr.RawQueue == "" {
+		return DefaultRawQueue
+	}
+
+	return lpr.RawQueue
+}
+
+// receiveRawJob receives a job from a connection accepted by a raw listener.
+// Connections closed without sending any data (e.g. probes of monitoring tools) are no jobs.
+func (lpr *LprConnection) receiveRawJob() error {
+	err := lpr.setReadTimeout(lpr.daemon.idleTimeout)
+	if err != nil {
+		return err
+	}
+
+	_, err = lpr.reader.Peek(1)
+	if errors.Is(err, io.EOF) {
+		logDebug("Raw connection was closed without sending data")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading from raw connection: %w", err)
+	}
+
+	lpr.setConnectionType(ConnectionTypeRaw)
+	lpr.PrqName = lpr.daemon.rawQueue()
+
+	if lpr.daemon.OnReceiveJob != nil {
+		err = lpr.daemon.OnReceiveJob(lpr.Connection.RemoteAddr(), lpr.PrqName)
+		if err != nil {
+			return fmt.Errorf("raw print job from %s rejected: %w", lpr.Connection.RemoteAddr(), err)
+		}
+	}
+
+	lpr.receivingJob = true
+	lpr.emit(JobAccepted, nil)
+
+	err = lpr.checkDiskSpace(0)
+	if err != nil {
+		return err
+	}
+
+	err = lpr.receiveStreamData(connectionReader{lpr})
+	if err != nil {
+		return fmt.Errorf("error receiving raw data: %w", err)
+	}
+
+	lpr.dataFileReceived = true
+
+	return nil
+}
+
+// receiveStreamData receives the data file of a job without announced size (e.g. a raw job)
+// from the given reader until it ends.
+func (lpr *LprConnection) receiveStreamData(reader io.Reader) (err error) {
+	lpr.Filesize = 0
+	lpr.processedDataBytes = 0
+	lpr.startInspection()
+
+	lpr.hash = nil
+	if lpr.daemon.ChecksumHash != nil {
+		lpr.hash = lpr.daemon.ChecksumHash()
+	}
+
+	err = lpr.openOutput()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		cErr := lpr.closeOutput()
+		if err == nil {
+			err = cErr
+		}
+
+		err = lpr.commitPartFile(err)
+	}()
+
+	lpr.lastProgress = time.Time{}
+	lpr.emit(DataFileStarted, nil)
+
+	for {
+		bytes, rErr := reader.Read(lpr.buffer)
+		if bytes > 0 {
+			err = lpr.writeToFile(lpr.buffer[:bytes])
+			if err != nil {
+				return fmt.Errorf("error writing %d bytes to output file: %w", bytes, err)
+			}
+			lpr.reportProgress(false)
+		}
+
+		if errors.Is(rErr, io.EOF) {
+			break
+		}
+		if rErr != nil {
+			return fmt.Errorf("error reading data: %w", rErr)
+		}
+	}
+
+	lpr.reportProgress(true)
+
+	lpr.ReceivedSize = lpr.processedDataBytes
+
+	if lpr.hash != nil {
+		lpr.Checksum = lpr.hash.Sum(nil)
+		logDebugf("Checksum of data file: %x", lpr.Checksum)
+	}
+
+	lpr.inspectDataFile()
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package lprlib
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemonRaw(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+	rawPort := uint16(2348)
+
+	text := strings.Repeat("Text for the file\n", 1000) + "\x00"
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.RawQueue = "jetdirect"
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	err = lprd.InitRaw(rawPort, "")
+	require.Nil(t, err)
+
+	err = SendRaw(name, "127.0.0.1", rawPort, time.Minute)
+	require.Nil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "jetdirect", conn.PrqName)
+	require.Equal(t, "Raw", conn.connectionType.String())
+	require.Equal(t, uint64(len(text)), conn.ReceivedSize)
+
+	data, err := os.ReadFile(conn.SaveName)
+	require.Nil(t, err)
+	require.Equal(t, text, string(data))
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// LPR jobs are still received
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "raw", conn.PrqName)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// connections without data are no jobs
+	socket, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", rawPort))
+	require.Nil(t, err)
+	require.Nil(t, socket.Close())
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "", conn.SaveName)
+
+	lprd.Close()
+
+	_, ok := <-lprd.FinishedConnections()
+	require.False(t, ok)
+
+	// the raw listener was closed as well
+	_, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", rawPort))
+	require.NotNil(t, err)
+
+	require.NotNil(t, lprd.InitRaw(rawPort, ""))
+}
@@ -0,0 +1,95 @@
+package lprlib
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startTestRawPrinter accepts one connection on the given port and returns the received data.
+func startTestRawPrinter(t *testing.T, port uint16) chan string {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.Nil(t, err)
+
+	received := make(chan string, 1)
+	go func() {
+		defer listener.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+
+		data, err := io.ReadAll(conn)
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		received <- string(data)
+	}()
+
+	return received
+}
+
+func TestSendRaw(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := strings.Repeat("Text for the file\n", 1000)
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	received := startTestRawPrinter(t, port)
+
+	progress := []int64{}
+	err = SendRaw(name, "127.0.0.1", port, time.Minute, WithProgress(func(bytesSent, totalBytes int64) {
+		require.Equal(t, int64(len(text)), totalBytes)
+		progress = append(progress, bytesSent)
+	}))
+	require.Nil(t, err)
+	require.Equal(t, text, <-received)
+	require.Equal(t, int64(len(text)), progress[len(progress)-1])
+
+	received = startTestRawPrinter(t, port)
+
+	err = SendRawStream(strings.NewReader("Text of the stream"), -1, "127.0.0.1", port, time.Minute)
+	require.Nil(t, err)
+	require.Equal(t, "Text of the stream", <-received)
+
+	// nobody is listening
+	err = SendRaw(name, "127.0.0.1", port, time.Minute)
+	require.NotNil(t, err)
+}
+
+func TestSendRawWithRetry(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	// the printer is started after the first attempt
+	started := make(chan chan string)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		started <- startTestRawPrinter(t, port)
+	}()
+
+	policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: 200 * time.Millisecond}
+	err = SendRawWithRetry(name, "127.0.0.1", port, time.Minute, policy)
+	require.Nil(t, err)
+	require.Equal(t, "Text for the file", <-<-started)
+}
@@ -0,0 +1,569 @@
+package lprlib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultRelayTimeout is the timeout of forwarding a job to a RelayTarget, if RelayTarget.Timeout is not set.
+const DefaultRelayTimeout = 5 * time.Minute
+
+// RelayProtocol is the protocol a Relay uses to forward jobs to a RelayTarget.
+type RelayProtocol string
+
+const (
+	// RelayLPR forwards the jobs to an LPD server (see Send)
+	RelayLPR RelayProtocol = "lpr"
+
+	// RelayRaw forwards the data files to a raw printer port, e.g. 9100 (see SendRaw)
+	RelayRaw RelayProtocol = "raw"
+
+	// RelayIPP forwards the jobs to an IPP printer (see IppClient)
+	RelayIPP RelayProtocol = "ipp"
+)
+
+// RelayTarget is a printer a Relay forwards jobs to.
+type RelayTarget struct {
+	// Name identifies the target in logs. If empty, the address of the target is used.
+	Name string
+
+	// Protocol is the protocol used to forward the jobs. If empty, RelayLPR is used.
+	Protocol RelayProtocol
+
+	// Hostname and Port are the address of an LPR or raw target. If the Port is 0,
+	// the default port of the protocol is used.
+	Hostname string
+	Port     uint16
+
+	// Queue is the queue of an LPR target. If empty, the queue the job was sent to is used.
+	Queue string
+
+	// PrinterURI is the URI of an IPP target, e.g. ipp://printer/ipp/print.
+	PrinterURI string
+
+	// Queues contains the queues whose jobs are forwarded to the target.
+	// If empty, the jobs of all queues are forwarded.
+	Queues []string
+
+	// Timeout limits forwarding a job to the target. If 0, DefaultRelayTimeout is used.
+	Timeout time.Duration
+
+	// Options are applied after the options taken from the control file of the job,
+	// e.g. to connect using TLS or to override the format. They are not used for IPP targets.
+	Options []SendOption
+}
+
+// String returns the Name or the address of the target.
+func (t *RelayTarget) String() string {
+	if t.Name != "" {
+		return t.Name
+	}
+
+	switch t.protocol() {
+	case RelayRaw:
+		return "raw://" + net.JoinHostPort(t.Hostname, strconv.Itoa(int(t.Port)))
+	case RelayIPP:
+		return t.PrinterURI
+	default:
+		return "lpr://" + net.JoinHostPort(t.Hostname, strconv.Itoa(int(t.Port))) + "/" + t.Queue
+	}
+}
+
+// protocol returns the Protocol or RelayLPR.
+func (t *RelayTarget) protocol() RelayProtocol {
+	if t.Protocol == "" {
+		return RelayLPR
+	}
+
+	return t.Protocol
+}
+
+// accepts tells if the jobs of the queue are forwarded to the target.
+func (t *RelayTarget) accepts(queue string) bool {
+	return len(t.Queues) == 0 || containsString(t.Queues, queue)
+}
+
+// timeout returns the Timeout or DefaultRelayTimeout.
+func (t *RelayTarget) timeout() time.Duration {
+	if t.Timeout <= 0 {
+		return DefaultRelayTimeout
+	}
+
+	return t.Timeout
+}
+
+// RelayDelivery is the state of the delivery of a spooled job to a RelayTarget.
+type RelayDelivery struct {
+	// Delivered is the time the job was forwarded to the target (zero while it wasn't)
+	Delivered time.Time `json:"delivered,omitempty"`
+
+	// Attempts is the number of attempts to forward the job to the target
+	Attempts int `json:"attempts"`
+
+	// LastError is the error of the last failed attempt
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Relay forwards the jobs received by an LprDaemon to one or more target printers, e.g. to run
+// a print gateway. Jobs forwarded over LPR keep the metadata of their control file (e.g. user,
+// host, job name, format and copies), IPP targets receive the user, job name and number of copies.
+//
+// If a SpoolDir is set, the relay stores and forwards the jobs: the received jobs are spooled
+// (see Spooler) and kept until they were delivered to all of their targets, so they survive
+// outages of the targets and restarts of the process. Failed deliveries are retried according
+// to the Retry policy, targets which already received a job don't receive it again.
+type Relay struct {
+	// Daemon is the daemon whose FinishedConnections are forwarded by Run.
+	// If it compresses the data files, its Compressor must be a Decompressor,
+	// as the data files are forwarded uncompressed.
+	Daemon *LprDaemon
+
+	// Targets are the printers the jobs are forwarded to. Each job is forwarded to all targets
+	// accepting its queue (see RelayTarget.Queues).
+	Targets []RelayTarget
+
+	// OnForwarded is called by Run after a job was forwarded to its targets,
+	// err is the error of the failed targets (nil if the job was forwarded to all targets).
+	// The data file of the job is removed after OnForwarded returned.
+	// It is not called if a SpoolDir is set (see OnDelivery).
+	OnForwarded func(conn *LprConnection, err error)
+
+	// SpoolDir is the spool directory storing the jobs until they are delivered.
+	// If empty, the jobs are forwarded once and dropped if forwarding fails.
+	SpoolDir string
+
+	// Retry configures how failed deliveries of spooled jobs are retried.
+	// If MaxAttempts is 0, the jobs are retried until they were delivered to all targets,
+	// otherwise they are kept with the state SpoolJobFailed (see Jobs and Remove).
+	Retry RetryPolicy
+
+	// OnDelivery is called after each attempt to deliver a spooled job to a target.
+	OnDelivery func(job SpoolJob, target *RelayTarget, delivery RelayDelivery)
+
+	// Store stores the metadata and delivery states of the spooled jobs (see Spooler.Store).
+	Store JobStore
+
+	// Priority, QueuePriorities and PriorityAging configure the order the spooled jobs are delivered in
+	// (see Spooler.Priority).
+	Priority        func(job SpoolJob) int
+	QueuePriorities map[string]int
+	PriorityAging   time.Duration
+
+	spoolerOnce sync.Once
+	spooler     *Spooler
+}
+
+// Run forwards the jobs received by the Daemon one after another until ctx is done.
+// Connections which are no successfully received jobs (e.g. queue state requests) are ignored.
+// If a SpoolDir is set, the jobs are spooled and the spooled jobs (including the ones
+// left by a previous run) are delivered until ctx is done.
+func (r *Relay) Run(ctx context.Context) error {
+	if r.Daemon == nil {
+		return errors.New("relay daemon is not set")
+	}
+	if len(r.Targets) == 0 {
+		return errors.New("relay has no targets")
+	}
+	if _, ok := r.Daemon.Compressor.(Decompressor); r.Daemon.Compressor != nil && !ok {
+		return fmt.Errorf("relay can't forward data files compressed by %T, as it is no Decompressor", r.Daemon.Compressor)
+	}
+
+	if r.SpoolDir != "" {
+		return r.spool().Run(ctx)
+	}
+
+	connections := r.Daemon.FinishedConnections()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case conn, ok := <-connections:
+			if !ok {
+				<-ctx.Done()
+				return nil
+			}
+
+			if conn.Status == End && (conn.SaveName != "" || conn.Data != nil) {
+				err := r.Forward(ctx, conn)
+				if r.OnForwarded != nil {
+					r.OnForwarded(conn, err)
+				}
+			}
+
+			if conn.SaveName != "" {
+				os.Remove(conn.SaveName)
+			}
+			conn.Release()
+		}
+	}
+}
+
+// Forward forwards the job received by the connection to all targets accepting its queue.
+// If forwarding to a target fails, the other targets are tried nevertheless and the error
+// of the first failed target is returned.
+func (r *Relay) Forward(ctx context.Context, conn *LprConnection) error {
+	job := newSpoolJob(conn)
+
+	return r.forward(ctx, job, func() (io.ReadCloser, error) {
+		if conn.SaveName == "" {
+			return io.NopCloser(bytes.NewReader(conn.Data)), nil
+		}
+		return os.Open(conn.SaveName)
+	})
+}
+
+// Jobs returns the spooled jobs of the given queue (all jobs if empty) with their delivery states
+// (see SpoolJob.Deliveries). The jobs are only spooled if a SpoolDir is set.
+func (r *Relay) Jobs(queue string) ([]SpoolJob, error) {
+	if r.SpoolDir == "" {
+		return nil, errors.New("relay spool directory is not set")
+	}
+
+	return r.spool().Jobs(queue)
+}
+
+// Remove removes the spooled job with the given ID, e.g. a job which failed too often.
+func (r *Relay) Remove(id uint64) error {
+	if r.SpoolDir == "" {
+		return errors.New("relay spool directory is not set")
+	}
+
+	return r.spool().Remove(id)
+}
+
+// Pause stops delivering the spooled jobs of the queue until it is resumed (see Spooler.Pause).
+func (r *Relay) Pause(queue string) error {
+	if r.SpoolDir == "" {
+		return errors.New("relay spool directory is not set")
+	}
+
+	r.spool().Pause(queue)
+	return nil
+}
+
+// Resume continues delivering the spooled jobs of a paused queue (see Spooler.Resume).
+func (r *Relay) Resume(queue string) error {
+	if r.SpoolDir == "" {
+		return errors.New("relay spool directory is not set")
+	}
+
+	r.spool().Resume(queue)
+	return nil
+}
+
+// spool returns the spooler of the SpoolDir.
+func (r *Relay) spool() *Spooler {
+	r.spoolerOnce.Do(func() {
+		r.spooler = &Spooler{
+			Dir:             r.SpoolDir,
+			Daemon:          r.Daemon,
+			Retry:           r.Retry,
+			Store:           r.Store,
+			Priority:        r.Priority,
+			QueuePriorities: r.QueuePriorities,
+			PriorityAging:   r.PriorityAging,
+		}
+		r.spooler.Processor = r.deliverSpooled
+	})
+
+	return r.spooler
+}
+
+// deliverSpooled forwards the spooled job to the targets accepting its queue, which didn't receive it yet.
+// The delivery states are stored with the job, so the job is retried for the failed targets only.
+func (r *Relay) deliverSpooled(ctx context.Context, job SpoolJob) error {
+	var firstErr error
+	failed := 0
+	targets := 0
+
+	for i := range r.Targets {
+		target := &r.Targets[i]
+		if !target.accepts(job.Queue) {
+			continue
+		}
+		targets++
+
+		name := target.String()
+		delivery := job.Deliveries[name]
+		if !delivery.Delivered.IsZero() {
+			continue
+		}
+
+		err := ctx.Err()
+		if err == nil {
+			err = r.deliver(target, &job, func() (io.ReadCloser, error) {
+				return os.Open(job.DataFile)
+			})
+		}
+		if ctx.Err() != nil {
+			// the delivery was interrupted and is retried by the next run
+			return ctx.Err()
+		}
+
+		delivery.Attempts++
+		delivery.LastError = ""
+		if err != nil {
+			logErrorf("Error delivering spooled job %d to %s: %v", job.ID, name, err)
+			delivery.LastError = err.Error()
+			failed++
+			if firstErr == nil {
+				firstErr = fmt.Errorf("error delivering job to %s: %w", name, err)
+			}
+		} else {
+			logDebugf("Delivered spooled job %d to %s", job.ID, name)
+			delivery.Delivered = time.Now()
+		}
+
+		job.Deliveries = withDelivery(job.Deliveries, name, delivery)
+		uErr := r.spooler.update(job.ID, func(job *SpoolJob) {
+			job.Deliveries = withDelivery(job.Deliveries, name, delivery)
+		})
+		if uErr != nil {
+			logErrorf("Error storing delivery state of spooled job %d: %v", job.ID, uErr)
+		}
+
+		if r.OnDelivery != nil {
+			r.OnDelivery(job, target, delivery)
+		}
+	}
+
+	if failed > 1 {
+		return fmt.Errorf("delivering job to %d targets failed, first %w", failed, firstErr)
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	if targets == 0 {
+		return fmt.Errorf("no relay target for queue %s", job.Queue)
+	}
+
+	return nil
+}
+
+// withDelivery returns a copy of the delivery states containing the delivery state of the target.
+// The states are copied, as the copies of a spooled job share them.
+func withDelivery(deliveries map[string]RelayDelivery, target string, delivery RelayDelivery) map[string]RelayDelivery {
+	copied := make(map[string]RelayDelivery, len(deliveries)+1)
+	for name, state := range deliveries {
+		copied[name] = state
+	}
+	copied[target] = delivery
+
+	return copied
+}
+
+// forward forwards the job to all targets accepting its queue, open opens the data file of the job.
+func (r *Relay) forward(ctx context.Context, job *SpoolJob, open func() (io.ReadCloser, error)) error {
+	var firstErr error
+	failed := 0
+	forwarded := 0
+
+	for i := range r.Targets {
+		target := &r.Targets[i]
+		if !target.accepts(job.Queue) {
+			continue
+		}
+
+		err := ctx.Err()
+		if err == nil {
+			err = r.deliver(target, job, open)
+		}
+		if err != nil {
+			logErrorf("Error forwarding job of queue %s to %s: %v", job.Queue, target, err)
+			failed++
+			if firstErr == nil {
+				firstErr = fmt.Errorf("error forwarding job to %s: %w", target, err)
+			}
+			continue
+		}
+
+		logDebugf("Forwarded job of queue %s to %s", job.Queue, target)
+		forwarded++
+	}
+
+	if failed > 1 {
+		return fmt.Errorf("forwarding job to %d targets failed, first %w", failed, firstErr)
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	if forwarded == 0 {
+		return fmt.Errorf("no relay target for queue %s", job.Queue)
+	}
+
+	return nil
+}
+
+// openData opens the data file of the job using open and decompresses it, if it is compressed,
+// so the data matches the uncompressed Size of the job.
+func (r *Relay) openData(job *SpoolJob, open func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	var decompressor Decompressor
+	if job.Compression != "" {
+		compressor := r.Daemon.Compressor
+		decompressor, _ = compressor.(Decompressor)
+		if decompressor == nil || compressor.Extension() != job.Compression {
+			return nil, fmt.Errorf("no Decompressor for data files compressed as %s", job.Compression)
+		}
+	}
+
+	file, err := open()
+	if err != nil {
+		return nil, fmt.Errorf("error opening data file: %w", err)
+	}
+	if decompressor == nil {
+		return file, nil
+	}
+
+	reader, err := decompressor.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error decompressing data file: %w", err)
+	}
+
+	return &decompressedFile{ReadCloser: reader, file: file}, nil
+}
+
+// decompressedFile reads a decompressed data file and closes the file and its decompressor.
+type decompressedFile struct {
+	io.ReadCloser
+	file io.Closer
+}
+
+func (d *decompressedFile) Close() error {
+	err := d.ReadCloser.Close()
+	if cErr := d.file.Close(); err == nil {
+		err = cErr
+	}
+
+	return err
+}
+
+// deliver sends the job to the target.
+func (r *Relay) deliver(target *RelayTarget, job *SpoolJob, open func() (io.ReadCloser, error)) error {
+	data, err := r.openData(job, open)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	size := int64(job.Size)
+
+	switch target.protocol() {
+	case RelayLPR:
+		queue := target.Queue
+		if queue == "" {
+			queue = job.Queue
+		}
+		name, opts := job.controlFileOptions()
+		opts = append(opts, target.Options...)
+		user := clipControlValue(job.User, maxUserLength)
+		return SendStream(data, size, name, target.Hostname, target.Port, queue, user, target.timeout(), opts...)
+
+	case RelayRaw:
+		return SendRawStream(data, size, target.Hostname, target.Port, target.timeout(), target.Options...)
+
+	case RelayIPP:
+		client := IppClient{PrinterURI: target.PrinterURI, Username: job.User, Timeout: target.timeout()}
+		if job.DetectedFormat != "" && job.DetectedFormat != DocumentFormatUnknown {
+			client.DocumentFormat = string(job.DetectedFormat)
+		}
+		name := job.JobName
+		if name == "" {
+			name = job.FileName
+		}
+		_, err = client.Print(data, name, job.copies())
+		return err
+
+	default:
+		return fmt.Errorf("unknown relay protocol %q", target.Protocol)
+	}
+}
+
+// controlFileOptions returns the name of the source file and the options reproducing
+// the control file of the job. As the daemon accepts values of any length, the values are
+// truncated to the limits of RFC-1179 and their control characters are removed (see clipControlValue),
+// otherwise the job could never be forwarded.
+func (job *SpoolJob) controlFileOptions() (string, []SendOption) {
+	clip := func(command byte, value string) string {
+		return clipControlValue(value, controlValueNames[command].maxLength)
+	}
+
+	name := job.FileName
+	opts := []SendOption{}
+
+	host := job.Host
+	cf := job.ControlFile
+	if cf != nil && cf.Host != "" {
+		host = cf.Host
+	}
+	host = clip('H', host)
+	if host != "" {
+		opts = append(opts, func(lpr *LprSend) {
+			lpr.Config['H'] = host
+		})
+	}
+
+	if job.JobName != "" {
+		opts = append(opts, WithJobName(clip('J', job.JobName)))
+	}
+
+	if cf == nil {
+		return clip('N', name), opts
+	}
+
+	if cf.SourceFileName != "" {
+		name = cf.SourceFileName
+	}
+	if cf.Title != "" {
+		opts = append(opts, WithTitle(clip('T', cf.Title)))
+	}
+	if cf.Class != "" {
+		opts = append(opts, WithClass(clip('C', cf.Class)))
+	}
+	if cf.PrintBanner {
+		opts = append(opts, WithBanner(clip('L', cf.BannerUser)))
+	}
+	if cf.Indent > 0 {
+		opts = append(opts, WithIndent(int(cf.Indent)))
+	}
+	if cf.Width > 0 {
+		opts = append(opts, WithWidth(int(cf.Width)))
+	}
+	if cf.MailUser != "" {
+		opts = append(opts, WithMailTo(clip('M', cf.MailUser)))
+	}
+	if len(cf.PrintFiles) > 0 {
+		opts = append(opts, WithFormat(Format(cf.PrintFiles[0].Format)))
+	}
+	if copies := job.copies(); copies > 1 {
+		opts = append(opts, WithCopies(copies))
+	}
+
+	return clip('N', name), opts
+}
+
+// copies returns the number of copies of the job, which is the number of print commands
+// of the control file referencing the (first) data file.
+func (job *SpoolJob) copies() int {
+	if job.ControlFile == nil || len(job.ControlFile.PrintFiles) == 0 {
+		return 1
+	}
+
+	copies := 0
+	for _, file := range job.ControlFile.PrintFiles {
+		if file.FileName == job.ControlFile.PrintFiles[0].FileName {
+			copies++
+		}
+	}
+
+	return copies
+}
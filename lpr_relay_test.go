@@ -0,0 +1,323 @@
+package lprlib
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelay(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+	targetPort := uint16(2350)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	var target LprDaemon
+	target.InputFileSaveDir = t.TempDir()
+	err = target.Init(targetPort, "")
+	require.Nil(t, err)
+
+	uri, requests := startTestIppPrinter(t, 0)
+
+	forwarded := make(chan error, 10)
+	relay := &Relay{
+		Daemon: &lprd,
+		Targets: []RelayTarget{
+			{Hostname: "127.0.0.1", Port: targetPort, Queue: "printer"},
+			{Protocol: RelayIPP, PrinterURI: uri, Queues: []string{"ipp"}},
+		},
+		OnForwarded: func(conn *LprConnection, err error) {
+			forwarded <- err
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	finished := make(chan error)
+	go func() {
+		finished <- relay.Run(ctx)
+	}()
+
+	// the metadata of the control file is forwarded
+	text := "%!PS\nshowpage\n"
+	err = SendStream(strings.NewReader(text), int64(len(text)), "document.ps", "127.0.0.1", port, "raw", "TestUser", time.Minute,
+		WithJobName("TestJob"), WithTitle("TestTitle"), WithFormat(FormatPostScript), WithCopies(2))
+	require.Nil(t, err)
+	require.Nil(t, <-forwarded)
+
+	conn := <-target.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "printer", conn.PrqName)
+	require.Equal(t, "TestUser", conn.UserIdentification)
+	require.Equal(t, "TestJob", conn.JobName)
+	require.Equal(t, "document.ps", conn.ControlFile.SourceFileName)
+	require.Equal(t, "TestTitle", conn.ControlFile.Title)
+	require.Equal(t, 2, len(conn.ControlFile.PrintFiles))
+	require.Equal(t, byte(FormatPostScript), conn.ControlFile.PrintFiles[0].Format)
+
+	data, err := os.ReadFile(conn.SaveName)
+	require.Nil(t, err)
+	require.Equal(t, text, string(data))
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// jobs of the ipp queue are forwarded to both targets
+	err = SendStream(strings.NewReader("Text for the file"), 17, "file.txt", "127.0.0.1", port, "ipp", "TestUser", time.Minute, WithJobName("IppJob"))
+	require.Nil(t, err)
+	require.Nil(t, <-forwarded)
+
+	request := <-requests
+	require.Equal(t, []string{"TestUser"}, request.attributes["requesting-user-name"])
+	require.Equal(t, []string{"IppJob"}, request.attributes["job-name"])
+	require.Equal(t, "Text for the file", request.data)
+
+	conn = <-target.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "printer", conn.PrqName)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// the error of an unreachable target is reported
+	target.Close()
+	err = SendStream(strings.NewReader("Text for the file"), 17, "file.txt", "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	require.NotNil(t, <-forwarded)
+
+	cancel()
+	require.Nil(t, <-finished)
+
+	// the data files of the forwarded jobs are removed
+	entries, err := os.ReadDir(lprd.InputFileSaveDir)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(entries))
+}
+
+func TestRelayStoreAndForward(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+	targetPort := uint16(2350)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	uri, requests := startTestIppPrinter(t, 0)
+
+	deliveries := make(chan RelayDelivery, 100)
+	relay := &Relay{
+		Daemon: &lprd,
+		Targets: []RelayTarget{
+			{Name: "office", Hostname: "127.0.0.1", Port: targetPort, Queue: "printer", Timeout: time.Second},
+			{Name: "ipp", Protocol: RelayIPP, PrinterURI: uri},
+		},
+		SpoolDir: t.TempDir(),
+		Retry:    RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond},
+		OnDelivery: func(job SpoolJob, target *RelayTarget, delivery RelayDelivery) {
+			deliveries <- delivery
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error)
+	go func() {
+		finished <- relay.Run(ctx)
+	}()
+
+	// the office printer is offline, the job is kept until it was delivered
+	err = SendStream(strings.NewReader("Text for the file"), 17, "file.txt", "127.0.0.1", port, "raw", "TestUser", time.Minute, WithJobName("TestJob"))
+	require.Nil(t, err)
+
+	request := <-requests
+	require.Equal(t, "Text for the file", request.data)
+
+	require.Eventually(t, func() bool {
+		jobs, err := relay.Jobs("raw")
+		return err == nil && len(jobs) == 1 && jobs[0].Deliveries["office"].Attempts >= 2
+	}, 10*time.Second, 10*time.Millisecond)
+
+	jobs, err := relay.Jobs("raw")
+	require.Nil(t, err)
+	require.Equal(t, SpoolJobPending, jobs[0].State)
+	require.False(t, jobs[0].Deliveries["ipp"].Delivered.IsZero())
+	require.Equal(t, 1, jobs[0].Deliveries["ipp"].Attempts)
+	require.True(t, jobs[0].Deliveries["office"].Delivered.IsZero())
+	require.NotEmpty(t, jobs[0].Deliveries["office"].LastError)
+
+	// once the office printer is online, the job is delivered to it only
+	var target LprDaemon
+	target.InputFileSaveDir = t.TempDir()
+	err = target.Init(targetPort, "")
+	require.Nil(t, err)
+	defer target.Close()
+
+	conn := <-target.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "printer", conn.PrqName)
+	require.Equal(t, "TestJob", conn.JobName)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	require.Eventually(t, func() bool {
+		jobs, err := relay.Jobs("")
+		return err == nil && len(jobs) == 0
+	}, 10*time.Second, 10*time.Millisecond)
+	require.Equal(t, 0, len(requests))
+
+	cancel()
+	require.Nil(t, <-finished)
+
+	delivered := 0
+	for len(deliveries) > 0 {
+		if delivery := <-deliveries; !delivery.Delivered.IsZero() {
+			delivered++
+		}
+	}
+	require.Equal(t, 2, delivered)
+
+	entries, err := os.ReadDir(relay.SpoolDir)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(entries))
+}
+
+func TestRelayCompressed(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+	targetPort := uint16(2350)
+
+	var target LprDaemon
+	target.InputFileSaveDir = t.TempDir()
+	err := target.Init(targetPort, "")
+	require.Nil(t, err)
+	defer target.Close()
+
+	for _, spoolDir := range []string{"", t.TempDir()} {
+		var lprd LprDaemon
+		lprd.InputFileSaveDir = t.TempDir()
+		lprd.Compressor = GzipCompressor{}
+		err = lprd.Init(port, "")
+		require.Nil(t, err)
+
+		relay := &Relay{
+			Daemon:   &lprd,
+			Targets:  []RelayTarget{{Hostname: "127.0.0.1", Port: targetPort, Queue: "printer"}},
+			SpoolDir: spoolDir,
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		finished := make(chan error)
+		go func() {
+			finished <- relay.Run(ctx)
+		}()
+
+		// the target receives the uncompressed data file
+		text := strings.Repeat("Text for the file\n", 100)
+		err = SendStream(strings.NewReader(text), int64(len(text)), "file.txt", "127.0.0.1", port, "raw", "TestUser", time.Minute)
+		require.Nil(t, err)
+
+		conn := <-target.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+		require.Equal(t, uint64(len(text)), conn.ReceivedSize)
+
+		data, err := os.ReadFile(conn.SaveName)
+		require.Nil(t, err)
+		require.Equal(t, text, string(data))
+		require.Nil(t, os.Remove(conn.SaveName))
+
+		cancel()
+		require.Nil(t, <-finished)
+		lprd.Close()
+	}
+}
+
+// writeOnlyCompressor is a Compressor which is no Decompressor.
+type writeOnlyCompressor struct{}
+
+func (w writeOnlyCompressor) Extension() string {
+	return ".gz"
+}
+
+func (w writeOnlyCompressor) NewWriter(writer io.Writer) (io.WriteCloser, error) {
+	return GzipCompressor{}.NewWriter(writer)
+}
+
+func TestRelayCompressorWithoutDecompressor(t *testing.T) {
+	var lprd LprDaemon
+	lprd.Compressor = writeOnlyCompressor{}
+
+	relay := &Relay{
+		Daemon:  &lprd,
+		Targets: []RelayTarget{{Hostname: "127.0.0.1", Port: 2350}},
+	}
+
+	err := relay.Run(context.Background())
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "Decompressor")
+}
+
+func TestRelayLongControlFileValues(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+	ippPort := uint16(2349)
+	targetPort := uint16(2350)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+	err = lprd.InitIpp(ippPort, "")
+	require.Nil(t, err)
+
+	var target LprDaemon
+	target.InputFileSaveDir = t.TempDir()
+	err = target.Init(targetPort, "")
+	require.Nil(t, err)
+	defer target.Close()
+
+	forwarded := make(chan error, 10)
+	relay := &Relay{
+		Daemon:  &lprd,
+		Targets: []RelayTarget{{Hostname: "127.0.0.1", Port: targetPort, Queue: "printer"}},
+		OnForwarded: func(conn *LprConnection, err error) {
+			forwarded <- err
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	finished := make(chan error)
+	go func() {
+		finished <- relay.Run(ctx)
+	}()
+
+	// IPP allows job names and user names exceeding the limits of RFC-1179, they are truncated
+	jobName := strings.Repeat("Long job name ", 10)
+	user := strings.Repeat("User", 10)
+	client := IppClient{PrinterURI: "ipp://127.0.0.1:2349/printers/office", Username: user, Timeout: time.Minute}
+	_, err = client.Print(strings.NewReader("Text for the file"), jobName, 1)
+	require.Nil(t, err)
+	require.Nil(t, <-forwarded)
+
+	conn := <-target.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, jobName[:99], conn.JobName)
+	require.Equal(t, user[:31], conn.UserIdentification)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	cancel()
+	require.Nil(t, <-finished)
+}
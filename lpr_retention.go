@@ -0,0 +1,160 @@
+package lprlib
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+// RetentionReason describes why the files of a job were purged.
+type RetentionReason int
+
+const (
+	// RetentionReasonTTL means that the job was registered longer than the TTL.
+	RetentionReasonTTL RetentionReason = 0
+
+	// RetentionReasonCount means that more than MaxJobs jobs were registered.
+	RetentionReasonCount RetentionReason = 1
+)
+
+// RetentionEvent describes the purge of the files of a job.
+type RetentionEvent struct {
+	// Job is the registered job
+	Job *LprConnection
+
+	// Files contains the removed files
+	Files []string
+
+	// Reason tells why the job was purged
+	Reason RetentionReason
+
+	// Err is set if (some of) the files could not be removed
+	Err error
+}
+
+// RetentionManager removes the saved files of received jobs, once they were registered longer
+// than TTL or once more than MaxJobs jobs are registered (the oldest jobs are removed first).
+type RetentionManager struct {
+	// TTL is the time after which the files of a registered job are removed.
+	// If 0, files are not removed because of their age.
+	TTL time.Duration
+
+	// MaxJobs is the maximum number of registered jobs.
+	// If 0, the number of jobs is not limited.
+	MaxJobs int
+
+	// Interval is the time between two checks for expired jobs done by Run.
+	// If 0, TTL is used.
+	Interval time.Duration
+
+	// OnPurge will be called after the files of a job were removed.
+	OnPurge func(event RetentionEvent)
+
+	mutex sync.Mutex
+	jobs  []retainedJob
+}
+
+type retainedJob struct {
+	job        *LprConnection
+	registered time.Time
+}
+
+// Register adds a finished job, whose files (SaveName and ControlFileSaveName) should be removed
+// according to the retention policy.
+func (r *RetentionManager) Register(job *LprConnection) {
+	r.mutex.Lock()
+	r.jobs = append(r.jobs, retainedJob{job: job, registered: time.Now()})
+
+	var purged []retainedJob
+	if r.MaxJobs > 0 && len(r.jobs) > r.MaxJobs {
+		count := len(r.jobs) - r.MaxJobs
+		purged = append(purged, r.jobs[:count]...)
+		r.jobs = r.jobs[count:]
+	}
+	r.mutex.Unlock()
+
+	r.purge(purged, RetentionReasonCount)
+}
+
+// Len returns the number of registered jobs.
+func (r *RetentionManager) Len() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return len(r.jobs)
+}
+
+// Purge removes the files of all jobs registered longer than TTL.
+func (r *RetentionManager) Purge() {
+	if r.TTL <= 0 {
+		return
+	}
+
+	deadline := time.Now().Add(-r.TTL)
+
+	r.mutex.Lock()
+	count := 0
+	for count < len(r.jobs) && !r.jobs[count].registered.After(deadline) {
+		count++
+	}
+	purged := append([]retainedJob{}, r.jobs[:count]...)
+	r.jobs = r.jobs[count:]
+	r.mutex.Unlock()
+
+	r.purge(purged, RetentionReasonTTL)
+}
+
+// Run purges expired jobs every Interval until ctx is done.
+func (r *RetentionManager) Run(ctx context.Context) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = r.TTL
+	}
+	if interval <= 0 {
+		<-ctx.Done()
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Purge()
+		}
+	}
+}
+
+func (r *RetentionManager) purge(jobs []retainedJob, reason RetentionReason) {
+	for _, retained := range jobs {
+		event := RetentionEvent{
+			Job:    retained.job,
+			Files:  []string{},
+			Reason: reason,
+		}
+
+		for _, fileName := range []string{retained.job.SaveName, retained.job.ControlFileSaveName} {
+			if fileName == "" {
+				continue
+			}
+
+			err := os.Remove(fileName)
+			if err != nil && !os.IsNotExist(err) {
+				logErrorf("Error removing file %s: %v", fileName, err)
+				event.Err = err
+				continue
+			}
+
+			logDebugf("Removed file %s", fileName)
+			event.Files = append(event.Files, fileName)
+		}
+
+		if r.OnPurge != nil {
+			r.OnPurge(event)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package lprlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetentionManager(t *testing.T) {
+	dir := t.TempDir()
+
+	newJob := func(name string) *LprConnection {
+		fileName := filepath.Join(dir, name)
+		require.Nil(t, os.WriteFile(fileName, []byte("data"), 0600))
+		require.Nil(t, os.WriteFile(fileName+".cf", []byte("Puser\n"), 0600))
+		return &LprConnection{SaveName: fileName, ControlFileSaveName: fileName + ".cf"}
+	}
+
+	events := []RetentionEvent{}
+	retention := RetentionManager{
+		TTL:     time.Hour,
+		MaxJobs: 2,
+		OnPurge: func(event RetentionEvent) {
+			events = append(events, event)
+		},
+	}
+
+	job1 := newJob("job1")
+	job2 := newJob("job2")
+	job3 := newJob("job3")
+
+	retention.Register(job1)
+	retention.Register(job2)
+	require.Empty(t, events)
+
+	// the count limit removes the oldest job
+	retention.Register(job3)
+	require.Equal(t, 2, retention.Len())
+	require.Len(t, events, 1)
+	require.Equal(t, job1, events[0].Job)
+	require.Equal(t, RetentionReasonCount, events[0].Reason)
+	require.Nil(t, events[0].Err)
+	require.Equal(t, []string{job1.SaveName, job1.ControlFileSaveName}, events[0].Files)
+
+	_, err := os.Stat(job1.SaveName)
+	require.True(t, os.IsNotExist(err))
+
+	// nothing expired yet
+	retention.Purge()
+	require.Len(t, events, 1)
+
+	// all remaining jobs expired
+	retention.TTL = time.Nanosecond
+	retention.Purge()
+	require.Equal(t, 0, retention.Len())
+	require.Len(t, events, 3)
+	require.Equal(t, RetentionReasonTTL, events[2].Reason)
+
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	require.Empty(t, entries)
+}
@@ -0,0 +1,121 @@
+package lprlib
+
+import (
+	"errors"
+	"time"
+)
+
+// Defaults of the RetryPolicy
+const (
+	DefaultRetryInitialBackoff = time.Second
+	DefaultRetryMultiplier     = 2
+)
+
+// RetryPolicy configures how SendWithRetry retries failed attempts.
+// Only attempts which failed to connect or timed out are retried, and only as long as the printer
+// did not acknowledge a control or data file, to make sure that a job is never printed twice.
+// Jobs interrupted later are only retransmitted if confirmed by Retransmit.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the first one.
+	// If 0 or 1, the job is not retried.
+	MaxAttempts int
+
+	// InitialBackoff is the time to wait before the first retry.
+	// If 0, DefaultRetryInitialBackoff is used.
+	InitialBackoff time.Duration
+
+	// MaxBackoff is the maximum time to wait between two attempts.
+	// If 0, the time is not limited.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor the backoff is multiplied with after each retry.
+	// If 0, DefaultRetryMultiplier is used.
+	Multiplier float64
+
+	// Retransmit is called if the connection failed after the printer acknowledged a control
+	// or data file, e.g. if the connection dropped while sending the data file. bytesSent is the
+	// number of bytes of the data file sent in the failed attempt. If it returns true, the whole
+	// job is retransmitted from the beginning, which may print the job twice if the printer
+	// kept the interrupted job. If nil, interrupted jobs are not retransmitted.
+	Retransmit func(attempt int, bytesSent int64, err error) bool
+}
+
+// backoff returns the time to wait before the given retry (starting with 1).
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	backoff := p.InitialBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryInitialBackoff
+	}
+
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = DefaultRetryMultiplier
+	}
+
+	for i := 1; i < retry; i++ {
+		backoff = time.Duration(float64(backoff) * multiplier)
+		if p.MaxBackoff > 0 && backoff >= p.MaxBackoff {
+			break
+		}
+	}
+
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+
+	return backoff
+}
+
+// retryable tells if a failed attempt of the LprSend may be retried:
+// the printer asked to retry later (see PrinterNackError.Temporary), or it failed to connect
+// or timed out before the printer acknowledged a control or data file.
+func (lpr *LprSend) retryable(err error) bool {
+	var nackErr *PrinterNackError
+	if errors.As(err, &nackErr) {
+		return nackErr.Temporary()
+	}
+
+	return !lpr.fileAcked && (!lpr.connected || lpr.timedOut)
+}
+
+// retransmittable tells if a job interrupted by a failed connection after the printer
+// acknowledged a control or data file should be retransmitted (see RetryPolicy.Retransmit).
+func (lpr *LprSend) retransmittable(err error, attempt int, policy RetryPolicy) bool {
+	if policy.Retransmit == nil || !lpr.fileAcked || !lpr.connectionFailed {
+		return false
+	}
+
+	var nackErr *PrinterNackError
+	if errors.As(err, &nackErr) {
+		return false
+	}
+
+	return policy.Retransmit(attempt, lpr.bytesSent, err)
+}
+
+// SendWithRetry sends the given file to the remote printer like Send,
+// but retries failed attempts according to the given RetryPolicy.
+func SendWithRetry(file string, hostname string, port uint16, queue string, username string, timeout time.Duration, policy RetryPolicy, opts ...SendOption) (err error) {
+	for attempt := 1; ; attempt++ {
+		lpr := &LprSend{}
+		err = send(lpr, hostname, port, queue, username, timeout, file, "", opts, func(lpr *LprSend) error {
+			return lpr.SendFile()
+		})
+		if err == nil {
+			return nil
+		}
+		if attempt >= policy.MaxAttempts {
+			if uri := lpr.ippFallbackURI(err); uri != "" {
+				return lpr.sendIppFile(uri, file, err)
+			}
+			return err
+		}
+		if !lpr.retryable(err) && !lpr.retransmittable(err, attempt, policy) {
+			return err
+		}
+
+		backoff := policy.backoff(attempt)
+		logErrorf("Attempt %d of %d to send %s failed, retrying in %v: %v", attempt, policy.MaxAttempts, file, backoff, err)
+		time.Sleep(backoff)
+	}
+}
@@ -0,0 +1,219 @@
+package lprlib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	require.Equal(t, 100*time.Millisecond, policy.backoff(1))
+	require.Equal(t, 200*time.Millisecond, policy.backoff(2))
+	require.Equal(t, 300*time.Millisecond, policy.backoff(3))
+	require.Equal(t, 300*time.Millisecond, policy.backoff(10))
+
+	policy = RetryPolicy{}
+	require.Equal(t, DefaultRetryInitialBackoff, policy.backoff(1))
+	require.Equal(t, 4*DefaultRetryInitialBackoff, policy.backoff(3))
+}
+
+func TestSendWithRetry(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: 200 * time.Millisecond}
+
+	// the daemon is started after the first attempt
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	started := make(chan error)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		started <- lprd.Init(port, "")
+	}()
+
+	err = SendWithRetry(name, "127.0.0.1", port, "raw", "TestUser", time.Minute, policy)
+	require.Nil(t, err)
+	require.Nil(t, <-started)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Nil(t, os.Remove(conn.SaveName))
+	lprd.Close()
+
+	// a timeout is retried
+	var mutex sync.Mutex
+	calls := 0
+
+	lprd = LprDaemon{}
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.OnReceiveJob = func(remoteAddr net.Addr, queue string) error {
+		mutex.Lock()
+		calls++
+		first := calls == 1
+		mutex.Unlock()
+
+		if first {
+			time.Sleep(500 * time.Millisecond)
+		}
+		return nil
+	}
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	err = SendWithRetry(name, "127.0.0.1", port, "raw", "TestUser", 200*time.Millisecond, policy)
+	require.Nil(t, err)
+
+	// the aborted attempt did not receive a data file
+	received := 0
+	for i := 0; i < 2; i++ {
+		conn = <-lprd.FinishedConnections()
+		if conn.SaveName != "" {
+			require.Equal(t, End, conn.Status)
+			require.Nil(t, os.Remove(conn.SaveName))
+			received++
+		}
+	}
+	require.Equal(t, 1, received)
+
+	mutex.Lock()
+	require.Equal(t, 2, calls)
+	mutex.Unlock()
+
+	// a rejected job is not retried
+	calls = 0
+	lprd.OnReceiveJob = func(remoteAddr net.Addr, queue string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls++
+		return errors.New("rejected")
+	}
+
+	err = SendWithRetry(name, "127.0.0.1", port, "raw", "TestUser", time.Minute, policy)
+	require.NotNil(t, err)
+
+	var nackErr *PrinterNackError
+	require.True(t, errors.As(err, &nackErr))
+	require.Equal(t, StepReceiveJob, nackErr.Step)
+	require.Equal(t, NackFailure, nackErr.Code)
+	require.False(t, nackErr.Temporary())
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+
+	mutex.Lock()
+	require.Equal(t, 1, calls)
+	mutex.Unlock()
+
+	// a temporary failure is retried
+	calls = 0
+	lprd.OnReceiveJob = func(remoteAddr net.Addr, queue string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls++
+		if calls == 1 {
+			return &NackError{Code: NackRetry, Err: errors.New("busy")}
+		}
+		return nil
+	}
+
+	err = SendWithRetry(name, "127.0.0.1", port, "raw", "TestUser", time.Minute, policy)
+	require.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		conn = <-lprd.FinishedConnections()
+		if conn.SaveName != "" {
+			require.Nil(t, os.Remove(conn.SaveName))
+		}
+	}
+
+	mutex.Lock()
+	require.Equal(t, 2, calls)
+	mutex.Unlock()
+}
+
+func TestSendWithRetryRetransmit(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	text := strings.Repeat("Text for the file\n", 10000)
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+
+	// printer drops the connection while receiving the data file of the first two connections
+	received := make(chan string, 10)
+	go func() {
+		for attempt := 1; ; attempt++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			reader := bufio.NewReader(conn)
+			readFile := func(limit int) string {
+				line, _ := reader.ReadString('\n')
+				conn.Write([]byte{0})
+				var size int
+				fmt.Sscanf(line[1:], "%d", &size)
+				if limit > 0 && limit < size {
+					data := make([]byte, limit)
+					io.ReadFull(reader, data)
+					return ""
+				}
+				data := make([]byte, size+1)
+				io.ReadFull(reader, data)
+				conn.Write([]byte{0})
+				return string(data[:size])
+			}
+
+			reader.ReadString('\n')
+			conn.Write([]byte{0})
+			readFile(0)
+			if attempt <= 2 {
+				readFile(100)
+			} else {
+				received <- readFile(0)
+			}
+			conn.Close()
+		}
+	}()
+
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond}
+
+	// not retransmitted per default
+	err = SendWithRetry(name, "127.0.0.1", port, "raw", "TestUser", time.Minute, policy)
+	require.NotNil(t, err)
+
+	var retransmits []int64
+	policy.Retransmit = func(attempt int, bytesSent int64, err error) bool {
+		retransmits = append(retransmits, bytesSent)
+		return true
+	}
+	err = SendWithRetry(name, "127.0.0.1", port, "raw", "TestUser", time.Minute, policy)
+	require.Nil(t, err)
+	require.Equal(t, text, <-received)
+	require.Len(t, retransmits, 1)
+	require.Greater(t, retransmits[0], int64(0))
+}
@@ -0,0 +1,143 @@
+package lprlib
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// dataFileLetters are the letters distinguishing the data files of a job, like classic lpr uses them
+// (dfA123host, dfB123host, ...).
+const dataFileLetters = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// sendDataFile is a data file queued by AddFile or AddReader.
+type sendDataFile struct {
+	// path is the file to send, if the data file was queued by AddFile
+	path string
+
+	// reader provides the data, if the data file was queued by AddReader
+	reader io.Reader
+
+	// size is the number of bytes the reader provides
+	size int64
+
+	// name is the name of the source file (N)
+	name string
+}
+
+// dataFileName returns the name of the data file with the given index within the job with the given number.
+func dataFileName(index int, jobNumber int, hostname string) (string, error) {
+	if index < 0 || index >= len(dataFileLetters) {
+		return "", &LprError{fmt.Sprintf("Too many data files: a job can contain at most %d data files", len(dataFileLetters))}
+	}
+
+	return fmt.Sprintf("df%c%03d%s", dataFileLetters[index], jobNumber, hostname), nil
+}
+
+// AddFile queues the given file to be sent as an additional data file of the job.
+// All queued data files are referenced by the control file sent by SendConfiguration
+// and are sent by SendFiles.
+func (lpr *LprSend) AddFile(path string) error {
+	if path == "" {
+		return &LprError{"No filename given"}
+	}
+
+	if len(lpr.files) >= len(dataFileLetters) {
+		return &LprError{fmt.Sprintf("Too many data files: a job can contain at most %d data files", len(dataFileLetters))}
+	}
+
+	lpr.files = append(lpr.files, sendDataFile{path: path, name: filepath.Base(path)})
+	return nil
+}
+
+// AddReader queues the data of the given reader to be sent as an additional data file of the job (see AddFile).
+// The size is the number of bytes the reader provides, name is the (optional) name of the source file.
+// As with SendReader, only size bytes are sent and sending fails if the reader provides less.
+func (lpr *LprSend) AddReader(reader io.Reader, size int64, name string) error {
+	if size <= 0 {
+		return &LprError{fmt.Sprintf("Can't send data: Invalid size %d", size)}
+	}
+
+	if len(lpr.files) >= len(dataFileLetters) {
+		return &LprError{fmt.Sprintf("Too many data files: a job can contain at most %d data files", len(dataFileLetters))}
+	}
+
+	lpr.files = append(lpr.files, sendDataFile{reader: reader, size: size, name: name})
+	return nil
+}
+
+// filesConfig returns the control file lines referencing the queued data files.
+// Each data file is printed using the print command of the Config (per default 'l').
+func (lpr *LprSend) filesConfig() (string, error) {
+	format := byte(lpr.defaultFormat())
+	for key := range lpr.printCommands() {
+		format = key
+	}
+
+	copies := lpr.Copies
+	if copies < 1 {
+		copies = 1
+	}
+
+	var config string
+	for index, file := range lpr.files {
+		fileName, err := lpr.DataFileName(index)
+		if err != nil {
+			return "", err
+		}
+
+		for i := 0; i < copies; i++ {
+			config += fmt.Sprintf("%c%s\n", format, fileName)
+		}
+		if file.name != "" {
+			err = checkControlValue('N', "source file name", file.name, maxSourceNameLength)
+			if err != nil {
+				return "", &LprError{"Invalid control file: " + err.Error()}
+			}
+			config += fmt.Sprintf("N%s\n", file.name)
+		}
+	}
+
+	return config, nil
+}
+
+// SendFiles sends the data files queued by AddFile and AddReader to the remote printer.
+func (lpr *LprSend) SendFiles() error {
+	if len(lpr.files) == 0 {
+		return &LprError{"No data files queued"}
+	}
+
+	for index, file := range lpr.files {
+		err := lpr.sendQueuedFile(file, index)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendQueuedFile sends the given queued data file.
+func (lpr *LprSend) sendQueuedFile(file sendDataFile, index int) error {
+	if file.reader != nil {
+		return lpr.sendDataFile(newSizedReader(file.reader, file.size), file.size, index)
+	}
+
+	input, err := os.Open(file.path)
+	if err != nil {
+		return &LprError{fmt.Sprintf("Can't open file %s: %s", file.path, err)}
+	}
+	defer input.Close()
+
+	fileInfo, err := input.Stat()
+	if err != nil {
+		return &LprError{fmt.Sprintf("Can't stat file %s: %s", file.path, err)}
+	}
+
+	if fileInfo.Size() <= 0 {
+		return &LprError{fmt.Sprintf("Can't read file %s: Invalid file size %d", file.path, fileInfo.Size())}
+	}
+
+	return lpr.sendDataFile(input, fileInfo.Size(), index)
+}
@@ -0,0 +1,162 @@
+package lprlib
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// SendOption sets a field of the control file sent by Send, SendStream or SendWithRetry.
+type SendOption func(lpr *LprSend)
+
+// WithJobName sets the job name printed on the banner page (J).
+func WithJobName(name string) SendOption {
+	return func(lpr *LprSend) {
+		lpr.Config['J'] = name
+	}
+}
+
+// WithTitle sets the title used by pr (T).
+func WithTitle(title string) SendOption {
+	return func(lpr *LprSend) {
+		lpr.Config['T'] = title
+	}
+}
+
+// WithClass sets the class name printed on the banner page (C).
+func WithClass(class string) SendOption {
+	return func(lpr *LprSend) {
+		lpr.Config['C'] = class
+	}
+}
+
+// WithBanner requests a banner page for the given user (L).
+// If the user is empty, the user identification of the job is used.
+func WithBanner(user string) SendOption {
+	return func(lpr *LprSend) {
+		if user == "" {
+			user = lpr.Config['P']
+		}
+		lpr.Config['L'] = user
+	}
+}
+
+// WithIndent indents the output by the given number of columns (I).
+// Negative values are ignored.
+func WithIndent(columns int) SendOption {
+	return func(lpr *LprSend) {
+		if columns < 0 {
+			logErrorf("Ignoring indent: invalid number of columns %d", columns)
+			return
+		}
+		lpr.Config['I'] = strconv.Itoa(columns)
+	}
+}
+
+// WithWidth sets the page width of the output (W).
+// Values less than 1 are ignored.
+func WithWidth(width int) SendOption {
+	return func(lpr *LprSend) {
+		if width < 1 {
+			logErrorf("Ignoring width: invalid page width %d", width)
+			return
+		}
+		lpr.Config['W'] = strconv.Itoa(width)
+	}
+}
+
+// WithMailTo requests a mail to the given user when the job is printed (M).
+// If the user is empty, the user identification of the job is used.
+func WithMailTo(user string) SendOption {
+	return func(lpr *LprSend) {
+		if user == "" {
+			user = lpr.Config['P']
+		}
+		lpr.Config['M'] = user
+	}
+}
+
+// WithCopies sets the number of copies which should be printed (see LprSend.Copies).
+func WithCopies(copies int) SendOption {
+	return func(lpr *LprSend) {
+		lpr.Copies = copies
+	}
+}
+
+// WithFormat sets the format the data file is printed with (see LprSend.SetFormat),
+// e.g. FormatRaw to print it leaving control characters or FormatPostScript for PostScript files.
+// Invalid formats are ignored.
+func WithFormat(format Format) SendOption {
+	return func(lpr *LprSend) {
+		err := lpr.SetFormat(format)
+		if err != nil {
+			logErrorf("Ignoring format: %v", err)
+		}
+	}
+}
+
+// WithDataFileFirst sends the data file before the control file (see LprSend.DataFileFirst).
+func WithDataFileFirst() SendOption {
+	return func(lpr *LprSend) {
+		lpr.DataFileFirst = true
+	}
+}
+
+// WithTLS connects to the printer using TLS (see LprSend.TLSConfig).
+func WithTLS(config *tls.Config) SendOption {
+	return func(lpr *LprSend) {
+		lpr.TLSConfig = config
+	}
+}
+
+// WithDialContext connects to the printer using the given dial function (see LprSend.DialContext).
+func WithDialContext(dial func(ctx context.Context, network string, address string) (net.Conn, error)) SendOption {
+	return func(lpr *LprSend) {
+		lpr.DialContext = dial
+	}
+}
+
+// WithProxy connects to the printer through the given proxy (see LprSend.Proxy).
+func WithProxy(proxy *url.URL) SendOption {
+	return func(lpr *LprSend) {
+		lpr.Proxy = proxy
+	}
+}
+
+// WithIppFallback sends the job using IPP if the LPD port of the printer can't be reached (see LprSend.IppFallback).
+// If the printer URI is empty, ipp://<host name>:631/ipp/print is used.
+func WithIppFallback(printerURI string) SendOption {
+	return func(lpr *LprSend) {
+		lpr.IppFallback = true
+		lpr.IppFallbackURI = printerURI
+	}
+}
+
+// WithProgress calls the given function after each block of the data file was sent (see LprSend.OnProgress).
+func WithProgress(progress func(bytesSent, totalBytes int64)) SendOption {
+	return func(lpr *LprSend) {
+		lpr.OnProgress = progress
+	}
+}
+
+// printCommands returns the print commands of the Config.
+func (lpr *LprSend) printCommands() map[byte]string {
+	commands := make(map[byte]string)
+	for key, value := range lpr.Config {
+		if isPrintCommand(key) {
+			commands[key] = value
+		}
+	}
+	return commands
+}
+
+// isPrintCommand tells if the given control file command prints a data file.
+func isPrintCommand(command byte) bool {
+	switch command {
+	case 'c', 'd', 'f', 'g', 'l', 'n', 'o', 'p', 'r', 't', 'v':
+		return true
+	}
+	return false
+}
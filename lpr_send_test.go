@@ -1,10 +1,26 @@
 package lprlib
 
 import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"math/big"
+	"net"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -58,6 +74,868 @@ func TestSend(t *testing.T) {
 	require.Nil(t, err)
 }
 
+func TestSendStream(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	err = SendStream(strings.NewReader(text), int64(len(text)), "report.txt", "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "report.txt", conn.Filename)
+	out, err := os.ReadFile(conn.SaveName)
+	require.Nil(t, err)
+	require.Equal(t, text, string(out))
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// data piped from another writer
+	reader, writer := io.Pipe()
+	go func() {
+		for i := 0; i < 100; i++ {
+			writer.Write([]byte(text))
+		}
+		writer.Close()
+	}()
+
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	require.Nil(t, lprs.SendConfiguration())
+	require.Nil(t, lprs.SendReader(reader, int64(100*len(text))))
+	require.Nil(t, lprs.Close())
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Empty(t, conn.Filename)
+	out, err = os.ReadFile(conn.SaveName)
+	require.Nil(t, err)
+	require.Equal(t, strings.Repeat(text, 100), string(out))
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// the size is required
+	err = SendStream(strings.NewReader(text), 0, "", "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.NotNil(t, err)
+
+	conn = <-lprd.FinishedConnections()
+	require.NotEqual(t, End, conn.Status)
+}
+
+func TestSendReaderSize(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	// a reader providing less data than announced fails
+	err = SendStream(strings.NewReader(text), int64(len(text)+10), "", "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	var lprErr *LprError
+	require.True(t, errors.As(err, &lprErr))
+
+	conn := <-lprd.FinishedConnections()
+	require.NotEqual(t, End, conn.Status)
+
+	// only the announced data is sent
+	err = SendStream(strings.NewReader(text), 4, "", "127.0.0.1", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	out, err := os.ReadFile(conn.SaveName)
+	require.Nil(t, err)
+	require.Equal(t, "Text", string(out))
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// the same applies to queued readers
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	require.Nil(t, lprs.AddReader(strings.NewReader(text), int64(len(text)+10), ""))
+	require.Nil(t, lprs.SendConfiguration())
+	err = lprs.SendFiles()
+	require.True(t, errors.As(err, &lprErr))
+	require.Nil(t, lprs.Close())
+
+	conn = <-lprd.FinishedConnections()
+	require.NotEqual(t, End, conn.Status)
+}
+
+func TestSendTimeouts(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.OnReceiveJob = func(remoteAddr net.Addr, queue string) error {
+		// a slow printer
+		time.Sleep(500 * time.Millisecond)
+		return nil
+	}
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	// the acknowledgement takes longer than the timeout
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", 100*time.Millisecond)
+	require.Nil(t, err)
+	err = lprs.SendConfiguration()
+	require.NotNil(t, err)
+	require.Nil(t, lprs.Close())
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+
+	// the acknowledgement timeout is sufficient
+	lprs = LprSend{AckTimeout: 5 * time.Second}
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", 100*time.Millisecond)
+	require.Nil(t, err)
+	require.Nil(t, lprs.SendConfiguration())
+	require.Nil(t, lprs.SendReader(strings.NewReader(text), int64(len(text))))
+	require.Nil(t, lprs.Close())
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// a dial timeout does not affect reachable printers
+	lprs = LprSend{DialTimeout: time.Second, AckTimeout: 5 * time.Second}
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	require.Nil(t, lprs.SendConfiguration())
+	require.Nil(t, lprs.SendReader(strings.NewReader(text), int64(len(text))))
+	require.Nil(t, lprs.Close())
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Nil(t, os.Remove(conn.SaveName))
+}
+
+func TestSendProgress(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := strings.Repeat("Text for the file\n", 10000)
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	progress := []int64{}
+
+	lprs := LprSend{MaxSize: 4096}
+	lprs.OnProgress = func(bytesSent, totalBytes int64) {
+		require.Equal(t, int64(len(text)), totalBytes)
+		progress = append(progress, bytesSent)
+	}
+	err = lprs.Init("127.0.0.1", name, port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	require.Nil(t, lprs.SendConfiguration())
+	require.Nil(t, lprs.SendFile())
+	require.Nil(t, lprs.Close())
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	require.Greater(t, len(progress), 1)
+	for i := 1; i < len(progress); i++ {
+		require.Greater(t, progress[i], progress[i-1])
+	}
+	require.Equal(t, int64(len(text)), progress[len(progress)-1])
+}
+
+func TestSendOptions(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute,
+		WithJobName("Job"), WithTitle("Title"), WithClass("Class"), WithBanner(""), WithMailTo("MailUser"),
+		WithIndent(4), WithWidth(-1), WithWidth(72), WithCopies(2), WithFormat(FormatRaw))
+	require.Nil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Nil(t, os.Remove(conn.SaveName))
+	require.Equal(t, "Job", conn.JobName)
+	require.Equal(t, "Title", conn.TitleText)
+	require.Equal(t, "Class", conn.ClassName)
+	require.True(t, conn.PrintBanner)
+	require.Equal(t, "TestUser", conn.BannerUser)
+	require.Equal(t, "MailUser", conn.ControlFile.MailUser)
+	require.Equal(t, int64(4), conn.ControlFile.Indent)
+	require.Equal(t, int64(72), conn.ControlFile.Width)
+	require.Empty(t, conn.PrintFileWithPr)
+	require.Len(t, conn.ControlFile.PrintFiles, 2)
+	for _, file := range conn.ControlFile.PrintFiles {
+		require.Equal(t, byte('l'), file.Format)
+	}
+}
+
+func TestSendFiles(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var mutex sync.Mutex
+	sinks := map[string]*bufferSink{}
+
+	var lprd LprDaemon
+	lprd.DataSinkFactory = func(job *LprConnection) (io.WriteCloser, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		sink := &bufferSink{}
+		sinks[job.DataFileName] = sink
+		return sink, nil
+	}
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	lprs := LprSend{Copies: 2}
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	require.Nil(t, lprs.AddFile(name))
+	require.Nil(t, lprs.AddReader(strings.NewReader("second file"), 11, "second.txt"))
+	require.Nil(t, lprs.SendConfiguration())
+	require.Nil(t, lprs.SendFiles())
+	require.Nil(t, lprs.Close())
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+
+	first, err := lprs.DataFileName(0)
+	require.Nil(t, err)
+	second, err := lprs.DataFileName(1)
+	require.Nil(t, err)
+
+	require.Equal(t, []PrintFile{
+		{Format: 'l', FileName: first},
+		{Format: 'l', FileName: first},
+		{Format: 'l', FileName: second},
+		{Format: 'l', FileName: second},
+	}, conn.ControlFile.PrintFiles)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	require.Len(t, sinks, 2)
+	require.Equal(t, text, sinks[first].String())
+	require.Equal(t, "second file", sinks[second].String())
+
+	// the number of data files is limited
+	lprs = LprSend{}
+	for i := 0; i < 52; i++ {
+		require.Nil(t, lprs.AddFile(name))
+	}
+	require.NotNil(t, lprs.AddFile(name))
+	require.NotNil(t, lprs.AddReader(strings.NewReader(text), int64(len(text)), ""))
+}
+
+func TestSendNextJob(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		text := fmt.Sprintf("Label %d", i)
+		lprs.Config['J'] = text
+
+		require.Nil(t, lprs.SendConfiguration())
+		require.Nil(t, lprs.SendReader(strings.NewReader(text), int64(len(text))))
+		lprs.NextJob()
+	}
+	require.Nil(t, lprs.Close())
+
+	for i := 0; i < 3; i++ {
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+		require.Equal(t, fmt.Sprintf("Label %d", i), conn.JobName)
+
+		out, err := os.ReadFile(conn.SaveName)
+		require.Nil(t, err)
+		require.Equal(t, conn.JobName, string(out))
+		require.Nil(t, os.Remove(conn.SaveName))
+	}
+}
+
+func TestSendControlFileBuilder(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	dataFile, err := lprs.DataFileName(0)
+	require.Nil(t, err)
+	lprs.ControlFileBuilder = NewControlFileBuilder("host", "BuilderUser").
+		SetJobName("Job").
+		AddPrintFile('l', dataFile, "report.txt", 1)
+
+	require.Nil(t, lprs.SendConfiguration())
+	require.Nil(t, lprs.SendReader(strings.NewReader(text), int64(len(text))))
+	require.Nil(t, lprs.Close())
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Equal(t, "BuilderUser", conn.UserIdentification)
+	require.Equal(t, "Job", conn.JobName)
+	require.Equal(t, "report.txt", conn.Filename)
+	require.Equal(t, []PrintFile{{Format: 'l', FileName: dataFile}}, conn.ControlFile.PrintFiles)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// invalid values are rejected before the job is started
+	lprs = LprSend{}
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser1\n\n", time.Minute)
+	require.Nil(t, err)
+	require.NotNil(t, lprs.SendConfiguration())
+
+	lprs.ControlFileBuilder = NewControlFileBuilder("host", "TestUser1\n\n").AddPrintFile('p', dataFile, "", 1)
+	require.NotNil(t, lprs.SendConfiguration())
+	require.Nil(t, lprs.Close())
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, ConnectionTypeUnknown, conn.connectionType)
+}
+
+func TestSendJobNumbers(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	first := lprs.JobNumber()
+	for i := 0; i < 2; i++ {
+		require.Nil(t, lprs.SendConfiguration())
+		require.Nil(t, lprs.SendReader(strings.NewReader(text), int64(len(text))))
+		lprs.NextJob()
+	}
+	require.Nil(t, lprs.Close())
+
+	for i := 0; i < 2; i++ {
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+		require.Equal(t, fmt.Sprintf("%03d", (first+i)%1000), conn.JobNumber)
+		require.Len(t, conn.ControlFile.PrintFiles, 1)
+		require.Equal(t, "dfA"+conn.JobNumber+conn.OriginHost, conn.ControlFile.PrintFiles[0].FileName)
+		require.Nil(t, os.Remove(conn.SaveName))
+	}
+
+	// the job numbers wrap after 999
+	atomic.StoreUint32(&lastJobNumber, 999)
+	require.Equal(t, 999, nextJobNumber())
+	require.Equal(t, 0, nextJobNumber())
+}
+
+func TestSendDataFileFirst(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+	name, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	controlFileFirst := make(chan bool, 1)
+
+	var lprd LprDaemon
+	lprd.DataSinkFactory = func(job *LprConnection) (io.WriteCloser, error) {
+		controlFileFirst <- job.ControlFile != nil
+		return &bufferSink{}, nil
+	}
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	for _, dataFileFirst := range []bool{false, true} {
+		opts := []SendOption{}
+		if dataFileFirst {
+			opts = append(opts, WithDataFileFirst())
+		}
+
+		err = Send(name, "127.0.0.1", port, "raw", "TestUser", time.Minute, opts...)
+		require.Nil(t, err)
+
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+		require.Equal(t, "TestUser", conn.UserIdentification)
+		require.Equal(t, !dataFileFirst, <-controlFileFirst)
+	}
+}
+
+func TestSendEncoding(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	var lprs LprSend
+	require.NotNil(t, lprs.SetEncoding("unknown"))
+	require.Nil(t, lprs.SetEncoding("windows-1252"))
+
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestÜser", time.Minute)
+	require.Nil(t, err)
+	lprs.Config['J'] = "Jöb €"
+	lprs.Config['T'] = "Title ✓"
+
+	require.Nil(t, lprs.SendConfiguration())
+	require.Nil(t, lprs.SendReader(strings.NewReader(text), int64(len(text))))
+	require.Nil(t, lprs.Close())
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Nil(t, os.Remove(conn.SaveName))
+
+	// the daemon decodes the windows-1252 encoded values
+	require.Equal(t, "TestÜser", conn.UserIdentification)
+	require.Equal(t, "Jöb €", conn.JobName)
+	require.Equal(t, "Title \x1a", conn.TitleText)
+	require.Contains(t, string(conn.rawControlFile), "PTest\xDCser\n")
+}
+
+func TestSendFormat(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "%!PS"
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	require.NotNil(t, lprs.SetFormat('x'))
+	require.Nil(t, lprs.SetFormat(FormatPostScript))
+
+	require.Nil(t, lprs.SendConfiguration())
+	require.Nil(t, lprs.SendReader(strings.NewReader(text), int64(len(text))))
+	require.Nil(t, lprs.Close())
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Nil(t, os.Remove(conn.SaveName))
+	require.Len(t, conn.ControlFile.PrintFiles, 1)
+	require.Equal(t, byte(FormatPostScript), conn.ControlFile.PrintFiles[0].Format)
+	require.Empty(t, conn.PrintFileWithPr)
+
+	require.Equal(t, "PostScript", FormatPostScript.String())
+	require.False(t, Format('x').Valid())
+	require.True(t, FormatRaw.Valid())
+}
+
+func TestSendConfigValidation(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	for name, value := range map[byte]string{
+		'J': strings.Repeat("j", 100),
+		'T': strings.Repeat("t", 80),
+		'P': strings.Repeat("u", 32),
+		'C': "class\x1b",
+		'N': "file\nname",
+		'W': "wide",
+	} {
+		var lprs LprSend
+		err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+		require.Nil(t, err)
+		lprs.Config[name] = value
+
+		err = lprs.SendConfiguration()
+		require.NotNil(t, err, string(name))
+		require.Contains(t, err.Error(), fmt.Sprintf("(%c)", name))
+		require.Nil(t, lprs.Close())
+	}
+}
+
+func TestSendFileNameOverride(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Relayed text"
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	lprs.ControlFileName = "cfA123origin"
+	lprs.DataFileNames = []string{"dfA123origin"}
+
+	require.Nil(t, lprs.SendConfiguration())
+	require.Nil(t, lprs.SendReader(strings.NewReader(text), int64(len(text))))
+	require.Nil(t, lprs.Close())
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Nil(t, os.Remove(conn.SaveName))
+	require.Len(t, conn.ControlFile.PrintFiles, 1)
+	require.Equal(t, "dfA123origin", conn.ControlFile.PrintFiles[0].FileName)
+	require.Equal(t, "cfA123origin", conn.ControlFileName)
+	require.Equal(t, "123", conn.JobNumber)
+	require.Equal(t, "origin", conn.OriginHost)
+
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	lprs.ControlFileName = "bad name"
+	require.NotNil(t, lprs.SendConfiguration())
+	require.Nil(t, lprs.Close())
+}
+
+func TestSendDefaultFormat(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	send := func(lprs *LprSend) *LprConnection {
+		err := lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+		require.Nil(t, err)
+		require.Nil(t, lprs.SendConfiguration())
+		require.Nil(t, lprs.SendReader(strings.NewReader(text), int64(len(text))))
+		require.Nil(t, lprs.Close())
+
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+		require.Nil(t, os.Remove(conn.SaveName))
+		return conn
+	}
+
+	// raw per default
+	conn := send(&LprSend{})
+	require.Len(t, conn.ControlFile.PrintFiles, 1)
+	require.Equal(t, byte(FormatRaw), conn.ControlFile.PrintFiles[0].Format)
+
+	conn = send(&LprSend{DefaultFormat: FormatPr})
+	require.Equal(t, "dfA"+conn.JobNumber+conn.OriginHost, conn.PrintFileWithPr)
+
+	conn = send(&LprSend{OmitDefaultFormat: true})
+	require.Empty(t, conn.ControlFile.PrintFiles)
+
+	var lprs LprSend
+	lprs.DefaultFormat = Format('x')
+	require.NotNil(t, lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute))
+}
+
+func TestSendPrivilegedSourcePort(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+
+	// occupy the first port of the range
+	atomic.StoreUint32(&lastSourcePort, 0)
+	blocker, err := net.Listen("tcp", fmt.Sprintf(":%d", minSourcePort))
+	if err != nil {
+		t.Skipf("Can't bind privileged ports: %v", err)
+	}
+	defer blocker.Close()
+
+	var lprs LprSend
+	lprs.PrivilegedSourcePort = true
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	defer lprs.Close()
+
+	conn, err := listener.Accept()
+	require.Nil(t, err)
+	defer conn.Close()
+
+	require.Equal(t, minSourcePort+1, conn.RemoteAddr().(*net.TCPAddr).Port)
+}
+
+func TestSendDialer(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	receive := func() {
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+		require.Nil(t, os.Remove(conn.SaveName))
+	}
+
+	// the dial function gets the unresolved address
+	var address string
+	dial := func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		address = addr
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, fmt.Sprintf("127.0.0.1:%d", port))
+	}
+	err = SendStream(strings.NewReader(text), int64(len(text)), "", "printer.invalid", 0, "raw", "TestUser", time.Minute,
+		WithDialContext(dial))
+	require.Nil(t, err)
+	require.Equal(t, "printer.invalid:515", address)
+	receive()
+
+	// the dialer may control the socket
+	controlled := false
+	var lprs LprSend
+	lprs.Dialer = &net.Dialer{Control: func(network, address string, c syscall.RawConn) error {
+		controlled = true
+		return nil
+	}}
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	require.True(t, controlled)
+	require.Nil(t, lprs.SendConfiguration())
+	require.Nil(t, lprs.SendReader(strings.NewReader(text), int64(len(text))))
+	require.Nil(t, lprs.Close())
+	receive()
+}
+
+func TestSendAddressFallback(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+
+	defer func(lookup func(context.Context, string) ([]net.IPAddr, error)) {
+		lookupIPAddr = lookup
+	}(lookupIPAddr)
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		// nothing listens on the first address
+		return []net.IPAddr{{IP: net.ParseIP("127.0.0.2")}, {IP: net.ParseIP("127.0.0.1")}}, nil
+	}
+
+	addrs, err := GetIPs("printer")
+	require.Nil(t, err)
+	require.Len(t, addrs, 2)
+
+	var lprs LprSend
+	err = lprs.Init("printer", "", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	defer lprs.Close()
+	require.Equal(t, fmt.Sprintf("127.0.0.1:%d", port), lprs.RemoteAddr().String())
+
+	conn, err := listener.Accept()
+	require.Nil(t, err)
+	require.Nil(t, conn.Close())
+}
+
+func TestSendStrictAck(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+
+	// printer answers the command of the print job and then the given reply
+	printer := func(reply func(conn net.Conn)) {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		reader.ReadString('\n')
+		conn.Write([]byte{0})
+		reader.ReadString('\n')
+		reply(conn)
+	}
+
+	for name, test := range map[string]struct {
+		reply   func(conn net.Conn)
+		message string
+	}{
+		"closed":  {func(conn net.Conn) {}, "Printer closed the connection instead of acknowledging the control file command"},
+		"nack":    {func(conn net.Conn) { conn.Write([]byte{1}) }, "Printer reported an error (1) for the control file command"},
+		"timeout": {func(conn net.Conn) { time.Sleep(time.Second) }, "No acknowledgement for the control file command within 100ms"},
+	} {
+		go printer(test.reply)
+
+		var lprs LprSend
+		lprs.AckTimeout = 100 * time.Millisecond
+		err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+		require.Nil(t, err)
+
+		err = lprs.SendConfiguration()
+		require.NotNil(t, err, name)
+		require.Contains(t, err.Error(), test.message)
+		require.Nil(t, lprs.Close())
+	}
+}
+
+// generateTestCertificate creates a self-signed certificate for 127.0.0.1 and a pool trusting it.
+func generateTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "lprlib test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.Nil(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestSendTLS(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	text := "Text for the file"
+
+	cert, pool := generateTestCertificate(t)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	require.Nil(t, err)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err = lprd.ServeListener(listener)
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+	err = SendStream(strings.NewReader(text), int64(len(text)), "tls.txt", "127.0.0.1", port, "raw", "TestUser", time.Minute,
+		WithTLS(&tls.Config{RootCAs: pool}))
+	require.Nil(t, err)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	out, err := os.ReadFile(conn.SaveName)
+	require.Nil(t, err)
+	require.Nil(t, os.Remove(conn.SaveName))
+	require.Equal(t, text, string(out))
+
+	// the server name must match the certificate
+	var lprs LprSend
+	lprs.TLSConfig = &tls.Config{RootCAs: pool, ServerName: "printer.example.com"}
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "TLS handshake failed")
+
+	// the certificate must be trusted
+	err = SendStream(strings.NewReader(text), int64(len(text)), "tls.txt", "127.0.0.1", port, "raw", "TestUser", time.Minute,
+		WithTLS(&tls.Config{}))
+	require.NotNil(t, err)
+}
+
 func TestSendWithExternalIDGeneration(t *testing.T) {
 	SetDebugLogger(log.Print)
 
@@ -132,3 +1010,76 @@ func TestSendWithExternalIDGeneration(t *testing.T) {
 
 	lprd.Close()
 }
+
+func TestSendWithSlowExternalIDGeneration(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+	file, err := generateTempFile("", "", text)
+	require.Nil(t, err)
+	defer os.Remove(file)
+
+	var mutex sync.Mutex
+	running := 0
+	maxRunning := 0
+	release := make(chan struct{})
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.ExternalIDConcurrency = 3
+	lprd.GetExternalID = func() uint64 {
+		mutex.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+
+		<-release
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		running--
+		return 42
+	}
+
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	for i := 0; i < 3; i++ {
+		go func(user string) {
+			err := Send(file, "127.0.0.1", port, "raw", user, time.Minute)
+			require.Nil(t, err)
+		}(fmt.Sprintf("TestUser%d", i))
+	}
+
+	// the calls run concurrently
+	require.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return running == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// status requests do not wait for the external IDs
+	status, err := GetStatus("127.0.0.1", port, "raw", false, 2*time.Second)
+	require.Nil(t, err)
+	require.NotEmpty(t, status)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, ConnectionTypeSendQueueStateShort, conn.connectionType)
+	require.Zero(t, conn.ExternalID)
+
+	close(release)
+
+	for i := 0; i < 3; i++ {
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+		require.Equal(t, uint64(42), conn.ExternalID)
+		require.Nil(t, os.Remove(conn.SaveName))
+	}
+
+	require.Equal(t, 3, maxRunning)
+}
@@ -0,0 +1,49 @@
+package lprlib
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"syscall"
+)
+
+// Range of the source ports a client must use according to RFC-1179, chapter 3.1
+const (
+	minSourcePort = 721
+	maxSourcePort = 731
+)
+
+// lastSourcePort counts the connections dialed by dialPrivileged, so consecutive connections
+// start with different source ports (the last one may still be in TIME_WAIT).
+var lastSourcePort uint32
+
+// dialPrivileged connects to the address using a source port in the range 721 - 731.
+// If a port is in use, the next one is tried until the range is exhausted.
+// The IP address of the LocalAddr of the dialer is kept.
+func dialPrivileged(dialer net.Dialer, address string) (net.Conn, error) {
+	count := uint32(maxSourcePort - minSourcePort + 1)
+	start := atomic.AddUint32(&lastSourcePort, 1) - 1
+
+	// keep the local address of the dialer
+	var localIP net.IP
+	if addr, ok := dialer.LocalAddr.(*net.TCPAddr); ok {
+		localIP = addr.IP
+	}
+
+	for i := uint32(0); i < count; i++ {
+		port := minSourcePort + int((start+i)%count)
+		dialer.LocalAddr = &net.TCPAddr{IP: localIP, Port: port}
+
+		conn, err := dialer.Dial("tcp", address)
+		if err == nil {
+			return conn, nil
+		}
+		if !errors.Is(err, syscall.EADDRINUSE) && !errors.Is(err, syscall.EADDRNOTAVAIL) {
+			return nil, err
+		}
+		logDebugf("Source port %d is in use: %v", port, err)
+	}
+
+	return nil, fmt.Errorf("No free source port in the range %d - %d", minSourcePort, maxSourcePort)
+}
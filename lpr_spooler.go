@@ -0,0 +1,924 @@
+package lprlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/documatrix/go-lprlib/pjl"
+)
+
+// File name extensions of the files of a spooled job
+const (
+	spoolJobExtension  = ".job"
+	spoolDataExtension = ".data"
+)
+
+// SpoolJobState is the processing state of a SpoolJob.
+type SpoolJobState string
+
+const (
+	// SpoolJobPending means that the job waits to be processed
+	SpoolJobPending SpoolJobState = "pending"
+
+	// SpoolJobProcessing means that the job is processed by the SpoolProcessor
+	SpoolJobProcessing SpoolJobState = "processing"
+
+	// SpoolJobFailed means that the processing failed and the job is not retried anymore
+	SpoolJobFailed SpoolJobState = "failed"
+)
+
+// SpoolJob is a job stored by the Spooler.
+type SpoolJob struct {
+	// ID is the unique number of the job in the spool directory
+	ID uint64 `json:"id"`
+
+	// State is the processing state of the job
+	State SpoolJobState `json:"state"`
+
+	// Queue is the name of the queue the job was sent to
+	Queue string `json:"queue"`
+
+	// User is the user identification of the job (P)
+	User string `json:"user,omitempty"`
+
+	// Host is the host name of the job (H)
+	Host string `json:"host,omitempty"`
+
+	// JobName is the job name of the job (J)
+	JobName string `json:"job_name,omitempty"`
+
+	// JobNumber is the job number taken from the control or data file name
+	JobNumber string `json:"job_number,omitempty"`
+
+	// FileName is the name of the source file (N)
+	FileName string `json:"file_name,omitempty"`
+
+	// RemoteAddr is the address of the client which sent the job
+	RemoteAddr string `json:"remote_addr,omitempty"`
+
+	// ExternalID is the external ID of the job (see LprDaemon.GetExternalID)
+	ExternalID uint64 `json:"external_id,omitempty"`
+
+	// ControlFile contains the lines of the received control file
+	ControlFile *ControlFile `json:"control_file,omitempty"`
+
+	// DataFile is the name of the data file in the spool directory
+	DataFile string `json:"data_file"`
+
+	// Size is the size of the data file in bytes. If the data file is compressed, it is the uncompressed size.
+	Size uint64 `json:"size"`
+
+	// Compression is the extension of the Compressor the data file was compressed with (see LprDaemon.Compressor),
+	// empty if it is not compressed
+	Compression string `json:"compression,omitempty"`
+
+	// Checksum is the checksum of the data file (see LprDaemon.ChecksumHash)
+	Checksum []byte `json:"checksum,omitempty"`
+
+	// DetectedFormat is the format of the data file (see LprDaemon.DetectFormat)
+	DetectedFormat DocumentFormat `json:"detected_format,omitempty"`
+
+	// PJL is the PJL job header of the data file (see LprDaemon.ParsePJL)
+	PJL *pjl.Header `json:"pjl,omitempty"`
+
+	// DSC contains the DSC comments of the data file (see LprDaemon.ParseDSC)
+	DSC *DSCComments `json:"dsc,omitempty"`
+
+	// Received is the time the job was spooled
+	Received time.Time `json:"received"`
+
+	// Priority is the priority class of the job, jobs with a higher priority are processed first
+	// (see Spooler.Priority)
+	Priority int `json:"priority,omitempty"`
+
+	// Attempts is the number of failed attempts to process the job
+	Attempts int `json:"attempts,omitempty"`
+
+	// LastError is the error of the last failed attempt
+	LastError string `json:"last_error,omitempty"`
+
+	// NextAttempt is the time the job is processed again after a failed attempt
+	NextAttempt time.Time `json:"next_attempt,omitempty"`
+
+	// Deliveries contains the delivery states of a job forwarded by a Relay by the names of the targets
+	Deliveries map[string]RelayDelivery `json:"deliveries,omitempty"`
+}
+
+// SpoolProcessor processes a spooled job, e.g. prints or archives its data file.
+// If it returns an error, the job is retried according to the Spooler.Retry policy.
+// It must stop if ctx is done.
+type SpoolProcessor func(ctx context.Context, job SpoolJob) error
+
+// Spooler stores the jobs received by an LprDaemon in a spool directory and processes them
+// with a SpoolProcessor. The metadata of each job is stored by a JobStore, so the jobs
+// survive restarts of the process: pending jobs are processed after the restart and jobs interrupted
+// while being processed are processed again (at-least-once). After a job was processed successfully,
+// its files are removed. Jobs which failed more often than allowed by the Retry policy are kept
+// with the state SpoolJobFailed until they are removed (see Remove).
+type Spooler struct {
+	// Dir is the spool directory. It should be on the same file system as the InputFileSaveDir
+	// of the daemon, so the data files can be moved without copying them.
+	Dir string
+
+	// Daemon is the daemon whose FinishedConnections are spooled by Run and whose queue state requests
+	// are answered by QueueState while Run is running. If nil, jobs have to be
+	// added by Enqueue (e.g. from LprDaemon.OnFinishedConnection).
+	Daemon *LprDaemon
+
+	// Processor processes the spooled jobs.
+	Processor SpoolProcessor
+
+	// Workers is the number of jobs processed concurrently.
+	// If 0, the jobs are processed one after another.
+	Workers int
+
+	// Retry configures how failed jobs are retried. If MaxAttempts is 0, jobs are retried until they succeed.
+	// Retransmit is not used.
+	Retry RetryPolicy
+
+	// Priority returns the priority class of a job when it is enqueued. Pending jobs with a higher priority
+	// are processed first, jobs with the same priority in the order they were received.
+	// If nil, the priority is taken from the QueuePriorities.
+	Priority func(job SpoolJob) int
+
+	// QueuePriorities contains the priority classes of the jobs by the names of their queues,
+	// if Priority is not set. Jobs of other queues have the priority 0.
+	QueuePriorities map[string]int
+
+	// PriorityAging avoids the starvation of jobs with a low priority: the priority of a pending job
+	// is increased by one for each PriorityAging it waits. If 0, the priorities are not increased.
+	PriorityAging time.Duration
+
+	// Store stores the metadata of the jobs, e.g. in the database of the application.
+	// If nil, the metadata is stored next to the data files in the Dir (see FileJobStore).
+	Store JobStore
+
+	// OnSkipped is called by Run for finished connections of the Daemon which are no successfully
+	// received jobs, e.g. queue state requests or failed jobs. If nil, the data files of failed jobs are removed.
+	OnSkipped func(conn *LprConnection)
+
+	mutex   sync.Mutex
+	opened  bool
+	jobs    map[uint64]*SpoolJob
+	lastID  uint64
+	changed chan struct{}
+	paused  map[string]bool
+	store   JobStore
+}
+
+// Open creates the spool directory and loads the jobs stored in it.
+// Jobs which were processed when the process stopped are pending again.
+// It is called by Run, Enqueue and Jobs if necessary.
+func (s *Spooler) Open() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.open()
+}
+
+// open loads the stored jobs if the spooler wasn't opened yet. The mutex must be locked.
+func (s *Spooler) open() error {
+	if s.opened {
+		return nil
+	}
+
+	if s.Dir == "" {
+		return errors.New("spool directory is not set")
+	}
+
+	err := os.MkdirAll(s.Dir, 0o700)
+	if err != nil {
+		return fmt.Errorf("error creating spool directory: %w", err)
+	}
+
+	s.store = s.Store
+	if s.store == nil {
+		s.store = &FileJobStore{Dir: s.Dir}
+	}
+
+	jobs, err := s.store.List()
+	if err != nil {
+		return err
+	}
+
+	s.jobs = make(map[uint64]*SpoolJob)
+	s.changed = make(chan struct{})
+
+	for i := range jobs {
+		job := &jobs[i]
+		if job.State == SpoolJobProcessing {
+			logDebugf("Spooled job %d was interrupted, processing it again", job.ID)
+			job.State = SpoolJobPending
+			err = s.store.UpdateState(job.ID, SpoolJobPending)
+			if err != nil {
+				logErrorf("Error marking spooled job %d as pending: %v", job.ID, err)
+			}
+		}
+
+		s.jobs[job.ID] = job
+		if job.ID > s.lastID {
+			s.lastID = job.ID
+		}
+	}
+
+	logDebugf("Loaded %d spooled jobs for %s", len(s.jobs), s.Dir)
+	s.opened = true
+
+	return nil
+}
+
+// Enqueue moves the data file of the received job into the spool directory and stores its metadata.
+// Jobs kept in memory (see LprDaemon.InMemoryThreshold) are written into the spool directory.
+// Once Enqueue returned, the job survives restarts of the process.
+func (s *Spooler) Enqueue(conn *LprConnection) (SpoolJob, error) {
+	if conn.SaveName == "" && conn.Data == nil {
+		return SpoolJob{}, errors.New("the connection has no saved data file")
+	}
+
+	s.mutex.Lock()
+	err := s.open()
+	if err != nil {
+		s.mutex.Unlock()
+		return SpoolJob{}, err
+	}
+	s.lastID++
+	id := s.lastID
+	s.mutex.Unlock()
+
+	job := newSpoolJob(conn)
+	job.ID = id
+	job.State = SpoolJobPending
+	job.DataFile = filepath.Join(s.Dir, strconv.FormatUint(id, 10)+spoolDataExtension)
+	job.Priority = s.priority(job)
+
+	if conn.SaveName != "" {
+		err = moveFile(conn.SaveName, job.DataFile)
+	} else {
+		err = writeSyncedFile(job.DataFile, conn.Data)
+	}
+	if err != nil {
+		return SpoolJob{}, fmt.Errorf("error spooling data file of job %d: %w", id, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err = s.store.Put(*job)
+	if err != nil {
+		os.Remove(job.DataFile)
+		return SpoolJob{}, err
+	}
+
+	logDebugf("Spooled job %d for queue %s (%d bytes)", job.ID, job.Queue, job.Size)
+
+	s.jobs[job.ID] = job
+	s.notify()
+
+	return *job, nil
+}
+
+// priority returns the priority class of the job (see Priority and QueuePriorities).
+func (s *Spooler) priority(job *SpoolJob) int {
+	if s.Priority != nil {
+		return s.Priority(*job)
+	}
+
+	return s.QueuePriorities[job.Queue]
+}
+
+// newSpoolJob returns the metadata of the job received by the connection.
+// The DataFile is the saved data file of the connection.
+func newSpoolJob(conn *LprConnection) *SpoolJob {
+	job := &SpoolJob{
+		Queue:          conn.PrqName,
+		User:           conn.UserIdentification,
+		Host:           conn.Hostname,
+		JobName:        conn.JobName,
+		JobNumber:      conn.JobNumber,
+		FileName:       conn.Filename,
+		ExternalID:     conn.ExternalID,
+		ControlFile:    conn.ControlFile,
+		DataFile:       conn.SaveName,
+		Size:           conn.ReceivedSize,
+		Checksum:       conn.Checksum,
+		DetectedFormat: conn.DetectedFormat,
+		PJL:            conn.PJL,
+		DSC:            conn.DSC,
+		Received:       time.Now(),
+	}
+	if conn.Connection != nil && conn.Connection.RemoteAddr() != nil {
+		job.RemoteAddr = conn.Connection.RemoteAddr().String()
+	}
+	if conn.SaveName != "" && conn.daemon != nil && conn.daemon.DataSinkFactory == nil {
+		// files of the InputFileSaveDir are compressed by the Compressor of the daemon
+		job.Compression = conn.daemon.fileExtension()
+	}
+
+	return job
+}
+
+// moveFile moves the file to the destination, copying it if it is on another file system.
+func moveFile(source, destination string) error {
+	err := os.Rename(source, destination)
+	if err == nil {
+		return nil
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cErr := out.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(destination)
+		return err
+	}
+
+	return os.Remove(source)
+}
+
+// writeSyncedFile writes the data into a new file and flushes it to disk.
+func writeSyncedFile(fileName string, data []byte) error {
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cErr := file.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(fileName)
+	}
+
+	return err
+}
+
+// Jobs returns the spooled jobs of the given queue (all jobs if empty) ordered by their IDs.
+func (s *Spooler) Jobs(queue string) ([]SpoolJob, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.open()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []SpoolJob{}
+	for _, job := range s.jobs {
+		if queue == "" || job.Queue == queue {
+			jobs = append(jobs, *job)
+		}
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].ID < jobs[j].ID
+	})
+
+	return jobs, nil
+}
+
+// QueueState returns the state of the requested queue in the format of the BSD lpq (see FormatQueueState).
+// It lists the processed jobs as "active" followed by the pending jobs in the order they will be processed
+// (see Priority). Paused queues are marked as down (see Pause).
+// Failed jobs are not listed. If the request contains user names or job numbers, only the matching jobs
+// are listed. Run answers the queue state requests of its Daemon with QueueState, unless a queue state
+// callback (e.g. LprDaemon.GetQueueStateContext) is set.
+func (s *Spooler) QueueState(ctx context.Context, request QueueStateRequest) (string, error) {
+	s.mutex.Lock()
+	err := s.open()
+	if err != nil {
+		s.mutex.Unlock()
+		return "", err
+	}
+	jobs := s.queued(request.Queue)
+	paused := s.paused[request.Queue]
+	s.mutex.Unlock()
+
+	listed := []QueueJob{}
+	active := false
+	position := 0
+	for _, job := range jobs {
+		rank := "active"
+		if job.State == SpoolJobProcessing {
+			active = true
+		} else {
+			position++
+			rank = queueRank(position)
+		}
+
+		queueJob := job.queueJob(rank)
+		if matchesQueueStateList(queueJob, request.List) {
+			listed = append(listed, queueJob)
+		}
+	}
+
+	state := FormatQueueState(listed, request.Long)
+	if paused {
+		state = "Warning: " + request.Queue + " is down: printing paused\n" + state
+	} else if active {
+		state = request.Queue + " is ready and printing\n" + state
+	}
+
+	return state, nil
+}
+
+// Pause stops processing the jobs of the queue until it is resumed (see Resume), like lpc stop.
+// Jobs for the queue are still enqueued, jobs processed at the moment are finished.
+// The paused queues are not stored, so they are resumed if the process is restarted.
+func (s *Spooler) Pause(queue string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.paused == nil {
+		s.paused = make(map[string]bool)
+	}
+	s.paused[queue] = true
+	logDebugf("Spooler queue %s was paused", queue)
+}
+
+// Resume continues processing the jobs of a paused queue (see Pause).
+func (s *Spooler) Resume(queue string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.paused[queue] {
+		return
+	}
+
+	delete(s.paused, queue)
+	logDebugf("Spooler queue %s was resumed", queue)
+
+	if s.changed != nil {
+		s.notify()
+	}
+}
+
+// Paused tells if the queue is paused (see Pause).
+func (s *Spooler) Paused(queue string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.paused[queue]
+}
+
+// queued returns the processed and pending jobs of the queue in the order they are processed.
+// The mutex must be locked.
+func (s *Spooler) queued(queue string) []SpoolJob {
+	jobs := []SpoolJob{}
+	for _, job := range s.jobs {
+		if job.Queue == queue && job.State != SpoolJobFailed {
+			jobs = append(jobs, *job)
+		}
+	}
+
+	now := time.Now()
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].State != jobs[j].State {
+			return jobs[i].State == SpoolJobProcessing
+		}
+		return s.before(&jobs[i], &jobs[j], now)
+	})
+
+	return jobs
+}
+
+// queueJob returns the job as listed in a queue state.
+func (job *SpoolJob) queueJob(rank string) QueueJob {
+	queueJob := QueueJob{
+		Rank:      rank,
+		Owner:     job.User,
+		JobNumber: job.JobNumber,
+		Files:     job.JobName,
+		Size:      int64(job.Size),
+		Host:      job.Host,
+	}
+
+	if queueJob.Owner == "" {
+		queueJob.Owner = "-"
+	}
+	if _, err := strconv.ParseUint(queueJob.JobNumber, 10, 64); err != nil {
+		// jobs without a numeric job number (e.g. raw jobs) are listed with their ID
+		queueJob.JobNumber = strconv.FormatUint(job.ID, 10)
+	}
+	if queueJob.Files == "" {
+		queueJob.Files = job.FileName
+	}
+	if queueJob.Files == "" {
+		queueJob.Files = "(standard input)"
+	}
+	if queueJob.Host == "" {
+		queueJob.Host, _, _ = net.SplitHostPort(job.RemoteAddr)
+	}
+
+	return queueJob
+}
+
+// matchesQueueStateList tells if the job is owned by one of the users or has one of the job numbers
+// of the list of a queue state request. An empty list matches every job.
+func matchesQueueStateList(job QueueJob, list []string) bool {
+	if len(list) == 0 {
+		return true
+	}
+
+	for _, entry := range list {
+		if entry == job.Owner || entry == job.JobNumber {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SuperUser is the agent which may remove the jobs of all users (see Spooler.Cancel).
+const SuperUser = "root"
+
+// Cancel removes the pending and failed jobs of the queue matching the user names or job numbers
+// (as listed by QueueState) of a remove jobs request (05 - Remove jobs) and returns the removed jobs.
+// Like the BSD lprm, the agent may only remove its own jobs unless it is the SuperUser,
+// and the first of its jobs is removed if the list is empty. Jobs which are processed at the moment
+// can't be removed. Run removes the jobs for remove jobs requests of its Daemon with Cancel,
+// unless LprDaemon.RemoveJobs is set.
+func (s *Spooler) Cancel(queue string, agent string, jobs []string) ([]SpoolJob, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.open()
+	if err != nil {
+		return nil, err
+	}
+
+	queued := s.queued(queue)
+	for _, job := range s.jobs {
+		if job.Queue == queue && job.State == SpoolJobFailed {
+			queued = append(queued, *job)
+		}
+	}
+
+	removed := []SpoolJob{}
+	for _, job := range queued {
+		if job.State == SpoolJobProcessing {
+			continue
+		}
+
+		queueJob := job.queueJob("")
+		if agent != SuperUser && queueJob.Owner != agent {
+			continue
+		}
+		if len(jobs) > 0 && !matchesQueueStateList(queueJob, jobs) {
+			continue
+		}
+
+		err = s.remove(s.jobs[job.ID])
+		if err != nil {
+			return removed, err
+		}
+		logDebugf("Spooled job %d of queue %s was removed by %s", job.ID, queue, agent)
+		removed = append(removed, job)
+
+		if len(jobs) == 0 {
+			break
+		}
+	}
+
+	return removed, nil
+}
+
+// RemoveJobs removes the jobs of a remove jobs request like Cancel. It can be used as LprDaemon.RemoveJobs.
+func (s *Spooler) RemoveJobs(queue string, agent string, jobs []string) error {
+	_, err := s.Cancel(queue, agent, jobs)
+	return err
+}
+
+// update changes the metadata of the spooled job with the given ID and stores it.
+func (s *Spooler) update(id uint64, change func(job *SpoolJob)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return fmt.Errorf("unknown spooled job %d", id)
+	}
+
+	change(job)
+
+	return s.store.Put(*job)
+}
+
+// Remove removes the spooled job with the given ID, which must not be processed at the moment.
+func (s *Spooler) Remove(id uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.open()
+	if err != nil {
+		return err
+	}
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return fmt.Errorf("unknown spooled job %d", id)
+	}
+	if job.State == SpoolJobProcessing {
+		return fmt.Errorf("spooled job %d is processed", id)
+	}
+
+	return s.remove(job)
+}
+
+// remove removes the files of the job. The mutex must be locked.
+func (s *Spooler) remove(job *SpoolJob) error {
+	err := s.store.Delete(job.ID)
+	if err != nil {
+		return err
+	}
+
+	delete(s.jobs, job.ID)
+
+	err = os.Remove(job.DataFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logErrorf("Error removing data file of spooled job %d: %v", job.ID, err)
+	}
+
+	return nil
+}
+
+// notify wakes up the workers waiting for jobs. The mutex must be locked.
+func (s *Spooler) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Run spools the finished connections of the Daemon (if set) and processes the spooled jobs
+// until ctx is done. Jobs processed when ctx is done are processed again by the next Run.
+func (s *Spooler) Run(ctx context.Context) error {
+	if s.Processor == nil {
+		return errors.New("spool processor is not set")
+	}
+
+	err := s.Open()
+	if err != nil {
+		return err
+	}
+
+	workers := s.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	if s.Daemon != nil {
+		s.Daemon.setRunningSpooler(s, nil)
+		defer s.Daemon.setRunningSpooler(nil, s)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work(ctx)
+		}()
+	}
+
+	if s.Daemon != nil {
+		s.spoolConnections(ctx)
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+// setRunningSpooler replaces the running spooler of the daemon by the given spooler,
+// if the current one is the expected spooler.
+func (lpr *LprDaemon) setRunningSpooler(spooler, expected *Spooler) {
+	lpr.spoolerMutex.Lock()
+	defer lpr.spoolerMutex.Unlock()
+
+	if lpr.spooler == expected {
+		lpr.spooler = spooler
+	}
+}
+
+// runningSpooler returns the spooler running for the daemon (nil if none).
+func (lpr *LprDaemon) runningSpooler() *Spooler {
+	lpr.spoolerMutex.Lock()
+	defer lpr.spoolerMutex.Unlock()
+
+	return lpr.spooler
+}
+
+// spoolConnections enqueues the jobs received by the Daemon until ctx is done.
+func (s *Spooler) spoolConnections(ctx context.Context) {
+	connections := s.Daemon.FinishedConnections()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case conn, ok := <-connections:
+			if !ok {
+				<-ctx.Done()
+				return
+			}
+
+			if conn.Status != End || (conn.SaveName == "" && conn.Data == nil) {
+				s.skip(conn)
+				continue
+			}
+
+			_, err := s.Enqueue(conn)
+			if err != nil {
+				logErrorf("Error spooling job for queue %s: %v", conn.PrqName, err)
+				s.skip(conn)
+				continue
+			}
+			conn.Release()
+		}
+	}
+}
+
+// skip passes a connection which wasn't spooled to OnSkipped or removes its data file.
+func (s *Spooler) skip(conn *LprConnection) {
+	if s.OnSkipped != nil {
+		s.OnSkipped(conn)
+		return
+	}
+
+	if conn.Status != End && conn.SaveName != "" {
+		os.Remove(conn.SaveName)
+	}
+	conn.Release()
+}
+
+// work processes the spooled jobs until ctx is done.
+func (s *Spooler) work(ctx context.Context) {
+	for {
+		job := s.take(ctx)
+		if job == nil {
+			return
+		}
+
+		err := s.Processor(ctx, *job)
+		s.finish(ctx, job, err)
+	}
+}
+
+// take waits for the next job which should be processed and marks it as processed.
+// It returns nil once ctx is done.
+func (s *Spooler) take(ctx context.Context) *SpoolJob {
+	for {
+		s.mutex.Lock()
+		job, wait := s.next(time.Now())
+		if job != nil {
+			job.State = SpoolJobProcessing
+			err := s.store.UpdateState(job.ID, SpoolJobProcessing)
+			if err != nil {
+				logErrorf("Error marking spooled job %d as processed: %v", job.ID, err)
+			}
+			copied := *job
+			s.mutex.Unlock()
+			return &copied
+		}
+		changed := s.changed
+		s.mutex.Unlock()
+
+		var timer *time.Timer
+		var retry <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			retry = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-changed:
+		case <-retry:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// next returns the pending job which should be processed next at the given time (see before).
+// The jobs of paused queues are skipped.
+// If there is none, it returns the time until the next retry (0 if no job is waiting for a retry).
+// The mutex must be locked.
+func (s *Spooler) next(now time.Time) (*SpoolJob, time.Duration) {
+	var next *SpoolJob
+	var wait time.Duration
+
+	for _, job := range s.jobs {
+		if job.State != SpoolJobPending || s.paused[job.Queue] {
+			continue
+		}
+
+		if job.NextAttempt.After(now) {
+			if until := job.NextAttempt.Sub(now); wait == 0 || until < wait {
+				wait = until
+			}
+			continue
+		}
+
+		if next == nil || s.before(job, next, now) {
+			next = job
+		}
+	}
+
+	return next, wait
+}
+
+// before tells if job a is processed before job b at the given time.
+func (s *Spooler) before(a, b *SpoolJob, now time.Time) bool {
+	priorityA := s.effectivePriority(a, now)
+	priorityB := s.effectivePriority(b, now)
+	if priorityA != priorityB {
+		return priorityA > priorityB
+	}
+
+	return a.ID < b.ID
+}
+
+// effectivePriority returns the priority of the job increased by the PriorityAging for the time it waits.
+func (s *Spooler) effectivePriority(job *SpoolJob, now time.Time) int {
+	if s.PriorityAging <= 0 || !now.After(job.Received) {
+		return job.Priority
+	}
+
+	return job.Priority + int(now.Sub(job.Received)/s.PriorityAging)
+}
+
+// finish stores the result of processing the job: successful jobs are removed,
+// failed jobs are scheduled for a retry or marked as failed.
+func (s *Spooler) finish(ctx context.Context, processed *SpoolJob, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job, ok := s.jobs[processed.ID]
+	if !ok {
+		return
+	}
+
+	if err == nil {
+		logDebugf("Spooled job %d was processed", job.ID)
+		err = s.remove(job)
+		if err != nil {
+			logErrorf("%v", err)
+		}
+		return
+	}
+
+	job.State = SpoolJobPending
+	if ctx.Err() != nil {
+		// the job was interrupted and is processed again by the next Run
+		logDebugf("Processing of spooled job %d was interrupted: %v", job.ID, err)
+	} else {
+		job.Attempts++
+		job.LastError = err.Error()
+		if s.Retry.MaxAttempts > 0 && job.Attempts >= s.Retry.MaxAttempts {
+			logErrorf("Processing of spooled job %d failed %d times, giving up: %v", job.ID, job.Attempts, err)
+			job.State = SpoolJobFailed
+		} else {
+			backoff := s.Retry.backoff(job.Attempts)
+			logErrorf("Processing of spooled job %d failed, retrying in %v: %v", job.ID, backoff, err)
+			job.NextAttempt = time.Now().Add(backoff)
+		}
+	}
+
+	err = s.store.Put(*job)
+	if err != nil {
+		logErrorf("%v", err)
+	}
+	s.notify()
+}
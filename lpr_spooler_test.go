@@ -0,0 +1,443 @@
+package lprlib
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpooler(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+	dir := t.TempDir()
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	// the jobs are spooled, but not processed yet
+	for _, text := range []string{"first job", "second job"} {
+		err = SendStream(strings.NewReader(text), int64(len(text)), "file.txt", "127.0.0.1", port, "raw", "TestUser", time.Minute, WithJobName(text))
+		require.Nil(t, err)
+
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+
+		spooler := &Spooler{Dir: dir}
+		job, err := spooler.Enqueue(conn)
+		require.Nil(t, err)
+		require.Equal(t, SpoolJobPending, job.State)
+		require.Equal(t, "raw", job.Queue)
+		require.Equal(t, "TestUser", job.User)
+		require.Equal(t, text, job.JobName)
+		require.Equal(t, uint64(len(text)), job.Size)
+
+		_, err = os.Stat(conn.SaveName)
+		require.True(t, os.IsNotExist(err))
+	}
+
+	// the jobs survive a restart
+	mutex := sync.Mutex{}
+	processed := []string{}
+	failures := 0
+	done := make(chan struct{})
+
+	spooler := &Spooler{Dir: dir, Daemon: &lprd, Retry: RetryPolicy{InitialBackoff: 10 * time.Millisecond}}
+	spooler.Processor = func(ctx context.Context, job SpoolJob) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if job.JobName == "third job" && failures == 0 {
+			failures++
+			return errors.New("printer offline")
+		}
+
+		data, err := os.ReadFile(job.DataFile)
+		if err != nil {
+			return err
+		}
+		processed = append(processed, string(data))
+		if len(processed) == 3 {
+			close(done)
+		}
+		return nil
+	}
+
+	jobs, err := spooler.Jobs("raw")
+	require.Nil(t, err)
+	require.Equal(t, 2, len(jobs))
+	require.Equal(t, "first job", jobs[0].JobName)
+	require.Equal(t, "second job", jobs[1].JobName)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error)
+	go func() {
+		finished <- spooler.Run(ctx)
+	}()
+
+	// jobs received while running are spooled and retried if the processing fails
+	err = SendStream(strings.NewReader("third job"), 9, "file.txt", "127.0.0.1", port, "raw", "TestUser", time.Minute, WithJobName("third job"))
+	require.Nil(t, err)
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("jobs were not processed")
+	}
+
+	cancel()
+	require.Nil(t, <-finished)
+
+	require.Equal(t, []string{"first job", "second job", "third job"}, processed)
+	require.Equal(t, 1, failures)
+
+	jobs, err = spooler.Jobs("")
+	require.Nil(t, err)
+	require.Equal(t, 0, len(jobs))
+
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(entries))
+}
+
+func TestSpoolerMaxAttempts(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	dir := t.TempDir()
+	attempts := make(chan int, 10)
+
+	spooler := &Spooler{Dir: dir, Retry: RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}}
+	spooler.Processor = func(ctx context.Context, job SpoolJob) error {
+		attempts <- job.Attempts
+		return errors.New("invalid job")
+	}
+
+	job, err := spooler.Enqueue(&LprConnection{PrqName: "raw", Data: []byte("in memory"), Status: End})
+	require.Nil(t, err)
+
+	data, err := os.ReadFile(job.DataFile)
+	require.Nil(t, err)
+	require.Equal(t, "in memory", string(data))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error)
+	go func() {
+		finished <- spooler.Run(ctx)
+	}()
+
+	require.Equal(t, 0, <-attempts)
+	require.Equal(t, 1, <-attempts)
+
+	require.Eventually(t, func() bool {
+		jobs, err := spooler.Jobs("raw")
+		return err == nil && len(jobs) == 1 && jobs[0].State == SpoolJobFailed
+	}, 10*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.Nil(t, <-finished)
+
+	// failed jobs are kept until they are removed
+	spooler = &Spooler{Dir: dir}
+	jobs, err := spooler.Jobs("")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(jobs))
+	require.Equal(t, SpoolJobFailed, jobs[0].State)
+	require.Equal(t, 2, jobs[0].Attempts)
+	require.Equal(t, "invalid job", jobs[0].LastError)
+
+	require.Nil(t, spooler.Remove(jobs[0].ID))
+	require.NotNil(t, spooler.Remove(jobs[0].ID))
+
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(entries))
+}
+
+func TestSpoolerQueueState(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+
+	spooler := &Spooler{Dir: t.TempDir(), Daemon: &lprd}
+	spooler.Processor = func(ctx context.Context, job SpoolJob) error {
+		started <- struct{}{}
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error)
+	go func() {
+		finished <- spooler.Run(ctx)
+	}()
+
+	for _, user := range []string{"alice", "bob", "alice"} {
+		text := "job of " + user
+		err = SendStream(strings.NewReader(text), int64(len(text)), "file.txt", "127.0.0.1", port, "raw", user, time.Minute, WithJobName(text))
+		require.Nil(t, err)
+	}
+	<-started
+
+	require.Eventually(t, func() bool {
+		jobs, err := spooler.Jobs("raw")
+		return err == nil && len(jobs) == 3
+	}, 10*time.Second, 10*time.Millisecond)
+	jobs, err := spooler.Jobs("raw")
+	require.Nil(t, err)
+
+	state, err := GetStatus("127.0.0.1", port, "raw", false, time.Minute)
+	require.Nil(t, err)
+
+	status := ParseQueueState(state)
+	require.Equal(t, []string{"raw is ready and printing"}, status.Messages)
+	require.Equal(t, []QueueJob{
+		{Rank: "active", Owner: "alice", JobNumber: jobs[0].JobNumber, Files: "job of alice", Size: 12},
+		{Rank: "1st", Owner: "bob", JobNumber: jobs[1].JobNumber, Files: "job of bob", Size: 10},
+		{Rank: "2nd", Owner: "alice", JobNumber: jobs[2].JobNumber, Files: "job of alice", Size: 12},
+	}, status.Jobs)
+
+	// only the jobs of the requested users or job numbers are listed
+	state, err = GetStatusFiltered("127.0.0.1", port, "raw", true, []string{"bob"}, time.Minute)
+	require.Nil(t, err)
+	require.Contains(t, state, "bob: 1st")
+	require.Contains(t, state, "[job "+jobs[1].JobNumber+" ")
+	require.NotContains(t, state, "alice")
+
+	state, err = GetStatusFiltered("127.0.0.1", port, "raw", false, []string{jobs[2].JobNumber}, time.Minute)
+	require.Nil(t, err)
+	status = ParseQueueState(state)
+	require.Equal(t, 1, len(status.Jobs))
+	require.Equal(t, "2nd", status.Jobs[0].Rank)
+
+	state, err = GetStatus("127.0.0.1", port, "other", false, time.Minute)
+	require.Nil(t, err)
+	require.Equal(t, "no entries\n", state)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		jobs, err := spooler.Jobs("raw")
+		return err == nil && len(jobs) == 0
+	}, 10*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.Nil(t, <-finished)
+
+	// without running spooler, the daemon answers "Idle"
+	state, err = GetStatus("127.0.0.1", port, "raw", false, time.Minute)
+	require.Nil(t, err)
+	require.Equal(t, "Idle\n", state)
+}
+
+func TestSpoolerPriority(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	spooler := &Spooler{Dir: t.TempDir(), QueuePriorities: map[string]int{"urgent": 10}}
+	for _, queue := range []string{"bulk", "bulk", "urgent"} {
+		_, err := spooler.Enqueue(&LprConnection{PrqName: queue, Data: []byte("job for " + queue), Status: End})
+		require.Nil(t, err)
+	}
+
+	jobs, err := spooler.Jobs("urgent")
+	require.Nil(t, err)
+	require.Equal(t, 10, jobs[0].Priority)
+
+	// urgent jobs are listed and processed first
+	state, err := spooler.QueueState(context.Background(), QueueStateRequest{Queue: "urgent"})
+	require.Nil(t, err)
+	require.Equal(t, "1st", ParseQueueState(state).Jobs[0].Rank)
+
+	processed := make(chan uint64, 10)
+	spooler.Processor = func(ctx context.Context, job SpoolJob) error {
+		processed <- job.ID
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error)
+	go func() {
+		finished <- spooler.Run(ctx)
+	}()
+
+	require.Equal(t, uint64(3), <-processed)
+	require.Equal(t, uint64(1), <-processed)
+	require.Equal(t, uint64(2), <-processed)
+
+	cancel()
+	require.Nil(t, <-finished)
+
+	// jobs waiting long enough overtake newer jobs with a higher priority
+	spooler = &Spooler{Dir: t.TempDir(), Priority: func(job SpoolJob) int {
+		if job.User == "boss" {
+			return 2
+		}
+		return 0
+	}, PriorityAging: time.Hour}
+
+	bulk, err := spooler.Enqueue(&LprConnection{PrqName: "raw", UserIdentification: "clerk", Data: []byte("bulk"), Status: End})
+	require.Nil(t, err)
+	urgent, err := spooler.Enqueue(&LprConnection{PrqName: "raw", UserIdentification: "boss", Data: []byte("urgent"), Status: End})
+	require.Nil(t, err)
+	require.Equal(t, 2, urgent.Priority)
+
+	spooler.mutex.Lock()
+	defer spooler.mutex.Unlock()
+
+	next, _ := spooler.next(bulk.Received.Add(time.Hour))
+	require.Equal(t, urgent.ID, next.ID)
+
+	spooler.jobs[urgent.ID].Received = bulk.Received.Add(3 * time.Hour)
+	next, _ = spooler.next(bulk.Received.Add(3 * time.Hour))
+	require.Equal(t, bulk.ID, next.ID)
+}
+
+func TestSpoolerCancel(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	skipped := make(chan *LprConnection, 10)
+
+	spooler := &Spooler{Dir: t.TempDir(), Daemon: &lprd}
+	spooler.Processor = func(ctx context.Context, job SpoolJob) error {
+		started <- struct{}{}
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	spooler.OnSkipped = func(conn *LprConnection) {
+		skipped <- conn
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error)
+	go func() {
+		finished <- spooler.Run(ctx)
+	}()
+
+	for _, user := range []string{"alice", "bob", "alice", "alice"} {
+		text := "job of " + user
+		err = SendStream(strings.NewReader(text), int64(len(text)), "file.txt", "127.0.0.1", port, "raw", user, time.Minute)
+		require.Nil(t, err)
+	}
+	<-started
+
+	require.Eventually(t, func() bool {
+		jobs, err := spooler.Jobs("raw")
+		return err == nil && len(jobs) == 4
+	}, 10*time.Second, 10*time.Millisecond)
+	jobs, err := spooler.Jobs("raw")
+	require.Nil(t, err)
+
+	// users may only remove their own jobs
+	err = RemoveJobs("127.0.0.1", port, "raw", "bob", []string{jobs[2].JobNumber}, time.Minute)
+	require.Nil(t, err)
+	require.Empty(t, (<-skipped).RemovedJobs)
+
+	err = RemoveJobs("127.0.0.1", port, "raw", "alice", []string{jobs[2].JobNumber}, time.Minute)
+	require.Nil(t, err)
+	require.Equal(t, []string{jobs[2].JobNumber}, (<-skipped).RemovedJobs)
+
+	err = RemoveJobs("127.0.0.1", port, "raw", SuperUser, []string{"bob"}, time.Minute)
+	require.Nil(t, err)
+	require.Equal(t, []string{jobs[1].JobNumber}, (<-skipped).RemovedJobs)
+
+	// without list, the first job of the agent which isn't processed is removed
+	err = RemoveJobs("127.0.0.1", port, "raw", "alice", nil, time.Minute)
+	require.Nil(t, err)
+	require.Equal(t, []string{jobs[3].JobNumber}, (<-skipped).RemovedJobs)
+
+	remaining, err := spooler.Jobs("raw")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(remaining))
+	require.Equal(t, jobs[0].ID, remaining[0].ID)
+	require.Equal(t, SpoolJobProcessing, remaining[0].State)
+
+	close(release)
+	cancel()
+	require.Nil(t, <-finished)
+}
+
+func TestSpoolerPause(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	processed := make(chan string, 10)
+	spooler := &Spooler{Dir: t.TempDir()}
+	spooler.Processor = func(ctx context.Context, job SpoolJob) error {
+		processed <- job.Queue
+		return nil
+	}
+
+	spooler.Pause("paused")
+	require.True(t, spooler.Paused("paused"))
+	require.False(t, spooler.Paused("other"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error)
+	go func() {
+		finished <- spooler.Run(ctx)
+	}()
+
+	// jobs of paused queues are enqueued, but not processed
+	for _, queue := range []string{"paused", "other"} {
+		_, err := spooler.Enqueue(&LprConnection{PrqName: queue, Data: []byte("job"), Status: End})
+		require.Nil(t, err)
+	}
+	require.Equal(t, "other", <-processed)
+
+	state, err := spooler.QueueState(ctx, QueueStateRequest{Queue: "paused"})
+	require.Nil(t, err)
+	status := ParseQueueState(state)
+	require.Equal(t, []string{"Warning: paused is down: printing paused"}, status.Messages)
+	require.Equal(t, 1, len(status.Jobs))
+	require.Equal(t, "1st", status.Jobs[0].Rank)
+
+	select {
+	case queue := <-processed:
+		t.Fatalf("job of queue %s was processed", queue)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	spooler.Resume("paused")
+	require.Equal(t, "paused", <-processed)
+
+	state, err = spooler.QueueState(ctx, QueueStateRequest{Queue: "paused"})
+	require.Nil(t, err)
+	require.NotContains(t, state, "Warning")
+
+	cancel()
+	require.Nil(t, <-finished)
+}
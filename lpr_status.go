@@ -1,15 +1,29 @@
 package lprlib
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
+	"strings"
 	"time"
 )
 
 // GetStatus Reads the Status from the printer
 func GetStatus(hostname string, port uint16, queue string, long bool, timeout time.Duration) (string, error) {
+	return GetStatusFiltered(hostname, port, queue, long, nil, timeout)
+}
+
+// GetStatusFiltered reads the status of the queue like GetStatus, but only requests the jobs
+// of the given user names or job numbers (see LprSend.JobNumber).
+// If the list is empty, all jobs are requested.
+func GetStatusFiltered(hostname string, port uint16, queue string, long bool, list []string, timeout time.Duration) (string, error) {
+	return GetStatusTLS(hostname, port, queue, long, list, timeout, nil)
+}
 
+// GetStatusTLS reads the status of the queue like GetStatusFiltered, but connects to the printer
+// using TLS if config is set. If the ServerName of the config is empty, the hostname is used.
+func GetStatusTLS(hostname string, port uint16, queue string, long bool, list []string, timeout time.Duration, config *tls.Config) (string, error) {
 	// Set default Port
 	if port == 0 {
 		port = 515
@@ -20,14 +34,12 @@ func GetStatus(hostname string, port uint16, queue string, long bool, timeout ti
 		queue = "raw"
 	}
 
-	var code byte
-	if long {
-		code = byte(4)
-	} else {
-		code = byte(3)
-	}
+	logDebugf("Checking status of LPR printer %s, port %d, queue %s, list %v, long flag %v and timeout %v", hostname, port, queue, list, long, timeout)
 
-	logDebugf("Checking status of LPR printer %s, port %d, queue %s, long flag %v and timeout %v", hostname, port, queue, long, timeout)
+	command, err := statusCommand(queue, long, list)
+	if err != nil {
+		return "", err
+	}
 
 	// Set default time.Duration
 	var timeoutDuration time.Duration
@@ -45,6 +57,15 @@ func GetStatus(hostname string, port uint16, queue string, long bool, timeout ti
 		return "", &LprError{"Can't reach printer: " + err.Error()}
 	}
 
+	if config != nil {
+		tlsSocket, err := clientTLS(socket, config, hostname, timeoutDuration)
+		if err != nil {
+			socket.Close()
+			return "", err
+		}
+		socket = tlsSocket
+	}
+
 	defer socket.Close()
 
 	// Command:
@@ -68,8 +89,6 @@ func GetStatus(hostname string, port uint16, queue string, long bool, timeout ti
 	**/
 
 	socket.SetWriteDeadline(time.Now().Add(timeoutDuration))
-	// List items are not used because they only filter the output
-	command := fmt.Sprintf("%c%s\n", code, queue)
 	logDebugf("Sending command %s to printer", command)
 	_, err = socket.Write([]byte(command))
 	if err != nil {
@@ -96,3 +115,76 @@ func GetStatus(hostname string, port uint16, queue string, long bool, timeout ti
 	logDebugf("Final result: %s", ret)
 	return ret, nil
 }
+
+// QueueState reads the state of the queue (03 / 04 - Send queue state) over the connection of the
+// LprSend, e.g. to validate the queue right before printing. It must be called before a job is started.
+// Reading the queue state and sending a job over a single connection is impossible: RFC-1179
+// (chapter 5.3) allows only one command per connection and the printer closes the connection after
+// sending the queue state. Therefore QueueState reconnects to the printer afterwards (a second TCP
+// handshake), so a job may be sent as usual. If the reconnect fails, an error is returned and the
+// LprSend can only be closed.
+func (lpr *LprSend) QueueState(long bool, list []string) (string, error) {
+	if lpr.printJobStarted {
+		return "", &LprError{"Can't read the queue state after a job was started"}
+	}
+
+	command, err := statusCommand(lpr.queue, long, list)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = lpr.writeString(command)
+	if err != nil {
+		return "", &LprError{"Can't write to printer: " + err.Error()}
+	}
+
+	var state []byte
+	buffer := make([]byte, 4096)
+	for {
+		err = lpr.socket.SetReadDeadline(time.Now().Add(lpr.Timeout))
+		if err != nil {
+			return "", &LprError{err.Error()}
+		}
+
+		n, err := lpr.socket.Read(buffer)
+		state = append(state, buffer[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return string(state), &LprError{"Error while reading status: " + err.Error()}
+		}
+	}
+	logDebugf("Queue state: %s", state)
+
+	socket := lpr.socket
+	socket.Close()
+	err = lpr.connect(lpr.hostname, lpr.port)
+	if err != nil {
+		// the closed socket is kept, so Close can still be called
+		lpr.socket = socket
+		return string(state), &LprError{"Can't reconnect to printer: " + err.Error()}
+	}
+
+	return string(state), nil
+}
+
+// statusCommand returns the command requesting the short or long queue state
+// of the given user names or job numbers.
+func statusCommand(queue string, long bool, list []string) (string, error) {
+	for _, item := range list {
+		if item == "" || strings.ContainsAny(item, " \t\n\x00") {
+			return "", &LprError{fmt.Sprintf("Invalid list item %q", item)}
+		}
+	}
+
+	code := byte(3)
+	if long {
+		code = byte(4)
+	}
+
+	if len(list) > 0 {
+		return fmt.Sprintf("%c%s %s\n", code, queue, strings.Join(list, " ")), nil
+	}
+	return fmt.Sprintf("%c%s\n", code, queue), nil
+}
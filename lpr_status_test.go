@@ -1,7 +1,14 @@
 package lprlib
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
 	"log"
+	"net"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -63,3 +70,162 @@ func TestGetStatus(t *testing.T) {
 
 	lprd.Close()
 }
+
+func TestGetStatusContext(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var lprd LprDaemon
+	lprd.GetQueueStateContext = func(ctx context.Context, request QueueStateRequest) (string, error) {
+		require.NotNil(t, ctx)
+		require.NotNil(t, request.RemoteAddr)
+		if request.Queue != "raw" {
+			return "", fmt.Errorf("unknown queue %s", request.Queue)
+		}
+		return fmt.Sprintf("%s %v %v\n", request.Queue, request.List, request.Long), nil
+	}
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	socket, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.Nil(t, err)
+	defer socket.Close()
+
+	_, err = socket.Write([]byte("\x04raw alice  12\n"))
+	require.Nil(t, err)
+
+	state, err := io.ReadAll(socket)
+	require.Nil(t, err)
+	require.Equal(t, "raw [alice 12] true\n", string(state))
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+
+	status, err := GetStatus("127.0.0.1", port, "raw", false, 2*time.Second)
+	require.Nil(t, err)
+	require.Equal(t, "raw [] false\n", status)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+
+	status, err = GetStatusFiltered("127.0.0.1", port, "raw", false, []string{"bob", "123"}, 2*time.Second)
+	require.Nil(t, err)
+	require.Equal(t, "raw [bob 123] false\n", status)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+
+	_, err = GetStatusFiltered("127.0.0.1", port, "raw", false, []string{"bob smith"}, 2*time.Second)
+	require.NotNil(t, err)
+
+	status, _ = GetStatus("127.0.0.1", port, "unknown", false, 2*time.Second)
+	require.Empty(t, status)
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, Error, conn.Status)
+}
+
+func TestGetStatusTLS(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	cert, pool := generateTestCertificate(t)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	require.Nil(t, err)
+
+	var lprd LprDaemon
+	lprd.GetQueueState = func(queue string, list string, long bool) string {
+		return "no entries\n"
+	}
+	err = lprd.ServeListener(listener)
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+	status, err := GetStatusTLS("127.0.0.1", port, "raw", false, nil, 2*time.Second, &tls.Config{RootCAs: pool})
+	require.Nil(t, err)
+	require.Equal(t, "no entries\n", status)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+
+	// the certificate must be trusted
+	_, err = GetStatusTLS("127.0.0.1", port, "raw", false, nil, 2*time.Second, &tls.Config{})
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "TLS handshake failed")
+}
+
+func TestSendQueueState(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	text := "Text for the file"
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.GetQueueState = func(queue string, list string, long bool) string {
+		return fmt.Sprintf("%s %s %v\n", queue, list, long)
+	}
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	state, err := lprs.QueueState(true, []string{"TestUser"})
+	require.Nil(t, err)
+	require.Equal(t, "raw TestUser true\n", state)
+
+	conn := <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+
+	// the job is sent over the new connection
+	require.Nil(t, lprs.SendConfiguration())
+	require.Nil(t, lprs.SendReader(strings.NewReader(text), int64(len(text))))
+
+	_, err = lprs.QueueState(false, nil)
+	require.NotNil(t, err)
+	require.Nil(t, lprs.Close())
+
+	conn = <-lprd.FinishedConnections()
+	require.Equal(t, End, conn.Status)
+	require.Nil(t, os.Remove(conn.SaveName))
+}
+
+func TestSendQueueStateReconnectFails(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	// the printer stops listening after answering the queue state
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+
+	go func() {
+		socket, err := listener.Accept()
+		listener.Close()
+		if err != nil {
+			return
+		}
+		defer socket.Close()
+
+		command := make([]byte, 100)
+		socket.Read(command)
+		socket.Write([]byte("Idle\n"))
+	}()
+
+	var lprs LprSend
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+
+	state, err := lprs.QueueState(false, nil)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "Can't reconnect to printer")
+	require.Equal(t, "Idle\n", state)
+
+	// the LprSend can still be closed
+	lprs.Close()
+}
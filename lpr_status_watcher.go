@@ -0,0 +1,189 @@
+package lprlib
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultWatchInterval is the interval the StatusWatcher polls the printers with,
+// if StatusWatcher.Interval is not set.
+const DefaultWatchInterval = 30 * time.Second
+
+// DefaultStatusEventsSize is the capacity of the channel returned by StatusWatcher.Events.
+const DefaultStatusEventsSize = 100
+
+// StatusEventType is the type of a StatusEvent.
+type StatusEventType int
+
+const (
+	// JobAppeared means, that a job was listed in the queue state for the first time.
+	JobAppeared StatusEventType = 0
+
+	// JobDisappeared means, that a job is no longer listed in the queue state,
+	// usually because it was printed (or removed).
+	JobDisappeared StatusEventType = 1
+
+	// PrinterUnreachable means, that the queue state could not be read (see StatusEvent.Err).
+	PrinterUnreachable StatusEventType = 2
+
+	// PrinterReachable means, that the queue state could be read again after the printer was unreachable.
+	PrinterReachable StatusEventType = 3
+)
+
+// String returns the name of the event type.
+func (t StatusEventType) String() string {
+	switch t {
+	case JobAppeared:
+		return "JobAppeared"
+	case JobDisappeared:
+		return "JobDisappeared"
+	case PrinterUnreachable:
+		return "PrinterUnreachable"
+	case PrinterReachable:
+		return "PrinterReachable"
+	default:
+		return "Unknown"
+	}
+}
+
+// WatchedPrinter is a queue of a printer watched by the StatusWatcher.
+type WatchedPrinter struct {
+	Hostname string
+	Port     uint16
+	Queue    string
+}
+
+// StatusEvent describes a change of the queue state of a WatchedPrinter.
+type StatusEvent struct {
+	// Type is the type of the event
+	Type StatusEventType
+
+	// Printer is the printer whose queue state changed
+	Printer WatchedPrinter
+
+	// Job is the job which appeared or disappeared
+	Job QueueJob
+
+	// Err is the reason why the printer is unreachable (PrinterUnreachable only)
+	Err error
+}
+
+// StatusWatcher polls the short queue state of printers (see GetStatus) and reports the changes
+// as StatusEvents. The fields must be set before calling Start.
+type StatusWatcher struct {
+	// Printers contains the printers to watch
+	Printers []WatchedPrinter
+
+	// Interval is the time between two queue state requests of a printer.
+	// If 0, DefaultWatchInterval is used.
+	Interval time.Duration
+
+	// Timeout is the timeout of a single queue state request (see GetStatus).
+	Timeout time.Duration
+
+	events    chan StatusEvent
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// Start starts polling the Printers until Close is called.
+func (w *StatusWatcher) Start() {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	var ctx context.Context
+	ctx, w.cancel = context.WithCancel(context.Background())
+	w.events = make(chan StatusEvent, DefaultStatusEventsSize)
+
+	for _, printer := range w.Printers {
+		w.wg.Add(1)
+		go w.watch(ctx, printer, interval)
+	}
+}
+
+// Events returns the channel the StatusEvents are sent to.
+// It is closed after Close was called.
+func (w *StatusWatcher) Events() <-chan StatusEvent {
+	return w.events
+}
+
+// Close stops polling the printers and closes the Events channel.
+// It may be called more than once and without Start.
+func (w *StatusWatcher) Close() {
+	w.closeOnce.Do(func() {
+		if w.cancel == nil {
+			// not started
+			return
+		}
+
+		w.cancel()
+		w.wg.Wait()
+		close(w.events)
+	})
+}
+
+// watch polls the queue state of the printer until ctx is canceled.
+func (w *StatusWatcher) watch(ctx context.Context, printer WatchedPrinter, interval time.Duration) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	jobs := map[string]QueueJob{}
+	reachable := true
+	for {
+		state, err := GetStatus(printer.Hostname, printer.Port, printer.Queue, false, w.Timeout)
+		if err != nil {
+			logDebugf("Can't read queue state of %s, queue %s: %v", printer.Hostname, printer.Queue, err)
+			if reachable {
+				reachable = false
+				w.send(ctx, StatusEvent{Type: PrinterUnreachable, Printer: printer, Err: err})
+			}
+		} else {
+			if !reachable {
+				reachable = true
+				w.send(ctx, StatusEvent{Type: PrinterReachable, Printer: printer})
+			}
+			jobs = w.diff(ctx, printer, jobs, ParseQueueState(state))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// diff sends the events for the jobs which appeared or disappeared since the previous
+// queue state and returns the jobs of the current one.
+func (w *StatusWatcher) diff(ctx context.Context, printer WatchedPrinter, previous map[string]QueueJob, status *QueueStatus) map[string]QueueJob {
+	current := make(map[string]QueueJob, len(status.Jobs))
+	for _, job := range status.Jobs {
+		key := job.Owner + " " + job.JobNumber
+		current[key] = job
+		if _, ok := previous[key]; !ok {
+			w.send(ctx, StatusEvent{Type: JobAppeared, Printer: printer, Job: job})
+		}
+	}
+
+	for key, job := range previous {
+		if _, ok := current[key]; !ok {
+			w.send(ctx, StatusEvent{Type: JobDisappeared, Printer: printer, Job: job})
+		}
+	}
+
+	return current
+}
+
+// send sends the event unless the watcher is closed.
+func (w *StatusWatcher) send(ctx context.Context, event StatusEvent) {
+	select {
+	case w.events <- event:
+	case <-ctx.Done():
+	}
+}
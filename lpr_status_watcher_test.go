@@ -0,0 +1,136 @@
+package lprlib
+
+import (
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQueueState(t *testing.T) {
+	status := ParseQueueState("printer is ready and printing\n" +
+		"Rank   Owner      Job  Files                                 Total Size\n" +
+		"active alice      123  report.pdf                            12345 bytes\n" +
+		"1st    bob        124  letter one.txt                        678 bytes\n")
+
+	require.Equal(t, []string{"printer is ready and printing"}, status.Messages)
+	require.Equal(t, []QueueJob{
+		{Rank: "active", Owner: "alice", JobNumber: "123", Files: "report.pdf", Size: 12345},
+		{Rank: "1st", Owner: "bob", JobNumber: "124", Files: "letter one.txt", Size: 678},
+	}, status.Jobs)
+
+	status = ParseQueueState("no entries\n")
+	require.Empty(t, status.Jobs)
+	require.Equal(t, []string{"no entries"}, status.Messages)
+}
+
+func TestFormatQueueState(t *testing.T) {
+	jobs := []QueueJob{
+		{Rank: "active", Owner: "alice", JobNumber: "123", Files: "report.pdf", Size: 12345, Host: "workstation"},
+		{Rank: queueRank(1), Owner: "bob", JobNumber: "124", Files: strings.Repeat("letter ", 10), Size: 678},
+	}
+
+	state := FormatQueueState(jobs, false)
+	require.Equal(t, "Rank   Owner      Job  Files                                 Total Size\n"+
+		"active alice      123  report.pdf                            12345 bytes\n"+
+		"1st    bob        124  letter letter letter letter letter... 678 bytes\n", state)
+
+	status := ParseQueueState(state)
+	require.Empty(t, status.Messages)
+	require.Equal(t, 2, len(status.Jobs))
+	require.Equal(t, "report.pdf", status.Jobs[0].Files)
+	require.Equal(t, int64(678), status.Jobs[1].Size)
+
+	require.Equal(t, "\nalice: active                           [job 123 workstation]\n"+
+		"        report.pdf                      12345 bytes\n", FormatQueueState(jobs[:1], true))
+
+	require.Equal(t, "no entries\n", FormatQueueState(nil, false))
+
+	require.Equal(t, "2nd", queueRank(2))
+	require.Equal(t, "3rd", queueRank(3))
+	require.Equal(t, "11th", queueRank(11))
+	require.Equal(t, "22nd", queueRank(22))
+}
+
+func TestStatusWatcher(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	var mutex sync.Mutex
+	state := "Rank   Owner      Job  Files                                 Total Size\n" +
+		"active alice      123  report.pdf                            12345 bytes\n"
+
+	var lprd LprDaemon
+	lprd.GetQueueState = func(queue string, list string, long bool) string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return state
+	}
+	err := lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+	go func() {
+		for range lprd.FinishedConnections() {
+		}
+	}()
+
+	// nothing listens on the port of the closed listener
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	unreachablePort := uint16(listener.Addr().(*net.TCPAddr).Port)
+	require.Nil(t, listener.Close())
+
+	printer := WatchedPrinter{Hostname: "127.0.0.1", Port: port, Queue: "raw"}
+	unreachable := WatchedPrinter{Hostname: "127.0.0.1", Port: unreachablePort, Queue: "raw"}
+	watcher := StatusWatcher{
+		Printers: []WatchedPrinter{printer, unreachable},
+		Interval: 50 * time.Millisecond,
+		Timeout:  time.Second,
+	}
+	watcher.Start()
+
+	next := func(printer WatchedPrinter) StatusEvent {
+		for event := range watcher.Events() {
+			if event.Printer == printer {
+				return event
+			}
+		}
+		t.Fatal("events closed")
+		return StatusEvent{}
+	}
+
+	event := next(unreachable)
+	require.Equal(t, PrinterUnreachable, event.Type)
+	require.NotNil(t, event.Err)
+
+	event = next(printer)
+	require.Equal(t, JobAppeared, event.Type)
+	require.Equal(t, "123", event.Job.JobNumber)
+
+	mutex.Lock()
+	state = "Rank   Owner      Job  Files                                 Total Size\n" +
+		"active bob        124  letter.txt                            678 bytes\n"
+	mutex.Unlock()
+
+	// the order of the events of one poll is not defined
+	events := map[StatusEventType]string{}
+	for i := 0; i < 2; i++ {
+		event = next(printer)
+		events[event.Type] = event.Job.JobNumber
+	}
+	require.Equal(t, map[StatusEventType]string{JobAppeared: "124", JobDisappeared: "123"}, events)
+
+	watcher.Close()
+	_, ok := <-watcher.Events()
+	require.False(t, ok)
+
+	// Close may be called again and without Start
+	watcher.Close()
+	unstarted := StatusWatcher{Printers: []WatchedPrinter{printer}}
+	unstarted.Close()
+}
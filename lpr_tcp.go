@@ -0,0 +1,36 @@
+package lprlib
+
+import (
+	"net"
+	"time"
+)
+
+// configureTCP sets the keepalive and no-delay options of a TCP connection.
+// A positive keepAlive enables keepalive probes with this period, a negative one disables them
+// and 0 keeps the default of the operating system. Other connections are left unchanged.
+func configureTCP(conn net.Conn, keepAlive time.Duration, disableNoDelay bool) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	if keepAlive > 0 {
+		err := tcpConn.SetKeepAlive(true)
+		if err == nil {
+			err = tcpConn.SetKeepAlivePeriod(keepAlive)
+		}
+		if err != nil {
+			logErrorf("Error enabling TCP keepalive: %v", err)
+		}
+	} else if keepAlive < 0 {
+		err := tcpConn.SetKeepAlive(false)
+		if err != nil {
+			logErrorf("Error disabling TCP keepalive: %v", err)
+		}
+	}
+
+	err := tcpConn.SetNoDelay(!disableNoDelay)
+	if err != nil {
+		logErrorf("Error setting TCP no-delay: %v", err)
+	}
+}
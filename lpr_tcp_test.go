@@ -0,0 +1,57 @@
+//go:build linux
+
+package lprlib
+
+import (
+	"log"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// tcpOption reads an integer socket option of the connection.
+func tcpOption(t *testing.T, conn net.Conn, level int, option int) int {
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	require.Nil(t, err)
+
+	var value int
+	var optErr error
+	err = raw.Control(func(fd uintptr) {
+		value, optErr = syscall.GetsockoptInt(int(fd), level, option)
+	})
+	require.Nil(t, err)
+	require.Nil(t, optErr)
+	return value
+}
+
+func TestSendTCPOptions(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+
+	var lprs LprSend
+	lprs.KeepAlive = 42 * time.Second
+	lprs.DisableNoDelay = true
+	err = lprs.Init("127.0.0.1", "", port, "raw", "TestUser", time.Minute)
+	require.Nil(t, err)
+	defer lprs.Close()
+
+	require.Equal(t, 1, tcpOption(t, lprs.socket, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE))
+	require.Equal(t, 42, tcpOption(t, lprs.socket, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE))
+	require.Equal(t, 0, tcpOption(t, lprs.socket, syscall.IPPROTO_TCP, syscall.TCP_NODELAY))
+
+	// the accepted connection of the daemon
+	conn, err := listener.Accept()
+	require.Nil(t, err)
+	defer conn.Close()
+
+	configureTCP(conn, -1, false)
+	require.Equal(t, 0, tcpOption(t, conn, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE))
+	require.Equal(t, 1, tcpOption(t, conn, syscall.IPPROTO_TCP, syscall.TCP_NODELAY))
+}
@@ -0,0 +1,49 @@
+package lprlib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// UnlinkSink can be implemented by the writers returned by a DataSinkFactory to be notified,
+// that the data file should be removed, because it was printed and the client requested it
+// (U - Unlink data file). Unlink is called by LprConnection.Printed after the writer was closed.
+type UnlinkSink interface {
+	Unlink() error
+}
+
+// Printed should be called by the application once the job was printed.
+// If LprDaemon.HonorUnlink is set and the client requested to remove the data file (see Unlink),
+// the saved data and control files are removed (or the UnlinkSink is notified) and Data is released.
+func (lpr *LprConnection) Printed() error {
+	if !lpr.daemon.HonorUnlink || !lpr.Unlink {
+		return nil
+	}
+
+	lpr.Data = nil
+
+	if lpr.unlinkSink != nil {
+		err := lpr.unlinkSink.Unlink()
+		if err != nil {
+			return fmt.Errorf("error unlinking data file %s: %w", lpr.DataFileName, err)
+		}
+
+		return nil
+	}
+
+	for _, fileName := range []string{lpr.SaveName, lpr.ControlFileSaveName} {
+		if fileName == "" {
+			continue
+		}
+
+		err := os.Remove(fileName)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error removing file %s: %w", fileName, err)
+		}
+
+		logDebugf("Removed file %s", fileName)
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package lprlib
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type unlinkBufferSink struct {
+	bytes.Buffer
+	unlinked bool
+}
+
+func (s *unlinkBufferSink) Close() error {
+	return nil
+}
+
+func (s *unlinkBufferSink) Unlink() error {
+	s.unlinked = true
+	return nil
+}
+
+func TestDaemonHonorUnlink(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	name, err := generateTempFile("", "", "Text for the file")
+	require.Nil(t, err)
+	defer os.Remove(name)
+
+	var lprd LprDaemon
+	lprd.InputFileSaveDir = t.TempDir()
+	lprd.SaveControlFile = true
+	lprd.HonorUnlink = true
+	err = lprd.Init(port, "")
+	require.Nil(t, err)
+	defer lprd.Close()
+
+	send := func(unlink bool) *LprConnection {
+		var lprs LprSend
+		err := lprs.Init("127.0.0.1", name, port, "raw", "TestUser", time.Minute)
+		require.Nil(t, err)
+		if unlink {
+			lprs.Config['U'] = lprs.Config['l']
+		}
+
+		require.Nil(t, lprs.SendConfiguration())
+		require.Nil(t, lprs.SendFile())
+		require.Nil(t, lprs.Close())
+
+		conn := <-lprd.FinishedConnections()
+		require.Equal(t, End, conn.Status)
+		require.Equal(t, unlink, conn.Unlink)
+
+		return conn
+	}
+
+	// the files are kept without unlink request
+	conn := send(false)
+	require.Nil(t, conn.Printed())
+	require.FileExists(t, conn.SaveName)
+	require.FileExists(t, conn.ControlFileSaveName)
+
+	conn = send(true)
+	require.FileExists(t, conn.SaveName)
+	require.Nil(t, conn.Printed())
+	require.NoFileExists(t, conn.SaveName)
+	require.NoFileExists(t, conn.ControlFileSaveName)
+
+	// the unlink request is passed to the data sink
+	sink := &unlinkBufferSink{}
+	lprd.DataSinkFactory = func(job *LprConnection) (io.WriteCloser, error) {
+		return sink, nil
+	}
+
+	conn = send(true)
+	require.False(t, sink.unlinked)
+	require.Nil(t, conn.Printed())
+	require.True(t, sink.unlinked)
+}
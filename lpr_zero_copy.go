@@ -0,0 +1,92 @@
+package lprlib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// zeroCopyChunkSize is the number of bytes copied from the connection into the data file at once
+// by copyDataFile. The progress is reported after every chunk. If the progress is reported,
+// the first chunk (and every chunk if ProgressInterval is 0) has the size of the receive buffer
+// to report the same blocks as the buffered receive.
+const zeroCopyChunkSize = 4 * 1024 * 1024
+
+// canCopyDataFile tells if the data file can be copied directly from the connection into the output file.
+// This requires a known size (no dummy size, see LprDaemon.WindowsQuirks), a plain output file
+// (no compression, memory or custom sink), no hashing and no inspection of the data (e.g. LprDaemon.DetectFormat),
+// because the data is not passing through the daemon. As the read timeout applies to single reads,
+// it is not supported either.
+func (lpr *LprConnection) canCopyDataFile() bool {
+	if lpr.daemon.DisableZeroCopy || lpr.Filesize == 0 || lpr.dummyFileSize() || lpr.hash != nil || lpr.headSize() > 0 || lpr.daemon.readTimeout > 0 {
+		return false
+	}
+
+	_, ok := lpr.sink.(*os.File)
+	return ok
+}
+
+// copyDataFile copies the announced number of bytes of the data file from the connection into the output file.
+// On Linux, the data is spliced from the socket into the file without copying it into user space
+// (see os.File.ReadFrom), other platforms fall back to a regular copy.
+// The terminating 0x00 byte is not read.
+func (lpr *LprConnection) copyDataFile() error {
+	file := lpr.sink.(*os.File)
+
+	// bytes which were already read from the connection have to be written first
+	for lpr.reader.Buffered() > 0 && lpr.processedDataBytes < lpr.Filesize {
+		buffered, _ := lpr.reader.Peek(lpr.reader.Buffered())
+		if remaining := lpr.Filesize - lpr.processedDataBytes; uint64(len(buffered)) > remaining {
+			buffered = buffered[:remaining]
+		}
+
+		_, err := file.Write(buffered)
+		if err != nil {
+			return fmt.Errorf("write failed: %w", err)
+		}
+
+		lpr.processedDataBytes += uint64(len(buffered))
+		lpr.reader.Discard(len(buffered))
+	}
+
+	// the deadline of the idle timeout or MaxCommandDuration left by reading the command
+	// must not limit the transfer, which isn't limited by a read timeout
+	err := lpr.setReadTimeout(0)
+	if err != nil {
+		return err
+	}
+
+	chunkSize := uint64(zeroCopyChunkSize)
+	if lpr.daemon.OnProgress != nil || lpr.daemon.OnJobEvent != nil {
+		chunkSize = uint64(lpr.BufferSize)
+	}
+
+	for lpr.processedDataBytes < lpr.Filesize {
+		chunk := lpr.Filesize - lpr.processedDataBytes
+		if chunk > chunkSize {
+			chunk = chunkSize
+		}
+
+		n, err := io.CopyN(file, lpr.Connection, int64(chunk))
+		lpr.processedDataBytes += uint64(n)
+		if errors.Is(err, io.EOF) && lpr.acceptsIncompleteDataFile() {
+			// the client closed the connection early, which is detected by the following read
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error copying data: %w", err)
+		}
+
+		// the last chunk is reported together with the terminating 0x00 byte
+		if lpr.processedDataBytes < lpr.Filesize {
+			lpr.reportProgress(false)
+		}
+
+		if lpr.daemon.ProgressInterval > 0 {
+			chunkSize = zeroCopyChunkSize
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package lprlib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemonZeroCopy(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+
+	data := make([]byte, 10*1024*1024+123)
+	_, err := rand.Read(data)
+	require.Nil(t, err)
+
+	file, err := os.CreateTemp(t.TempDir(), "")
+	require.Nil(t, err)
+	_, err = file.Write(data)
+	require.Nil(t, err)
+	require.Nil(t, file.Close())
+
+	for _, disable := range []bool{false, true} {
+		t.Run(fmt.Sprintf("disabled=%v", disable), func(t *testing.T) {
+			progress := []uint64{}
+
+			lprd := &LprDaemon{}
+			lprd.InputFileSaveDir = t.TempDir()
+			lprd.AtomicWrites = true
+			lprd.DisableZeroCopy = disable
+			lprd.ProgressInterval = time.Hour
+			lprd.OnProgress = func(job *LprConnection, bytesReceived uint64, totalBytes uint64) {
+				progress = append(progress, bytesReceived)
+			}
+			err := lprd.Init(port, "")
+			require.Nil(t, err)
+			defer lprd.Close()
+
+			err = Send(file.Name(), "127.0.0.1", port, "raw", "TestUser", time.Minute)
+			require.Nil(t, err)
+
+			conn := <-lprd.FinishedConnections()
+			require.Equal(t, End, conn.Status)
+			require.NotEmpty(t, progress)
+			require.Equal(t, uint64(len(data)), progress[len(progress)-1])
+
+			received, err := os.ReadFile(conn.SaveName)
+			require.Nil(t, err)
+			require.True(t, bytes.Equal(data, received))
+		})
+	}
+}
+
+func BenchmarkDaemonReceive(b *testing.B) {
+	port := uint16(2345)
+
+	data := make([]byte, 64*1024*1024)
+	_, err := rand.Read(data)
+	require.Nil(b, err)
+
+	file, err := os.CreateTemp(b.TempDir(), "")
+	require.Nil(b, err)
+	_, err = file.Write(data)
+	require.Nil(b, err)
+	require.Nil(b, file.Close())
+
+	for _, disable := range []bool{false, true} {
+		name := "zerocopy"
+		if disable {
+			name = "buffered"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			lprd := &LprDaemon{}
+			lprd.InputFileSaveDir = b.TempDir()
+			lprd.DisableZeroCopy = disable
+			lprd.ReceiveBufferSize = 256 * 1024
+			err := lprd.Init(port, "")
+			require.Nil(b, err)
+			defer lprd.Close()
+
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				err = Send(file.Name(), "127.0.0.1", port, "raw", "TestUser", time.Minute)
+				require.Nil(b, err)
+
+				conn := <-lprd.FinishedConnections()
+				require.Equal(b, End, conn.Status)
+				require.Nil(b, os.Remove(conn.SaveName))
+			}
+		})
+	}
+}
+
+// slowReader returns its data in chunks of the given size with a delay before each chunk.
+type slowReader struct {
+	data  []byte
+	chunk int
+	delay time.Duration
+}
+
+func (r *slowReader) Read(buffer []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+
+	time.Sleep(r.delay)
+
+	n := r.chunk
+	if n > len(buffer) {
+		n = len(buffer)
+	}
+	if n > len(r.data) {
+		n = len(r.data)
+	}
+	copy(buffer, r.data[:n])
+	r.data = r.data[n:]
+
+	return n, nil
+}
+
+func TestDaemonZeroCopyIdleTimeout(t *testing.T) {
+	SetDebugLogger(log.Print)
+
+	port := uint16(2345)
+	text := bytes.Repeat([]byte("Text for the file\n"), 10)
+
+	for _, disable := range []bool{false, true} {
+		t.Run(fmt.Sprintf("disabled=%v", disable), func(t *testing.T) {
+			// the data file takes longer than the idle timeout, which only limits waiting for commands
+			lprd := &LprDaemon{}
+			lprd.InputFileSaveDir = t.TempDir()
+			lprd.DisableZeroCopy = disable
+			lprd.SetConnectionTimeout(0, 200*time.Millisecond)
+			err := lprd.Init(port, "")
+			require.Nil(t, err)
+			defer lprd.Close()
+
+			reader := &slowReader{data: text, chunk: len(text) / 5, delay: 100 * time.Millisecond}
+			err = SendStream(reader, int64(len(text)), "file.txt", "127.0.0.1", port, "raw", "TestUser", time.Minute)
+			require.Nil(t, err)
+
+			conn := <-lprd.FinishedConnections()
+			require.Equal(t, End, conn.Status)
+
+			data, err := os.ReadFile(conn.SaveName)
+			require.Nil(t, err)
+			require.Equal(t, text, data)
+		})
+	}
+}
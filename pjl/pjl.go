@@ -0,0 +1,245 @@
+// Package pjl parses the PJL (Printer Job Language) job headers which printer drivers put in front of
+// the actual print data, e.g.
+//
+//	<ESC>%-12345X@PJL JOB NAME="Invoice"
+//	@PJL SET COPIES=2
+//	@PJL SET DUPLEX=ON
+//	@PJL ENTER LANGUAGE=PCL
+//
+// Many drivers (e.g. the ones of Windows) only send the real job information there
+// and not in the control file of the LPR job.
+package pjl
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// UEL is the Universal Exit Language command, which starts and ends PJL jobs.
+const UEL = "\x1b%-12345X"
+
+// MaxHeaderSize is the maximum number of bytes at the start of a data file which are searched
+// for the PJL header.
+const MaxHeaderSize = 64 * 1024
+
+// prefix starts every PJL command line.
+const prefix = "@PJL"
+
+// ErrNoHeader is returned by Parse if the data does not start with a PJL header.
+var ErrNoHeader = errors.New("no PJL header found")
+
+// Header contains the information of a PJL job header.
+type Header struct {
+	// JobName is the name of the job (JOB NAME, otherwise SET JOBNAME)
+	JobName string
+
+	// UserName is the name of the user who sent the job (SET USERNAME)
+	UserName string
+
+	// Copies is the number of copies (SET QTY, otherwise SET COPIES), 0 if not set
+	Copies int
+
+	// Duplex tells if both sides of the paper should be printed (SET DUPLEX)
+	Duplex bool
+
+	// Binding is the edge duplex pages are bound at, e.g. LONGEDGE or SHORTEDGE (SET BINDING)
+	Binding string
+
+	// Language is the printer language of the print data following the header,
+	// e.g. PCL or POSTSCRIPT (ENTER LANGUAGE)
+	Language string
+
+	// Variables contains the values of all SET commands by their upper case variable names
+	Variables map[string]string
+
+	// JobAttributes contains the job attributes sent by SET JOBATTR="@NAME=value" by their names
+	JobAttributes map[string]string
+
+	// Comments contains the texts of all COMMENT commands
+	Comments []string
+
+	// Size is the number of bytes of the header, so the print data starts at this offset
+	// if the header ends with an ENTER LANGUAGE command
+	Size int
+}
+
+// IsHeader tells if the data starts with a PJL header, optionally preceded by UEL commands.
+func IsHeader(data []byte) bool {
+	return bytes.HasPrefix(bytes.ToUpper(skipUEL(data)), []byte(prefix))
+}
+
+// Parse parses the PJL header at the start of data, which ends at the ENTER LANGUAGE command
+// or the first line which is no PJL command. An incomplete last line is ignored, so data
+// may contain only the first bytes of a data file (e.g. MaxHeaderSize bytes).
+// ErrNoHeader is returned if data does not start with a PJL header.
+func Parse(data []byte) (*Header, error) {
+	if !IsHeader(data) {
+		return nil, ErrNoHeader
+	}
+
+	header := &Header{Variables: map[string]string{}, JobAttributes: map[string]string{}}
+
+	for offset := 0; offset < len(data); {
+		rest := skipUEL(data[offset:])
+
+		end := bytes.IndexByte(rest, '\n')
+		if end < 0 {
+			break
+		}
+
+		line := strings.TrimRight(string(rest[:end]), "\r")
+		if !strings.HasPrefix(strings.ToUpper(line), prefix) {
+			break
+		}
+
+		offset = len(data) - len(rest) + end + 1
+		header.Size = offset
+
+		if header.parseCommand(strings.TrimSpace(line[len(prefix):])) {
+			break
+		}
+	}
+
+	if header.JobName == "" {
+		header.JobName = header.Variables["JOBNAME"]
+	}
+	if header.JobName == "" {
+		header.JobName = header.JobAttributes["JOBNAME"]
+	}
+	header.UserName = header.Variables["USERNAME"]
+	header.Duplex = strings.EqualFold(header.Variables["DUPLEX"], "ON")
+	header.Binding = strings.ToUpper(header.Variables["BINDING"])
+
+	for _, name := range []string{"QTY", "COPIES"} {
+		copies, err := strconv.Atoi(header.Variables[name])
+		if err == nil && copies > 0 {
+			header.Copies = copies
+			break
+		}
+	}
+
+	return header, nil
+}
+
+// parseCommand parses the given PJL command (without the @PJL prefix)
+// and tells if it was the last command of the header.
+func (h *Header) parseCommand(command string) bool {
+	name, arguments := splitWord(command)
+
+	switch strings.ToUpper(name) {
+	case "JOB":
+		for _, option := range parseOptions(arguments) {
+			if option.name == "NAME" {
+				h.JobName = option.value
+			}
+		}
+
+	case "SET", "DEFAULT":
+		// the variables of the default environment are set as well, as they apply to the job
+		if index := strings.Index(arguments, ":"); strings.HasPrefix(strings.ToUpper(arguments), "LPARM") && index >= 0 {
+			arguments = strings.TrimSpace(arguments[index+1:])
+		}
+
+		for _, option := range parseOptions(arguments) {
+			if option.name == "JOBATTR" {
+				h.addJobAttribute(option.value)
+				continue
+			}
+
+			h.Variables[option.name] = option.value
+		}
+
+	case "COMMENT":
+		h.Comments = append(h.Comments, arguments)
+
+	case "ENTER":
+		for _, option := range parseOptions(arguments) {
+			if option.name == "LANGUAGE" {
+				h.Language = strings.ToUpper(option.value)
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// addJobAttribute adds a job attribute of the form @NAME=value.
+func (h *Header) addJobAttribute(attribute string) {
+	name, value, found := strings.Cut(strings.TrimPrefix(attribute, "@"), "=")
+	if !found {
+		return
+	}
+
+	h.JobAttributes[strings.ToUpper(strings.TrimSpace(name))] = strings.TrimSpace(value)
+}
+
+// option is a name = value pair of a PJL command.
+type option struct {
+	name  string
+	value string
+}
+
+// parseOptions parses the name = value pairs of a PJL command, where values may be quoted.
+// Options without value (e.g. the keywords of INFO) are returned with an empty value.
+func parseOptions(arguments string) []option {
+	var options []option
+
+	for arguments = strings.TrimSpace(arguments); arguments != ""; arguments = strings.TrimSpace(arguments) {
+		index := strings.IndexAny(arguments, "= \t")
+		if index < 0 {
+			options = append(options, option{name: strings.ToUpper(arguments)})
+			break
+		}
+
+		name := strings.ToUpper(arguments[:index])
+		arguments = strings.TrimSpace(arguments[index:])
+		if !strings.HasPrefix(arguments, "=") {
+			options = append(options, option{name: name})
+			continue
+		}
+
+		var value string
+		arguments = strings.TrimSpace(arguments[1:])
+		if strings.HasPrefix(arguments, `"`) {
+			end := strings.IndexByte(arguments[1:], '"')
+			if end < 0 {
+				value, arguments = arguments[1:], ""
+			} else {
+				value, arguments = arguments[1:end+1], arguments[end+2:]
+			}
+		} else {
+			value, arguments = splitWord(arguments)
+		}
+
+		options = append(options, option{name: name, value: value})
+	}
+
+	return options
+}
+
+// splitWord returns the first word of s and the rest of s after the following white space.
+func splitWord(s string) (string, string) {
+	index := strings.IndexAny(s, " \t")
+	if index < 0 {
+		return s, ""
+	}
+
+	return s[:index], strings.TrimSpace(s[index:])
+}
+
+// skipUEL skips the UEL commands and white space at the start of data.
+func skipUEL(data []byte) []byte {
+	for {
+		trimmed := bytes.TrimLeft(data, " \t\r\n")
+		if bytes.HasPrefix(trimmed, []byte(UEL)) {
+			data = trimmed[len(UEL):]
+			continue
+		}
+
+		return trimmed
+	}
+}
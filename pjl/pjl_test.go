@@ -0,0 +1,60 @@
+package pjl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	data := UEL + "@PJL JOB NAME=\"Invoice 42\" DISPLAY=\"Printing\"\r\n" +
+		"@PJL COMMENT Windows driver\r\n" +
+		"@PJL SET USERNAME=\"jdoe\"\r\n" +
+		"@PJL SET JOBNAME = \"invoice.pdf\"\r\n" +
+		"@PJL SET COPIES=3\r\n" +
+		"@pjl set duplex=on\r\n" +
+		"@PJL SET BINDING=SHORTEDGE\r\n" +
+		"@PJL SET LPARM:PCL SYMSET=ROMAN8\r\n" +
+		"@PJL SET JOBATTR=\"@LUNA=jdoe\"\r\n" +
+		"@PJL ENTER LANGUAGE=PCL\r\n" +
+		"\x1bE@PJL SET COPIES=1\r\n"
+
+	header, err := Parse([]byte(data))
+	require.Nil(t, err)
+	require.Equal(t, "Invoice 42", header.JobName)
+	require.Equal(t, "jdoe", header.UserName)
+	require.Equal(t, 3, header.Copies)
+	require.True(t, header.Duplex)
+	require.Equal(t, "SHORTEDGE", header.Binding)
+	require.Equal(t, "PCL", header.Language)
+	require.Equal(t, "invoice.pdf", header.Variables["JOBNAME"])
+	require.Equal(t, "ROMAN8", header.Variables["SYMSET"])
+	require.Equal(t, map[string]string{"LUNA": "jdoe"}, header.JobAttributes)
+	require.Equal(t, []string{"Windows driver"}, header.Comments)
+	require.Equal(t, "\x1bE@PJL SET COPIES=1\r\n", data[header.Size:])
+
+	// QTY takes precedence over COPIES, the job name is taken from SET JOBNAME
+	header, err = Parse([]byte("@PJL\n@PJL SET COPIES=2\n@PJL SET QTY=5\n@PJL SET JOBNAME=report\n%!PS-Adobe-3.0\n"))
+	require.Nil(t, err)
+	require.Equal(t, 5, header.Copies)
+	require.Equal(t, "report", header.JobName)
+	require.False(t, header.Duplex)
+	require.Equal(t, "", header.Language)
+	require.Equal(t, 62, header.Size)
+
+	// an incomplete last line is ignored
+	header, err = Parse([]byte(UEL + "@PJL SET COPIES=2\n@PJL SET DUP"))
+	require.Nil(t, err)
+	require.Equal(t, 2, header.Copies)
+	require.Equal(t, map[string]string{"COPIES": "2"}, header.Variables)
+
+	_, err = Parse([]byte("%PDF-1.7\n"))
+	require.ErrorIs(t, err, ErrNoHeader)
+}
+
+func TestIsHeader(t *testing.T) {
+	require.True(t, IsHeader([]byte(UEL+UEL+"@PJL ENTER LANGUAGE=PDF\n")))
+	require.True(t, IsHeader([]byte("@pjl\r\n")))
+	require.False(t, IsHeader([]byte(UEL+"%!PS")))
+	require.False(t, IsHeader(nil))
+}